@@ -1,7 +1,18 @@
 package controller
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pejovski/wish-list/emitter"
 	"github.com/pejovski/wish-list/gateway/catalog"
+	"github.com/pejovski/wish-list/notifier"
+	"github.com/pejovski/wish-list/pkg/worker"
 	"github.com/pejovski/wish-list/repository"
 
 	myerr "github.com/pejovski/wish-list/error"
@@ -10,126 +21,827 @@ import (
 )
 
 type Controller interface {
-	UpdateProduct(productId string) error
-	DeactivateProduct(productId string) error
-	DeleteProduct(productId string) error
-	UpdateProductPrice(productId string, price float32) error
+	UpdateProduct(ctx context.Context, productId string) error
+	DeactivateProduct(ctx context.Context, productId string) error
+	ActivateProduct(ctx context.Context, productId string) error
+	UpdateProductAvailability(ctx context.Context, productId string, available bool) error
+	DeleteProduct(ctx context.Context, productId string) error
+	UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) error
+
+	EventProcessed(ctx context.Context, eventId string) (bool, error)
+	MarkEventProcessed(ctx context.Context, eventId string, retention time.Duration) error
+
+	AddItem(ctx context.Context, userId string, listId string, productId string) error
+	AddItemSync(ctx context.Context, userId string, listId string, productId string) (*model.Item, error)
+	RemoveItem(ctx context.Context, userId string, listId string, productId string) error
+	UpdateItemDetails(ctx context.Context, userId string, listId string, productId string, details *model.ItemDetails) error
+
+	GetLists(ctx context.Context, userId string) ([]*model.List, error)
+	GetList(ctx context.Context, userId string, listId string, query *model.ItemQuery) (*model.List, error)
+	GetDefaultList(ctx context.Context, userId string, query *model.ItemQuery) (*model.List, error)
+	EnsureDefaultList(ctx context.Context, userId string) (*model.List, error)
+	CreateList(ctx context.Context, userId string, name string) (*model.List, error)
+	RenameList(ctx context.Context, userId string, listId string, name string) error
+	DeleteList(ctx context.Context, userId string, listId string) error
+
+	ShareList(ctx context.Context, userId string, listId string) (*model.Share, error)
+	GetShares(ctx context.Context, userId string, listId string) ([]*model.Share, error)
+	RevokeShare(ctx context.Context, userId string, listId string, token string) error
+	GetSharedList(ctx context.Context, token string, query *model.ItemQuery) (*model.List, error)
+
+	ReserveItem(ctx context.Context, token string, productId string) (*model.Claim, error)
+	ReleaseItem(ctx context.Context, token string, productId string, claimId string) error
+	PurchaseItem(ctx context.Context, token string, productId string, claimId string) error
+
+	SetAlertRule(ctx context.Context, userId string, listId string, productId string, rule *model.AlertRule) error
+	GetAlerts(ctx context.Context, userId string) ([]*model.PriceAlert, error)
+
+	GetPriceHistory(ctx context.Context, productId string) ([]*model.PricePoint, error)
+
+	EraseUser(ctx context.Context, userId string, source string) (*model.Erasure, error)
+	GetErasures(ctx context.Context, userId string) ([]*model.Erasure, error)
+}
 
-	AddItem(userId string, productId string) error
-	RemoveItem(userId string, productId string) error
+const (
+	tokenLength    = 32
+	reservationTTL = 72 * time.Hour
 
-	GetList(userId string) (model.List, error)
-}
+	defaultPageSize = 100
+	maxPageSize     = 500
+
+	maxQuantity       = 99
+	maxNoteLength     = 500
+	maxVariantEntries = 10
+	maxVariantLength  = 50
+)
 
 type controller struct {
 	repository     repository.Repository
 	productGateway catalog.Gateway
+	notifier       notifier.Notifier
+	workers        worker.Pool
 }
 
-func New(r repository.Repository, g catalog.Gateway) Controller {
-	return controller{repository: r, productGateway: g}
+// New returns the controller, the events it stores in the outbox are published by the outbox relay
+func New(r repository.Repository, g catalog.Gateway, n notifier.Notifier, w worker.Pool) Controller {
+	return controller{repository: r, productGateway: g, notifier: n, workers: w}
 }
 
-func (c controller) AddItem(userId string, productId string) error {
+func (c controller) AddItem(ctx context.Context, userId string, listId string, productId string) error {
 
-	// get item from repo
-	item, err := c.repository.Item(userId, productId)
+	err := c.createItem(ctx, userId, listId, productId)
 	if err != nil {
-		logrus.Errorf("GetItem failed for product %s, user %s Error: %s", productId, userId, err)
 		return err
 	}
 
-	// check if item exist from repo
-	if item != nil {
-		logrus.Errorf("Item exist failure for product %s, user %s Error: %s", productId, userId, myerr.ErrItemAlreadyExist)
-		return myerr.ErrItemAlreadyExist
+	//update item async
+	err = c.workers.Submit(worker.Job{
+		Name: fmt.Sprintf("enrich product %s of list %s", productId, listId),
+		Run: func(ctx context.Context) error {
+			err := c.enrichItem(ctx, userId, listId, productId)
+			if err != nil && !myerr.IsTransient(err) {
+				return worker.Permanent(err)
+			}
+			return err
+		},
+		// if update failed or was aborted on shutdown, remove the item so it can be added again
+		// the request context is gone by then
+		Failed: func(error) {
+			c.deleteItem(context.Background(), listId, productId)
+		},
+	})
+	if err != nil {
+		logrus.Errorf("Enrich job rejected for product %s, list %s Error: %s", productId, listId, err)
+		c.deleteItem(ctx, listId, productId)
+		return myerr.ErrServiceBusy
+	}
+
+	return nil
+}
+
+// AddItemSync adds the item and waits for it to be enriched with the product data
+// the item is removed again if the product is unknown or the enrichment fails
+func (c controller) AddItemSync(ctx context.Context, userId string, listId string, productId string) (*model.Item, error) {
+
+	err := c.createItem(ctx, userId, listId, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.enrichItem(ctx, userId, listId, productId)
+	if err != nil {
+		// the request context could be the reason of the failure
+		c.deleteItem(context.Background(), listId, productId)
+		return nil, err
+	}
+
+	item, err := c.repository.Item(ctx, listId, productId)
+	if err != nil {
+		logrus.Errorf("GetItem failed for product %s, list %s Error: %s", productId, listId, err)
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// createItem creates a bare item which is enriched with the product data afterwards
+func (c controller) createItem(ctx context.Context, userId string, listId string, productId string) error {
+
+	// check if list exist for the user
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return err
 	}
 
-	// item doesn't exist so create a new one
-	err = c.repository.CreateItem(userId, productId)
+	// the repository inserts only if the item is absent, so concurrent adds can't both succeed
+	err := c.repository.CreateItem(ctx, userId, listId, productId)
+	if errors.Is(err, myerr.ErrItemAlreadyExist) {
+		logrus.Errorf("Item exist failure for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
 	if err != nil {
 		logrus.Errorf("CreateItem failed for product %s, user %s Error: %s", productId, userId, err)
 		return err
 	}
 
-	//update item async
-	updateFailed := make(chan bool)
-	go func(updateFiled chan bool) {
+	return nil
+}
+
+// enrichItem updates the item with product data from the repo or the catalog,
+// the wish_item_added event is stored with the update
+func (c controller) enrichItem(ctx context.Context, userId string, listId string, productId string) error {
+
+	// get product data from repo
+	product, err := c.repository.Product(ctx, productId)
+	if err != nil {
+		logrus.Errorf("Unexpected failure for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
 
-		// get product data from repo
-		product, err := c.repository.Product(productId)
+	// check if product exist from repo
+	if product != nil {
+		logrus.Infof("Product %s exist in some wish-list", productId)
+	} else {
+		// get product data from external domain
+		product, err = c.productGateway.Product(ctx, productId)
 		if err != nil {
-			logrus.Errorf("Unexpected failure for product %s, user %s Error: %s", productId, userId, err)
-			updateFiled <- true
-			return
+			logrus.Errorf("Get Product failed for product %s. Error: %s", productId, err)
+			return err
 		}
 
-		// check if product exist from repo
-		if product != nil {
-			logrus.Infof("Product %s exist in some wish-list", productId)
+		// unknown product can not be wish-listed
+		if product == nil {
+			logrus.Errorf("Product %s not found in catalog", productId)
+			return myerr.ErrProductNotFound
+		}
+
+		err = c.storeProduct(ctx, product)
+		if err != nil {
+			logrus.Errorf("UpdateProduct failed for product %s. Error: %s", productId, err)
+			return err
+		}
+	}
+
+	event, err := emitter.ItemAdded(userId, listId, product)
+	if err != nil {
+		logrus.Errorf("Event creation failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	// update item with product data
+	err = c.repository.UpdateItem(ctx, listId, product, event)
+	if err != nil {
+		logrus.Errorf("Unexpected failure for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
 
-			// update item with product data
-			err = c.repository.UpdateItem(userId, product)
+	return nil
+}
+
+func (c controller) RemoveItem(ctx context.Context, userId string, listId string, productId string) error {
+
+	// check if list exist for the user
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return err
+	}
+
+	err := c.workers.Submit(worker.Job{
+		Name: fmt.Sprintf("delete product %s of list %s", productId, listId),
+		Run: func(ctx context.Context) error {
+			event, err := emitter.ItemRemoved(userId, listId, productId)
 			if err != nil {
-				logrus.Errorf("Unexpected failure for product %s, user %s Error: %s", productId, userId, err)
-				updateFiled <- true
-				return
+				return err
 			}
 
-			// item was updated with product data
-			// no failure
-			updateFiled <- false
-			return
+			return c.repository.DeleteItem(ctx, listId, productId, event)
+		},
+	})
+	if err != nil {
+		logrus.Errorf("Delete job rejected for product %s, list %s Error: %s", productId, listId, err)
+		return myerr.ErrServiceBusy
+	}
+
+	return nil
+}
+
+// deleteItem removes an item whose enrichment failed
+func (c controller) deleteItem(ctx context.Context, listId string, productId string) {
+	// the item was never announced, so its removal isn't either
+	err := c.repository.DeleteItem(ctx, listId, productId, nil)
+	if err != nil {
+		logrus.Errorf("DeleteItem failed for product %s, list %s Error: %s", productId, listId, err)
+	}
+}
+
+func (c controller) UpdateItemDetails(ctx context.Context, userId string, listId string, productId string, details *model.ItemDetails) error {
+	if !validItemDetails(details) {
+		return myerr.ErrInvalidDetails
+	}
+
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return err
+	}
+
+	item, err := c.repository.Item(ctx, listId, productId)
+	if err != nil {
+		logrus.Errorf("GetItem failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	if item == nil {
+		return myerr.ErrItemNotFound
+	}
+
+	err = c.repository.UpdateItemDetails(ctx, listId, productId, details)
+	if err != nil {
+		logrus.Errorf("UpdateItemDetails failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetLists returns the stored lists of the user, the default list is stored on the first write to it
+func (c controller) GetLists(ctx context.Context, userId string) ([]*model.List, error) {
+
+	lists, err := c.repository.Lists(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Get Lists failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (c controller) GetList(ctx context.Context, userId string, listId string, query *model.ItemQuery) (*model.List, error) {
+	query, err := normalizeItemQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := c.list(ctx, userId, listId)
+	if err != nil {
+		return nil, err
+	}
+
+	list.Items, list.NextCursor, err = c.repository.Items(ctx, listId, query)
+	if err != nil {
+		logrus.Errorf("Get Items failed for list %s Error: %s", listId, err)
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetDefaultList reads the user's default list without creating it, a user without one gets an empty list
+func (c controller) GetDefaultList(ctx context.Context, userId string, query *model.ItemQuery) (*model.List, error) {
+	list, err := c.repository.DefaultList(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Get Default List failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	if list == nil {
+		if _, err := normalizeItemQuery(query); err != nil {
+			return nil, err
 		}
 
-		err = c.UpdateProduct(productId)
-		if err != nil {
-			logrus.Errorf("UpdateProduct async failed for product %s. Error: %s", productId, err)
-			updateFiled <- true
-			return
+		return &model.List{UserId: userId, Default: true, Items: model.Items{}}, nil
+	}
+
+	return c.GetList(ctx, userId, list.Id, query)
+}
+
+// EnsureDefaultList returns the user's default list for the writes to it, creating it on first access
+func (c controller) EnsureDefaultList(ctx context.Context, userId string) (*model.List, error) {
+	list, err := c.repository.EnsureDefaultList(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Ensure Default List failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (c controller) CreateList(ctx context.Context, userId string, name string) (*model.List, error) {
+	list, err := c.repository.CreateList(ctx, userId, name)
+	if err != nil {
+		logrus.Errorf("Create List %s failed for user %s Error: %s", name, userId, err)
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (c controller) RenameList(ctx context.Context, userId string, listId string, name string) error {
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return err
+	}
+
+	err := c.repository.RenameList(ctx, userId, listId, name)
+	if err != nil {
+		logrus.Errorf("Rename List failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (c controller) DeleteList(ctx context.Context, userId string, listId string) error {
+	list, err := c.list(ctx, userId, listId)
+	if err != nil {
+		return err
+	}
+
+	if list.Default {
+		logrus.Errorf("Delete List failed for list %s, user %s Error: %s", listId, userId, myerr.ErrDefaultListDelete)
+		return myerr.ErrDefaultListDelete
+	}
+
+	err = c.repository.DeleteList(ctx, userId, listId)
+	if err != nil {
+		logrus.Errorf("Delete List failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (c controller) ShareList(ctx context.Context, userId string, listId string) (*model.Share, error) {
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		logrus.Errorf("Share token generation failed for list %s Error: %s", listId, err)
+		return nil, err
+	}
+
+	share := &model.Share{
+		Token:     token,
+		UserId:    userId,
+		ListId:    listId,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	event, err := emitter.ListShared(share)
+	if err != nil {
+		logrus.Errorf("Event creation failed for share of list %s Error: %s", listId, err)
+		return nil, err
+	}
+
+	err = c.repository.CreateShare(ctx, share, event)
+	if err != nil {
+		logrus.Errorf("Create Share failed for list %s, user %s Error: %s", listId, userId, err)
+		return nil, err
+	}
+
+	return share, nil
+}
+
+func (c controller) GetShares(ctx context.Context, userId string, listId string) ([]*model.Share, error) {
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return nil, err
+	}
+
+	shares, err := c.repository.Shares(ctx, listId)
+	if err != nil {
+		logrus.Errorf("Get Shares failed for list %s, user %s Error: %s", listId, userId, err)
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+func (c controller) RevokeShare(ctx context.Context, userId string, listId string, token string) error {
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return err
+	}
+
+	err := c.repository.DeleteShare(ctx, listId, token)
+	if err != nil {
+		logrus.Errorf("Delete Share failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetSharedList returns the list behind a share token without the owner's private fields
+func (c controller) GetSharedList(ctx context.Context, token string, query *model.ItemQuery) (*model.List, error) {
+	share, err := c.repository.Share(ctx, token)
+	if err != nil {
+		logrus.Errorf("Get Share failed Error: %s", err)
+		return nil, err
+	}
+
+	if share == nil {
+		return nil, myerr.ErrShareNotFound
+	}
+
+	list, err := c.GetList(ctx, share.UserId, share.ListId, query)
+	if err != nil {
+		if errors.Is(err, myerr.ErrListNotFound) {
+			return nil, myerr.ErrShareNotFound
+		}
+		return nil, err
+	}
+
+	items := model.Items{}
+	for _, item := range list.Items {
+		items = append(items, sharedItem(item))
+	}
+
+	return &model.List{
+		Name:       list.Name,
+		Items:      items,
+		NextCursor: list.NextCursor,
+	}, nil
+}
+
+// sharedItem is the item as the visitors of a shared list see it.
+// The owner-private fields are left out: the note, the prices when added and since added,
+// and the alert rule. The reservation only has its public state, the claim id never leaves the repository.
+func sharedItem(item *model.Item) *model.Item {
+	shared := *item
+	shared.Note = ""
+	shared.PriceWhenAdded = 0
+	shared.LowestPriceSinceAdded = 0
+	shared.AlertRule = nil
+
+	return &shared
+}
+
+// ReserveItem claims an item of a shared list for the visitor holding the returned claim
+func (c controller) ReserveItem(ctx context.Context, token string, productId string) (*model.Claim, error) {
+	listId, err := c.sharedItem(ctx, token, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	claimId, err := newToken()
+	if err != nil {
+		logrus.Errorf("Claim generation failed for product %s, list %s Error: %s", productId, listId, err)
+		return nil, err
+	}
+
+	claim := &model.Claim{
+		Id:        claimId,
+		ProductId: productId,
+		ExpiresAt: time.Now().UTC().Add(reservationTTL),
+	}
+
+	reserved, err := c.repository.ReserveItem(ctx, listId, productId, claim.Id, claim.ExpiresAt)
+	if err != nil {
+		logrus.Errorf("Reserve Item failed for product %s, list %s Error: %s", productId, listId, err)
+		return nil, err
+	}
+
+	if !reserved {
+		return nil, myerr.ErrItemReserved
+	}
+
+	return claim, nil
+}
+
+func (c controller) ReleaseItem(ctx context.Context, token string, productId string, claimId string) error {
+	listId, err := c.sharedItem(ctx, token, productId)
+	if err != nil {
+		return err
+	}
+
+	released, err := c.repository.ReleaseItem(ctx, listId, productId, claimId)
+	if err != nil {
+		logrus.Errorf("Release Item failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	if !released {
+		return myerr.ErrClaimNotFound
+	}
+
+	return nil
+}
+
+func (c controller) PurchaseItem(ctx context.Context, token string, productId string, claimId string) error {
+	listId, err := c.sharedItem(ctx, token, productId)
+	if err != nil {
+		return err
+	}
+
+	purchased, err := c.repository.PurchaseItem(ctx, listId, productId, claimId)
+	if err != nil {
+		logrus.Errorf("Purchase Item failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	if !purchased {
+		return myerr.ErrClaimNotFound
+	}
+
+	return nil
+}
+
+// sharedItem returns the id of the shared list after checking the item is on it
+func (c controller) sharedItem(ctx context.Context, token string, productId string) (string, error) {
+	share, err := c.repository.Share(ctx, token)
+	if err != nil {
+		logrus.Errorf("Get Share failed Error: %s", err)
+		return "", err
+	}
+
+	if share == nil {
+		return "", myerr.ErrShareNotFound
+	}
+
+	item, err := c.repository.Item(ctx, share.ListId, productId)
+	if err != nil {
+		logrus.Errorf("GetItem failed for product %s, list %s Error: %s", productId, share.ListId, err)
+		return "", err
+	}
+
+	if item == nil {
+		return "", myerr.ErrItemNotFound
+	}
+
+	return share.ListId, nil
+}
+
+// SetAlertRule sets the price alert rule of the item, a nil rule removes it
+func (c controller) SetAlertRule(ctx context.Context, userId string, listId string, productId string, rule *model.AlertRule) error {
+	if _, err := c.list(ctx, userId, listId); err != nil {
+		return err
+	}
+
+	item, err := c.repository.Item(ctx, listId, productId)
+	if err != nil {
+		logrus.Errorf("GetItem failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	if item == nil {
+		return myerr.ErrItemNotFound
+	}
+
+	if rule != nil {
+		if !validAlertRule(rule) {
+			return myerr.ErrInvalidAlertRule
 		}
 
-		// item was updated with product data
-		// no failure
-		updateFiled <- false
-	}(updateFailed)
+		// percentage rules need a known price to compare with
+		if rule.DropPercentage > 0 && (item.Product == nil || item.Price <= 0) {
+			return myerr.ErrInvalidAlertRule
+		}
 
-	// waiting for updateFailed result in a goroutine
-	go func(updateFiled chan bool) {
-		// if update failed, remove the item
-		if <-updateFiled {
-			_ = c.RemoveItem(userId, productId)
+		if item.Product != nil {
+			rule.ReferencePrice = item.Price
 		}
-	}(updateFailed)
+	}
+
+	err = c.repository.SetAlertRule(ctx, listId, productId, rule)
+	if err != nil {
+		logrus.Errorf("SetAlertRule failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
 
 	return nil
 }
 
-func (c controller) RemoveItem(userId string, productId string) error {
-	go func() {
-		err := c.repository.DeleteItem(userId, productId)
+func (c controller) GetAlerts(ctx context.Context, userId string) ([]*model.PriceAlert, error) {
+	alerts, err := c.repository.Alerts(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Get Alerts failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// priceWatches returns the wish-listed copies of the product with alert rules
+// it must be called before the new price is stored so the old price is known
+func (c controller) priceWatches(ctx context.Context, productId string) ([]*model.PriceWatch, error) {
+	watches, err := c.repository.PriceWatches(ctx, productId)
+	if err != nil {
+		logrus.Errorf("PriceWatches failed for product %s. Error: %s", productId, err)
+		return nil, err
+	}
+
+	return watches, nil
+}
+
+// triggerAlerts records an alert for every watch whose threshold is crossed by the new price
+// and hands it off to the notifier
+func (c controller) triggerAlerts(ctx context.Context, watches []*model.PriceWatch, price float32) {
+	for _, w := range watches {
+		threshold := alertThreshold(w.Rule)
+		if !(w.Price > threshold && price <= threshold) {
+			continue
+		}
+
+		alertId, err := newToken()
 		if err != nil {
-			logrus.Errorf("DeleteProduct failed for product %s, user %s Error: %s", productId, userId, err)
+			logrus.Errorf("Alert id generation failed for product %s, user %s. Error: %s", w.ProductId, w.UserId, err)
+			continue
 		}
-	}()
 
-	return nil
+		alert := &model.PriceAlert{
+			Id:          alertId,
+			UserId:      w.UserId,
+			ListId:      w.ListId,
+			ProductId:   w.ProductId,
+			OldPrice:    w.Price,
+			NewPrice:    price,
+			TargetPrice: threshold,
+			CreatedAt:   time.Now().UTC(),
+		}
+
+		event, err := emitter.PriceAlertTriggered(alert)
+		if err != nil {
+			logrus.Errorf("Event creation failed for alert of product %s, user %s. Error: %s", w.ProductId, w.UserId, err)
+			continue
+		}
+
+		err = c.repository.CreateAlert(ctx, alert, event)
+		if err != nil {
+			logrus.Errorf("CreateAlert failed for product %s, user %s. Error: %s", w.ProductId, w.UserId, err)
+			continue
+		}
+
+		err = c.notifier.Notify(ctx, alert)
+		if err != nil {
+			logrus.Errorf("Notify failed for alert %s. Error: %s", alert.Id, err)
+		}
+	}
 }
 
-func (c controller) GetList(userId string) (model.List, error) {
-	list, err := c.repository.List(userId)
+// EraseUser deletes all wish list data of the user and returns the audit record of the erasure.
+// The price history of a product goes too when no other user has the product on a list.
+func (c controller) EraseUser(ctx context.Context, userId string, source string) (*model.Erasure, error) {
+	if userId == "" {
+		return nil, myerr.Wrap(myerr.ErrInvalidRequest, fmt.Errorf("missing user id"))
+	}
+
+	erasureId, err := newToken()
+	if err != nil {
+		logrus.Errorf("Erasure id generation failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	erasure := &model.Erasure{
+		Id:       erasureId,
+		UserId:   userId,
+		Source:   source,
+		ErasedAt: time.Now().UTC(),
+	}
+
+	err = c.repository.EraseUser(ctx, erasure)
+	if err != nil {
+		logrus.Errorf("Erase User failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	logrus.Infof("User %s erased by %s: %d lists, %d items, %d reservations, %d shares, %d alerts, %d price points, %d events",
+		userId, source, erasure.Lists, erasure.Items, erasure.Reservations, erasure.Shares, erasure.Alerts,
+		erasure.PricePoints, erasure.Events)
+
+	return erasure, nil
+}
+
+func (c controller) GetErasures(ctx context.Context, userId string) ([]*model.Erasure, error) {
+	erasures, err := c.repository.Erasures(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Get Erasures failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return erasures, nil
+}
+
+func (c controller) GetPriceHistory(ctx context.Context, productId string) ([]*model.PricePoint, error) {
+	points, err := c.repository.PriceHistory(ctx, productId)
+	if err != nil {
+		logrus.Errorf("Get PriceHistory failed for product %s. Error: %s", productId, err)
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// normalizeItemQuery validates the query and fills in the default sort and page size
+func normalizeItemQuery(query *model.ItemQuery) (*model.ItemQuery, error) {
+	q := model.ItemQuery{}
+	if query != nil {
+		q = *query
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = model.SortAdded
+	case model.SortRecent:
+		q.Sort = model.SortAdded
+		q.Desc = true
+	case model.SortAdded, model.SortPrice, model.SortName, model.SortPriority:
+	default:
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	if q.Limit == 0 {
+		q.Limit = defaultPageSize
+	}
+
+	if q.Limit < 0 || q.Limit > maxPageSize {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	return &q, nil
+}
+
+func validItemDetails(details *model.ItemDetails) bool {
+	if details.Quantity != nil && (*details.Quantity < 1 || *details.Quantity > maxQuantity) {
+		return false
+	}
+
+	if details.Priority != nil && *details.Priority != model.PriorityMustHave && *details.Priority != model.PriorityNiceToHave {
+		return false
+	}
+
+	if details.Note != nil && utf8.RuneCountInString(*details.Note) > maxNoteLength {
+		return false
+	}
+
+	if len(details.Variant) > maxVariantEntries {
+		return false
+	}
+
+	for k, v := range details.Variant {
+		if k == "" || utf8.RuneCountInString(k) > maxVariantLength || utf8.RuneCountInString(v) > maxVariantLength {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validAlertRule(rule *model.AlertRule) bool {
+	// exactly one of target price or drop percentage has to be set
+	if (rule.TargetPrice > 0) == (rule.DropPercentage > 0) {
+		return false
+	}
+
+	return rule.TargetPrice >= 0 && rule.DropPercentage >= 0 && rule.DropPercentage < 100
+}
+
+func alertThreshold(rule *model.AlertRule) float32 {
+	if rule.TargetPrice > 0 {
+		return rule.TargetPrice
+	}
+
+	return rule.ReferencePrice * (1 - rule.DropPercentage/100)
+}
+
+// list returns the list or ErrListNotFound if the user doesn't own it
+func (c controller) list(ctx context.Context, userId string, listId string) (*model.List, error) {
+	list, err := c.repository.List(ctx, userId, listId)
 	if err != nil {
-		logrus.Errorf("Get List failed for user %s Error: %s", userId, err)
+		logrus.Errorf("Get List failed for list %s, user %s Error: %s", listId, userId, err)
 		return nil, err
 	}
 
+	if list == nil {
+		return nil, myerr.ErrListNotFound
+	}
+
 	return list, nil
 }
 
-func (c controller) UpdateProduct(productId string) error {
+func (c controller) UpdateProduct(ctx context.Context, productId string) error {
 
 	// get product data from external domain
-	product, err := c.productGateway.Product(productId)
+	product, err := c.productGateway.Product(ctx, productId)
 	if err != nil {
 		logrus.Errorf("Get Product failed for product %s. Error: %s", productId, err)
 		return err
@@ -138,20 +850,33 @@ func (c controller) UpdateProduct(productId string) error {
 	// no product found
 	// product could has been deleted
 	if product == nil {
-		return c.DeactivateProduct(productId)
+		return c.DeactivateProduct(ctx, productId)
+	}
+
+	return c.storeProduct(ctx, product)
+}
+
+// storeProduct updates every wish-listed copy of the product with the catalog data
+func (c controller) storeProduct(ctx context.Context, product *model.Product) error {
+	watches, err := c.priceWatches(ctx, product.ProductId)
+	if err != nil {
+		return err
 	}
 
-	err = c.repository.UpdateProduct(product)
+	// the repository records a changed price of a wish-listed product in the price history
+	err = c.repository.UpdateProduct(ctx, product)
 	if err != nil {
-		logrus.Errorf("UpdateProduct failed for product %s. Error: %s", productId, err)
+		logrus.Errorf("UpdateProduct failed for product %s. Error: %s", product.ProductId, err)
 		return err
 	}
 
+	c.triggerAlerts(ctx, watches, product.Price)
+
 	return nil
 }
 
-func (c controller) DeactivateProduct(productId string) error {
-	err := c.repository.DeactivateProduct(productId)
+func (c controller) DeactivateProduct(ctx context.Context, productId string) error {
+	err := c.repository.DeactivateProduct(ctx, productId)
 	if err != nil {
 		logrus.Errorf("DeactivateProduct failed for product %s. Error: %s", productId, err)
 		return err
@@ -160,8 +885,27 @@ func (c controller) DeactivateProduct(productId string) error {
 	return nil
 }
 
-func (c controller) DeleteProduct(productId string) error {
-	err := c.repository.DeleteProduct(productId)
+func (c controller) ActivateProduct(ctx context.Context, productId string) error {
+	err := c.repository.ActivateProduct(ctx, productId)
+	if err != nil {
+		logrus.Errorf("ActivateProduct failed for product %s. Error: %s", productId, err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateProductAvailability reactivates products back in stock and deactivates unavailable ones
+func (c controller) UpdateProductAvailability(ctx context.Context, productId string, available bool) error {
+	if available {
+		return c.ActivateProduct(ctx, productId)
+	}
+
+	return c.DeactivateProduct(ctx, productId)
+}
+
+func (c controller) DeleteProduct(ctx context.Context, productId string) error {
+	err := c.repository.DeleteProduct(ctx, productId)
 	if err != nil {
 		logrus.Errorf("DeleteProduct failed for product %s. Error: %s", productId, err)
 		return err
@@ -170,12 +914,58 @@ func (c controller) DeleteProduct(productId string) error {
 	return nil
 }
 
-func (c controller) UpdateProductPrice(productId string, price float32) error {
-	err := c.repository.UpdateProductPrice(productId, price)
+// UpdateProductPrice applies the price set at the given time, a price older than the stored one is skipped
+// so events delivered out of order don't roll the price back
+func (c controller) UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) error {
+	watches, err := c.priceWatches(ctx, productId)
+	if err != nil {
+		return err
+	}
+
+	// the repository records the applied price in the price history, a product no list has gets no history
+	stale, err := c.repository.UpdateProductPrice(ctx, productId, price, at)
 	if err != nil {
 		logrus.Errorf("UpdateProductPrice failed for product %s. Error: %s", productId, err)
 		return err
 	}
 
+	if stale {
+		logrus.Warnf("Stale price %v of product %s set at %s skipped", price, productId, at)
+		return nil
+	}
+
+	c.triggerAlerts(ctx, watches, price)
+
 	return nil
 }
+
+func (c controller) EventProcessed(ctx context.Context, eventId string) (bool, error) {
+	processed, err := c.repository.EventProcessed(ctx, eventId)
+	if err != nil {
+		logrus.Errorf("EventProcessed failed for event %s. Error: %s", eventId, err)
+		return false, err
+	}
+
+	return processed, nil
+}
+
+// MarkEventProcessed remembers the event for the retention, redeliveries within it are skipped
+func (c controller) MarkEventProcessed(ctx context.Context, eventId string, retention time.Duration) error {
+	err := c.repository.MarkEventProcessed(ctx, eventId, time.Now().UTC().Add(retention))
+	if err != nil {
+		logrus.Errorf("MarkEventProcessed failed for event %s. Error: %s", eventId, err)
+		return err
+	}
+
+	return nil
+}
+
+// newToken returns an unguessable url safe token
+func newToken() (string, error) {
+	b := make([]byte, tokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
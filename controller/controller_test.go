@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	"github.com/pejovski/wish-list/pkg/worker"
+	"github.com/pejovski/wish-list/repository/memory"
+)
+
+const userId = "user-1"
+
+type fakeCatalog map[string]*model.Product
+
+func (c fakeCatalog) Product(ctx context.Context, id string) (*model.Product, error) {
+	return c[id], nil
+}
+
+func newTestController(t *testing.T, products ...*model.Product) (Controller, func()) {
+	t.Helper()
+
+	c := fakeCatalog{}
+	for _, p := range products {
+		c[p.ProductId] = p
+	}
+
+	w := worker.NewPool(worker.Config{Workers: 1, QueueSize: 10, MaxAttempts: 1, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	return New(memory.NewRepository(), c, nil, w), func() {
+		w.Shutdown(context.Background())
+	}
+}
+
+func TestGetSharedListLeavesOutPrivateFields(t *testing.T) {
+	ctx := context.Background()
+	c, cleanup := newTestController(t, &model.Product{ProductId: "p1", Name: "Galaxy", Price: 800})
+	defer cleanup()
+
+	list, err := c.CreateList(ctx, userId, "Birthday")
+	if err != nil {
+		t.Fatalf("CreateList failed: %s", err)
+	}
+
+	if _, err := c.AddItemSync(ctx, userId, list.Id, "p1"); err != nil {
+		t.Fatalf("AddItemSync failed: %s", err)
+	}
+
+	note := "the blue one"
+	if err := c.UpdateItemDetails(ctx, userId, list.Id, "p1", &model.ItemDetails{Note: &note}); err != nil {
+		t.Fatalf("UpdateItemDetails failed: %s", err)
+	}
+
+	if err := c.SetAlertRule(ctx, userId, list.Id, "p1", &model.AlertRule{TargetPrice: 700}); err != nil {
+		t.Fatalf("SetAlertRule failed: %s", err)
+	}
+
+	share, err := c.ShareList(ctx, userId, list.Id)
+	if err != nil {
+		t.Fatalf("ShareList failed: %s", err)
+	}
+
+	if _, err := c.ReserveItem(ctx, share.Token, "p1"); err != nil {
+		t.Fatalf("ReserveItem failed: %s", err)
+	}
+
+	shared, err := c.GetSharedList(ctx, share.Token, nil)
+	if err != nil {
+		t.Fatalf("GetSharedList failed: %s", err)
+	}
+
+	if shared.Id != "" || shared.UserId != "" {
+		t.Errorf("shared list reveals the owner: id %q, user %q", shared.Id, shared.UserId)
+	}
+	if len(shared.Items) != 1 {
+		t.Fatalf("shared list has %d items, want 1", len(shared.Items))
+	}
+
+	item := shared.Items[0]
+	if item.Note != "" {
+		t.Errorf("shared item has note %q", item.Note)
+	}
+	if item.PriceWhenAdded != 0 || item.LowestPriceSinceAdded != 0 {
+		t.Errorf("shared item has prices %v when added and %v since added", item.PriceWhenAdded, item.LowestPriceSinceAdded)
+	}
+	if item.AlertRule != nil {
+		t.Errorf("shared item has alert rule %+v", item.AlertRule)
+	}
+	if item.Reservation == nil || item.Reservation.Status != model.ReservationStatusReserved {
+		t.Errorf("shared item reservation is %+v, want reserved", item.Reservation)
+	}
+	if item.Name != "Galaxy" {
+		t.Errorf("shared item name is %q, want the product name", item.Name)
+	}
+
+	// the owner still sees everything
+	owned, err := c.GetList(ctx, userId, list.Id, nil)
+	if err != nil {
+		t.Fatalf("GetList failed: %s", err)
+	}
+	if owned.Items[0].Note != note || owned.Items[0].AlertRule == nil || owned.Items[0].PriceWhenAdded != 800 {
+		t.Errorf("owner item lost private fields: %+v", owned.Items[0])
+	}
+}
+
+// blockingCatalog answers only when the request is canceled
+type blockingCatalog struct {
+	called chan struct{}
+}
+
+func (c blockingCatalog) Product(ctx context.Context, id string) (*model.Product, error) {
+	c.called <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// an enrichment aborted on shutdown removes the bare item, so the product can be added again
+func TestAddItemAbortedOnShutdown(t *testing.T) {
+	ctx := context.Background()
+	r := memory.NewRepository()
+	catalog := blockingCatalog{called: make(chan struct{}, 2)}
+	w := worker.NewPool(worker.Config{Workers: 1, QueueSize: 10, MaxAttempts: 1})
+	c := New(r, catalog, nil, w)
+
+	list, err := c.CreateList(ctx, userId, "Birthday")
+	if err != nil {
+		t.Fatalf("CreateList failed: %s", err)
+	}
+
+	// the first enrichment holds the only worker, the second waits in the queue
+	for _, productId := range []string{"p1", "p2"} {
+		if err := c.AddItem(ctx, userId, list.Id, productId); err != nil {
+			t.Fatalf("AddItem failed: %s", err)
+		}
+	}
+	<-catalog.called
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+
+	for _, productId := range []string{"p1", "p2"} {
+		item, err := r.Item(ctx, list.Id, productId)
+		if err != nil {
+			t.Fatalf("Item failed: %s", err)
+		}
+		if item != nil {
+			t.Errorf("item %s left after its enrichment was aborted", productId)
+		}
+	}
+
+	if err := r.CreateItem(ctx, userId, list.Id, "p1"); errors.Is(err, myerr.ErrItemAlreadyExist) {
+		t.Error("product can't be added again after the aborted enrichment")
+	}
+}
+
+// recordingNotifier records the alerts it is notified of
+type recordingNotifier struct {
+	alerts []*model.PriceAlert
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert *model.PriceAlert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func TestPriceAlerts(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   model.AlertRule
+		prices []float32
+		// want are the new prices of the triggered alerts
+		want []float32
+	}{
+		{"target crossed", model.AlertRule{TargetPrice: 80}, []float32{90, 80}, []float32{80}},
+		{"target not reached", model.AlertRule{TargetPrice: 80}, []float32{90, 81}, nil},
+		{"percentage crossed", model.AlertRule{DropPercentage: 10}, []float32{95, 89}, []float32{89}},
+		{"percentage not reached", model.AlertRule{DropPercentage: 10}, []float32{95, 91}, nil},
+		{"no re-fire below target", model.AlertRule{TargetPrice: 80}, []float32{75, 70, 60}, []float32{75}},
+		{"fires again after going back above", model.AlertRule{TargetPrice: 80}, []float32{75, 85, 79}, []float32{75, 79}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			n := &recordingNotifier{}
+			w := worker.NewPool(worker.Config{Workers: 1, QueueSize: 10, MaxAttempts: 1})
+			defer w.Shutdown(ctx)
+			c := New(memory.NewRepository(), fakeCatalog{"p1": {ProductId: "p1", Name: "Galaxy", Price: 100}}, n, w)
+
+			list, err := c.CreateList(ctx, userId, "Birthday")
+			if err != nil {
+				t.Fatalf("CreateList failed: %s", err)
+			}
+			if _, err := c.AddItemSync(ctx, userId, list.Id, "p1"); err != nil {
+				t.Fatalf("AddItemSync failed: %s", err)
+			}
+
+			rule := tt.rule
+			if err := c.SetAlertRule(ctx, userId, list.Id, "p1", &rule); err != nil {
+				t.Fatalf("SetAlertRule failed: %s", err)
+			}
+
+			at := time.Now().UTC()
+			for i, price := range tt.prices {
+				if err := c.UpdateProductPrice(ctx, "p1", price, at.Add(time.Duration(i+1)*time.Second)); err != nil {
+					t.Fatalf("UpdateProductPrice failed: %s", err)
+				}
+			}
+
+			if len(n.alerts) != len(tt.want) {
+				t.Fatalf("got %d alerts, want %d", len(n.alerts), len(tt.want))
+			}
+			for i, alert := range n.alerts {
+				if alert.NewPrice != tt.want[i] {
+					t.Errorf("alert %d new price = %v, want %v", i, alert.NewPrice, tt.want[i])
+				}
+			}
+
+			alerts, err := c.GetAlerts(ctx, userId)
+			if err != nil {
+				t.Fatalf("GetAlerts failed: %s", err)
+			}
+			if len(alerts) != len(tt.want) {
+				t.Errorf("stored %d alerts, want %d", len(alerts), len(tt.want))
+			}
+		})
+	}
+}
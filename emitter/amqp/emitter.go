@@ -0,0 +1,151 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	emt "github.com/pejovski/wish-list/emitter"
+	"github.com/pejovski/wish-list/model"
+	"github.com/pejovski/wish-list/pkg/rabbitmq"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const (
+	exKind = "fanout"
+
+	headerSchemaVersion = "x-schema-version"
+)
+
+var errNotConfirmed = errors.New("publish not confirmed by rabbitmq")
+
+// emitter publishes every event type to its fanout exchange, like the catalog publishes the product events.
+// It publishes on its own channel in confirm mode, an event counts as emitted only once RabbitMQ confirms it.
+type emitter struct {
+	// openChannel opens a new channel on the connection
+	openChannel func() (confirmChannel, error)
+
+	mu       sync.Mutex
+	ch       confirmChannel
+	confirms chan amqp.Confirmation
+}
+
+// confirmChannel is the part of the channel the events are published on in confirm mode
+type confirmChannel interface {
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+func NewEmitter(conn rabbitmq.Connection) emt.Emitter {
+	e := &emitter{
+		openChannel: func() (confirmChannel, error) {
+			ch, err := conn.Open()
+			if err != nil {
+				return nil, err
+			}
+			return ch, nil
+		},
+	}
+	conn.Setup(e.declare)
+
+	return e
+}
+
+func (e *emitter) declare(ch *amqp.Channel) error {
+	for _, ex := range emt.Types {
+		err := ch.ExchangeDeclare(
+			ex,
+			exKind,
+			true,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			logrus.Errorf("%s %s: %s", "Failed to declare an exchange", ex, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *emitter) Emit(ctx context.Context, event *model.Event) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := e.open(); err != nil {
+		logrus.Errorf("Failed to open channel for %s event %s. Error: %s", event.Type, event.Id, err)
+		return err
+	}
+
+	err = e.ch.Publish(event.Type, "", false, false, amqp.Publishing{
+		Headers:      amqp.Table{headerSchemaVersion: int32(event.Version)},
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.Id,
+		Timestamp:    event.OccurredAt,
+		Type:         event.Type,
+		Body:         body,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to publish %s event %s. Error: %s", event.Type, event.Id, err)
+		e.close()
+		return err
+	}
+
+	select {
+	case c, ok := <-e.confirms:
+		if !ok || !c.Ack {
+			logrus.Errorf("Publish of %s event %s not confirmed", event.Type, event.Id)
+			e.close()
+			return errNotConfirmed
+		}
+	case <-ctx.Done():
+		// the confirm can't be matched to the next publish anymore
+		e.close()
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// open opens the confirm channel unless it's open
+func (e *emitter) open() error {
+	if e.ch != nil {
+		return nil
+	}
+
+	ch, err := e.openChannel()
+	if err != nil {
+		return err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		return err
+	}
+
+	e.ch = ch
+	e.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	return nil
+}
+
+func (e *emitter) close() {
+	if e.ch != nil {
+		_ = e.ch.Close()
+	}
+	e.ch = nil
+	e.confirms = nil
+}
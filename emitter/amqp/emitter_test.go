@@ -0,0 +1,129 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	emt "github.com/pejovski/wish-list/emitter"
+	"github.com/streadway/amqp"
+)
+
+// fakeChannel records the publishings and confirms them with ack
+type fakeChannel struct {
+	ack       bool
+	confirms  chan amqp.Confirmation
+	published map[string][]amqp.Publishing
+	keys      []string
+	closed    bool
+}
+
+func (ch *fakeChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (ch *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.confirms = confirm
+	return confirm
+}
+
+func (ch *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if ch.published == nil {
+		ch.published = map[string][]amqp.Publishing{}
+	}
+
+	ch.published[exchange] = append(ch.published[exchange], msg)
+	ch.keys = append(ch.keys, key)
+	ch.confirms <- amqp.Confirmation{DeliveryTag: uint64(len(ch.keys)), Ack: ch.ack}
+	return nil
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.closed = true
+	return nil
+}
+
+func newTestEmitter(ch *fakeChannel) *emitter {
+	return &emitter{openChannel: func() (confirmChannel, error) {
+		return ch, nil
+	}}
+}
+
+func TestEmitPublishesEnvelope(t *testing.T) {
+	ch := &fakeChannel{ack: true}
+	e := newTestEmitter(ch)
+
+	event, err := emt.ItemRemoved("user-1", "list-1", "p1")
+	if err != nil {
+		t.Fatalf("ItemRemoved failed: %s", err)
+	}
+
+	if err := e.Emit(context.Background(), event); err != nil {
+		t.Fatalf("Emit failed: %s", err)
+	}
+
+	msgs := ch.published[emt.TypeWishItemRemoved]
+	if len(msgs) != 1 {
+		t.Fatalf("published %v, want one message to the %s exchange", ch.published, emt.TypeWishItemRemoved)
+	}
+	if ch.keys[0] != "" {
+		t.Errorf("routing key = %q, want none on a fanout exchange", ch.keys[0])
+	}
+
+	msg := msgs[0]
+	if v := msg.Headers[headerSchemaVersion]; v != int32(event.Version) {
+		t.Errorf("%s header = %v, want %d", headerSchemaVersion, v, event.Version)
+	}
+	if msg.MessageId != event.Id || msg.Type != event.Type || !msg.Timestamp.Equal(event.OccurredAt) {
+		t.Errorf("message id %q, type %q, timestamp %s, want the event %+v", msg.MessageId, msg.Type, msg.Timestamp, event)
+	}
+	if msg.ContentType != "application/json" || msg.DeliveryMode != amqp.Persistent {
+		t.Errorf("content type %q, delivery mode %d, want persistent json", msg.ContentType, msg.DeliveryMode)
+	}
+
+	var envelope struct {
+		Id         string          `json:"id"`
+		Type       string          `json:"type"`
+		Version    int             `json:"version"`
+		OccurredAt string          `json:"occurred_at"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Body, &envelope); err != nil {
+		t.Fatalf("Failed to decode body %s: %s", msg.Body, err)
+	}
+	if envelope.Id != event.Id || envelope.Type != event.Type || envelope.Version != event.Version || envelope.OccurredAt == "" {
+		t.Errorf("envelope = %+v, want the event %+v", envelope, event)
+	}
+
+	var data map[string]string
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		t.Fatalf("Failed to decode data %s: %s", envelope.Data, err)
+	}
+	want := map[string]string{"user_id": "user-1", "list_id": "list-1", "product_id": "p1"}
+	if len(data) != len(want) {
+		t.Errorf("data = %v, want %v", data, want)
+	}
+	for k, v := range want {
+		if data[k] != v {
+			t.Errorf("data[%s] = %q, want %q", k, data[k], v)
+		}
+	}
+}
+
+// a nack fails the emit and the channel is reopened for the next one
+func TestEmitNotConfirmed(t *testing.T) {
+	ch := &fakeChannel{ack: false}
+	e := newTestEmitter(ch)
+
+	event, err := emt.ItemRemoved("user-1", "list-1", "p1")
+	if err != nil {
+		t.Fatalf("ItemRemoved failed: %s", err)
+	}
+
+	if err := e.Emit(context.Background(), event); err != errNotConfirmed {
+		t.Fatalf("Emit = %v, want %v", err, errNotConfirmed)
+	}
+	if !ch.closed || e.ch != nil {
+		t.Error("channel kept open after the nack")
+	}
+}
@@ -0,0 +1,15 @@
+package emitter
+
+import (
+	"context"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+// Emitter publishes the wish list domain events for the other services to react on.
+// The controller stores the events in the outbox with the change, the outbox relay emits them.
+// The controller doesn't call Emit, a publish of its own couldn't share the transaction of the change
+// and would be lost or duplicated by a crash in between.
+type Emitter interface {
+	Emit(ctx context.Context, event *model.Event) error
+}
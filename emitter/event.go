@@ -0,0 +1,110 @@
+package emitter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+const (
+	TypeWishItemAdded       = "wish_item_added"
+	TypeWishItemRemoved     = "wish_item_removed"
+	TypeWishListShared      = "wish_list_shared"
+	TypePriceAlertTriggered = "price_alert_triggered"
+
+	// SchemaVersion is the version of the event schemas in app/events
+	SchemaVersion = 1
+)
+
+// Types are the events the wish list emits
+var Types = []string{TypeWishItemAdded, TypeWishItemRemoved, TypeWishListShared, TypePriceAlertTriggered}
+
+type itemAdded struct {
+	UserId    string  `json:"user_id"`
+	ListId    string  `json:"list_id"`
+	ProductId string  `json:"product_id"`
+	Price     float32 `json:"price"`
+}
+
+type itemRemoved struct {
+	UserId    string `json:"user_id"`
+	ListId    string `json:"list_id"`
+	ProductId string `json:"product_id"`
+}
+
+// listShared leaves the share token out, it grants access to the list
+type listShared struct {
+	UserId   string    `json:"user_id"`
+	ListId   string    `json:"list_id"`
+	SharedAt time.Time `json:"shared_at"`
+}
+
+type priceAlertTriggered struct {
+	AlertId     string  `json:"alert_id"`
+	UserId      string  `json:"user_id"`
+	ListId      string  `json:"list_id"`
+	ProductId   string  `json:"product_id"`
+	OldPrice    float32 `json:"old_price"`
+	NewPrice    float32 `json:"new_price"`
+	TargetPrice float32 `json:"target_price"`
+}
+
+func ItemAdded(userId string, listId string, product *model.Product) (*model.Event, error) {
+	return newEvent(TypeWishItemAdded, itemAdded{
+		UserId:    userId,
+		ListId:    listId,
+		ProductId: product.ProductId,
+		Price:     product.Price,
+	})
+}
+
+func ItemRemoved(userId string, listId string, productId string) (*model.Event, error) {
+	return newEvent(TypeWishItemRemoved, itemRemoved{
+		UserId:    userId,
+		ListId:    listId,
+		ProductId: productId,
+	})
+}
+
+func ListShared(share *model.Share) (*model.Event, error) {
+	return newEvent(TypeWishListShared, listShared{
+		UserId:   share.UserId,
+		ListId:   share.ListId,
+		SharedAt: share.CreatedAt,
+	})
+}
+
+func PriceAlertTriggered(alert *model.PriceAlert) (*model.Event, error) {
+	return newEvent(TypePriceAlertTriggered, priceAlertTriggered{
+		AlertId:     alert.Id,
+		UserId:      alert.UserId,
+		ListId:      alert.ListId,
+		ProductId:   alert.ProductId,
+		OldPrice:    alert.OldPrice,
+		NewPrice:    alert.NewPrice,
+		TargetPrice: alert.TargetPrice,
+	})
+}
+
+func newEvent(t string, data interface{}) (*model.Event, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Event{
+		Id:         hex.EncodeToString(b),
+		Type:       t,
+		Version:    SchemaVersion,
+		OccurredAt: time.Now().UTC(),
+		Data:       body,
+	}, nil
+}
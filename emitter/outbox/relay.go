@@ -0,0 +1,122 @@
+package outbox
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/pejovski/wish-list/emitter"
+	"github.com/pejovski/wish-list/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Store is the outbox of the repository
+type Store interface {
+	PendingEvents(ctx context.Context, limit int) ([]*model.Event, error)
+	MarkEventsPublished(ctx context.Context, events []*model.Event) error
+	OutboxStats(ctx context.Context) (*model.OutboxStats, error)
+}
+
+// Relay publishes the outbox events in the order they occurred.
+//
+// An event leaves the outbox only after it's published, a crash in between publishes it again,
+// so the delivery is at-least-once and the consumers skip the redeliveries by the event id.
+type Relay interface {
+	// Run relays until the context is done
+	Run(ctx context.Context)
+}
+
+type Config struct {
+	// Interval is the pause after the outbox is drained or a publish failed
+	Interval  time.Duration
+	BatchSize int
+}
+
+// metrics are served on /metrics with the other expvars
+var (
+	metrics    = expvar.NewMap("outbox")
+	pending    = new(expvar.Int)
+	lagSeconds = new(expvar.Float)
+	published  = new(expvar.Int)
+	failures   = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("pending", pending)
+	metrics.Set("lag_seconds", lagSeconds)
+	metrics.Set("published", published)
+	metrics.Set("failures", failures)
+}
+
+type relay struct {
+	store   Store
+	emitter emitter.Emitter
+	config  Config
+}
+
+func NewRelay(s Store, e emitter.Emitter, c Config) Relay {
+	return relay{store: s, emitter: e, config: c}
+}
+
+func (r relay) Run(ctx context.Context) {
+	for {
+		r.drain(ctx)
+		r.measure(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.config.Interval):
+		}
+	}
+}
+
+// drain publishes batches until the outbox is empty or a publish fails,
+// a failed event stops the batch so the later events don't overtake it
+func (r relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := r.store.PendingEvents(ctx, r.config.BatchSize)
+		if err != nil {
+			failures.Add(1)
+			return
+		}
+
+		sent := []*model.Event{}
+		for _, e := range events {
+			if err := r.emitter.Emit(ctx, e); err != nil {
+				logrus.Errorf("Emit failed for %s event %s, retrying in %s. Error: %s", e.Type, e.Id, r.config.Interval, err)
+				failures.Add(1)
+				break
+			}
+			sent = append(sent, e)
+		}
+
+		if len(sent) > 0 {
+			// the events stay in the outbox if this fails and are published again
+			if err := r.store.MarkEventsPublished(ctx, sent); err != nil {
+				failures.Add(1)
+				return
+			}
+			published.Add(int64(len(sent)))
+		}
+
+		if len(sent) < r.config.BatchSize {
+			return
+		}
+	}
+}
+
+// measure updates the outbox lag, the age of the oldest pending event
+func (r relay) measure(ctx context.Context) {
+	stats, err := r.store.OutboxStats(ctx)
+	if err != nil {
+		return
+	}
+
+	pending.Set(int64(stats.Pending))
+	if stats.Oldest.IsZero() {
+		lagSeconds.Set(0)
+		return
+	}
+	lagSeconds.Set(time.Since(stats.Oldest).Seconds())
+}
@@ -0,0 +1,165 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+// fakeStore is an outbox in memory
+type fakeStore struct {
+	mu        sync.Mutex
+	pending   []*model.Event
+	published []string
+}
+
+func (s *fakeStore) PendingEvents(ctx context.Context, limit int) ([]*model.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) < limit {
+		limit = len(s.pending)
+	}
+
+	return append([]*model.Event{}, s.pending[:limit]...), nil
+}
+
+func (s *fakeStore) MarkEventsPublished(ctx context.Context, events []*model.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range events {
+		s.published = append(s.published, e.Id)
+		for i, p := range s.pending {
+			if p.Id == e.Id {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *fakeStore) OutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &model.OutboxStats{Pending: len(s.pending)}, nil
+}
+
+func (s *fakeStore) state() ([]string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.published...), len(s.pending)
+}
+
+// fakeEmitter fails the events in failures once, a publish is confirmed when Emit returns nil
+type fakeEmitter struct {
+	store     *fakeStore
+	failures  map[string]bool
+	confirmed []string
+}
+
+func (e *fakeEmitter) Emit(ctx context.Context, event *model.Event) error {
+	// an event marked published before its publish is confirmed could be lost
+	if published, _ := e.store.state(); contains(published, event.Id) {
+		return errors.New("event " + event.Id + " is published already")
+	}
+
+	if e.failures[event.Id] {
+		delete(e.failures, event.Id)
+		return errors.New("publish not confirmed")
+	}
+
+	e.confirmed = append(e.confirmed, event.Id)
+	return nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func events(ids ...string) []*model.Event {
+	list := []*model.Event{}
+	for _, id := range ids {
+		list = append(list, &model.Event{Id: id, Type: "wish_item_added", Version: 1, OccurredAt: time.Now()})
+	}
+
+	return list
+}
+
+func TestDrainMarksOnlyConfirmedEvents(t *testing.T) {
+	s := &fakeStore{pending: events("e1", "e2", "e3")}
+	e := &fakeEmitter{store: s, failures: map[string]bool{"e2": true}}
+	r := relay{store: s, emitter: e, config: Config{Interval: time.Millisecond, BatchSize: 10}}
+
+	r.drain(context.Background())
+
+	published, pending := s.state()
+	if len(published) != 1 || published[0] != "e1" {
+		t.Fatalf("published %v after the failed publish, want only e1", published)
+	}
+	if pending != 2 {
+		t.Fatalf("%d events pending, want e2 and e3 to wait behind the failed one", pending)
+	}
+
+	r.drain(context.Background())
+
+	published, pending = s.state()
+	if pending != 0 {
+		t.Fatalf("%d events pending after the retry", pending)
+	}
+	want := []string{"e1", "e2", "e3"}
+	if len(published) != len(want) || len(e.confirmed) != len(want) {
+		t.Fatalf("published %v, confirmed %v, want %v", published, e.confirmed, want)
+	}
+	for i, id := range want {
+		if published[i] != id || e.confirmed[i] != id {
+			t.Fatalf("published %v, confirmed %v, want %v in order", published, e.confirmed, want)
+		}
+	}
+}
+
+func TestRunRetriesFailedPublish(t *testing.T) {
+	s := &fakeStore{pending: events("e1", "e2", "e3")}
+	e := &fakeEmitter{store: s, failures: map[string]bool{"e1": true}}
+	r := NewRelay(s, e, Config{Interval: time.Millisecond, BatchSize: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, pending := s.state(); pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the outbox was not drained after the failed publish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	published, _ := s.state()
+	if len(published) != 3 || published[0] != "e1" {
+		t.Errorf("published %v, want e1 first and every event once", published)
+	}
+}
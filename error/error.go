@@ -1,7 +1,101 @@
 package error
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
+
+// Kind classifies an error, the server and the receiver decide their outcome by it
+type Kind int
+
+const (
+	// KindUnknown is an unclassified failure, e.g. of the storage, it is assumed transient
+	KindUnknown Kind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	// KindUnavailable is a dependency which is down or too slow, it is transient
+	KindUnavailable
+	// KindBusy is the service shedding load, it is transient
+	KindBusy
+	// KindPermanent is a failure which fails the same way again, e.g. a malformed message
+	KindPermanent
+)
 
 var (
-	ErrItemAlreadyExist = errors.New("item already exist")
+	ErrItemAlreadyExist   = New(KindConflict, "item already exist")
+	ErrListNotFound       = New(KindNotFound, "list not found")
+	ErrDefaultListDelete  = New(KindConflict, "default list can not be deleted")
+	ErrShareNotFound      = New(KindNotFound, "share not found")
+	ErrItemNotFound       = New(KindNotFound, "item not found")
+	ErrItemReserved       = New(KindConflict, "item already reserved")
+	ErrClaimNotFound      = New(KindNotFound, "claim not found")
+	ErrInvalidAlertRule   = New(KindValidation, "invalid alert rule")
+	ErrInvalidDetails     = New(KindValidation, "invalid item details")
+	ErrInvalidQuery       = New(KindValidation, "invalid item query")
+	ErrInvalidRequest     = New(KindValidation, "invalid request")
+	ErrInvalidMessage     = New(KindPermanent, "invalid message")
+	ErrProductNotFound    = New(KindNotFound, "product not found")
+	ErrCatalogUnavailable = New(KindUnavailable, "catalog unavailable")
+	ErrServiceBusy        = New(KindBusy, "service busy")
 )
+
+// Error is a classified error, the variables above are compared with errors.Is
+type Error struct {
+	Kind    Kind
+	Message string
+	// Err is the cause, it is not shown to the clients
+	Err error
+}
+
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Wrap returns the error with the cause attached, errors.Is still matches the error
+func Wrap(e *Error, cause error) error {
+	return &Error{Kind: e.Kind, Message: e.Message, Err: cause}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches a wrapped error with the error it wraps
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Message == e.Message
+}
+
+// KindOf returns the kind of the first classified error in the chain,
+// a timed out context is a dependency which is too slow
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return KindUnavailable
+	}
+
+	return KindUnknown
+}
+
+// IsTransient tells if trying again later could succeed
+func IsTransient(err error) bool {
+	switch KindOf(err) {
+	case KindUnknown, KindUnavailable, KindBusy:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,21 @@
+package factory
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+const boltTimeout = 2 * time.Second
+
+func CreateBoltDB(path string) *bbolt.DB {
+	// the file is locked by a single process, the timeout stops waiting for another instance
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: boltTimeout})
+	if err != nil {
+		logrus.Fatalf("Failed to open bolt db %s: %s", path, err)
+	}
+
+	logrus.Infof("Opened bolt db %s", path)
+	return db
+}
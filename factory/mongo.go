@@ -11,7 +11,8 @@ import (
 const mongoTimeout = 2 * time.Second
 
 func CreateMongoClient(uri string) *mongo.Client {
-	ctx, _ := context.WithTimeout(context.Background(), mongoTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
 
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
@@ -0,0 +1,30 @@
+package factory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const postgresTimeout = 2 * time.Second
+
+func CreatePostgresDB(dsn string) *sql.DB {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logrus.Fatalln("Failed to open Postgres", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		logrus.Fatalln("Failed to ping Postgres", err)
+	}
+
+	logrus.Infoln("Connected to Postgres!")
+	return db
+}
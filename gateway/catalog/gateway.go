@@ -1,56 +1,70 @@
 package catalog
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/go-retryablehttp"
+	myerr "github.com/pejovski/wish-list/error"
 	"github.com/pejovski/wish-list/model"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type Gateway interface {
-	Product(id string) (*model.Product, error)
+	Product(ctx context.Context, id string) (*model.Product, error)
 }
 
 type gateway struct {
 	client *retryablehttp.Client
 	host   string
+	// timeout bounds a call including its retries on top of the caller's context
+	timeout time.Duration
 }
 
-func NewGateway(c *retryablehttp.Client, host string) Gateway {
-	return gateway{client: c, host: host}
+func NewGateway(c *retryablehttp.Client, host string, timeout time.Duration) Gateway {
+	return gateway{client: c, host: host, timeout: timeout}
 }
 
-func (g gateway) Product(id string) (*model.Product, error) {
+func (g gateway) Product(ctx context.Context, id string) (*model.Product, error) {
 
 	url := g.host + fmt.Sprintf("/products/%s", id)
 
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
 	req, err := retryablehttp.NewRequest("GET", url, nil)
 	if err != nil {
 		logrus.Errorln("Failed to create new request", err)
 		return nil, err
 	}
 
-	res, err := g.client.Do(req)
+	res, err := g.client.Do(req.WithContext(ctx))
 	if err != nil {
 		logrus.Errorln("Failed to Do request", err)
-		return nil, err
+		return nil, myerr.Wrap(myerr.ErrCatalogUnavailable, err)
+	}
+
+	defer res.Body.Close()
+
+	// product doesn't exist in the catalog
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
 	}
 
 	if res.StatusCode != http.StatusOK {
 		logrus.Errorln(ErrorNotOk, strconv.Itoa(res.StatusCode))
-		return nil, ErrorNotOk
+		return nil, myerr.Wrap(myerr.ErrCatalogUnavailable, ErrorNotOk)
 	}
 
 	var p *Product
 	err = json.NewDecoder(res.Body).Decode(&p)
 	if err != nil {
 		logrus.Errorln("Failed to Decode", err)
-		return nil, err
+		return nil, myerr.Wrap(myerr.ErrCatalogUnavailable, err)
 	}
-	defer res.Body.Close()
 
 	return g.mapProductToDomainProduct(p), nil
 }
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"github.com/pejovski/wish-list/pkg/rabbitmq"
 	"github.com/pejovski/wish-list/pkg/signals"
+	"github.com/pejovski/wish-list/pkg/worker"
+	"github.com/pejovski/wish-list/repository"
+	boltRepository "github.com/pejovski/wish-list/repository/bolt"
+	memoryRepository "github.com/pejovski/wish-list/repository/memory"
 	mongo2 "github.com/pejovski/wish-list/repository/mongo"
+	postgresRepository "github.com/pejovski/wish-list/repository/postgres"
 	"github.com/pejovski/wish-list/server/api"
 	"os"
 	"time"
@@ -11,11 +20,38 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/pejovski/wish-list/controller"
+	amqpEmitter "github.com/pejovski/wish-list/emitter/amqp"
+	"github.com/pejovski/wish-list/emitter/outbox"
 	"github.com/pejovski/wish-list/factory"
 	"github.com/pejovski/wish-list/gateway/catalog"
+	logNotifier "github.com/pejovski/wish-list/notifier/log"
 	"github.com/pejovski/wish-list/pkg/logger"
 	amqpReceiver "github.com/pejovski/wish-list/receiver/amqp"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	backendMongo    = "mongo"
+	backendBolt     = "bolt"
+	backendMemory   = "memory"
+	backendPostgres = "postgres"
+
+	defaultBoltPath = "wish-list.db"
+
+	postgresMigrateTimeout = 30 * time.Second
+
+	migrateCommand     = "migrate"
+	deadLettersCommand = "dead-letters"
+
+	deadLettersListLimit = 100
+	// deadLettersConnectTimeout is how long the dead-letters command waits for RabbitMQ
+	deadLettersConnectTimeout = 30 * time.Second
+
+	defaultDedupRetention = time.Hour
+
+	// defaultCatalogTimeout bounds a catalog call including its retries
+	defaultCatalogTimeout = 5 * time.Second
 )
 
 const (
@@ -23,44 +59,258 @@ const (
 	mongoShutdownTimeout  = 2 * time.Second
 )
 
+var outboxRelayConfig = outbox.Config{
+	Interval:  time.Second,
+	BatchSize: 100,
+}
+
+var enrichWorkerConfig = worker.Config{
+	Workers:     10,
+	QueueSize:   100,
+	MaxAttempts: 3,
+	Backoff:     200 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
 func init() {
 	initLogger()
 }
 
 func main() {
-	mongoClient := factory.CreateMongoClient(fmt.Sprintf(
-		"mongodb://%s:%s",
-		os.Getenv("MONGO_HOST"),
-		os.Getenv("MONGO_PORT"),
-	))
+	if len(os.Args) > 1 && os.Args[1] == migrateCommand {
+		migrate()
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == deadLettersCommand {
+		deadLetters(os.Args[2:])
+		return
+	}
+
+	wishRepository, closeRepository := createRepository()
+	defer closeRepository()
+
+	catalogGateway := catalog.NewGateway(
+		retryablehttp.NewClient(),
+		os.Getenv("CATALOG_API_HOST"),
+		envDuration("CATALOG_TIMEOUT", defaultCatalogTimeout),
+	)
+
+	enrichWorkers := worker.NewPool(enrichWorkerConfig)
+
+	ctx := signals.Context()
+
+	// a RabbitMQ outage doesn't stop the API, the events are consumed again once it's reconnected
+	amqpConn := rabbitmq.Dial(ctx, amqpURL())
+
+	wishController := controller.New(wishRepository, catalogGateway, logNotifier.NewNotifier(), enrichWorkers)
+
+	// the events stored in the outbox are published while RabbitMQ is up, they wait in the outbox meanwhile
+	outboxRelay := outbox.NewRelay(wishRepository, amqpEmitter.NewEmitter(amqpConn), outboxRelayConfig)
+	go outboxRelay.Run(ctx)
+
+	amqpHandler := amqpReceiver.NewHandler(wishController, amqpConn, envDuration("EVENT_DEDUP_RETENTION", defaultDedupRetention))
+	receiver := amqpReceiver.NewReceiver(amqpConn, amqpHandler)
+	// Receive events in goroutines
+	receiver.Receive(ctx)
+
+	serverAPI := api.NewServer(wishController, api.HealthCheck{Name: "rabbitmq", Up: amqpConn.Connected})
+	serverAPI.Run(ctx)
+
+	logrus.Infof("allowing %s for graceful shutdown to complete", serverShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	// drain the in-flight item enrichments before exit
+	if err := enrichWorkers.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Worker pool shutdown failed. Error: %s", err)
+	}
+}
+
+// createRepository creates the storage backend chosen by REPOSITORY_BACKEND, MongoDB by default,
+// the returned func closes it on shutdown
+func createRepository() (repository.Repository, func()) {
+	switch backend := os.Getenv("REPOSITORY_BACKEND"); backend {
+	case "", backendMongo:
+		// ToDo mongo shutdown
+		r, err := mongo2.NewRepository(createMongoClient(), repositoryTimeouts())
+		if err != nil {
+			logrus.Fatalln("Failed to create mongo repository", err)
+		}
+
+		return r, func() {}
+	case backendBolt:
+		path := os.Getenv("BOLT_PATH")
+		if path == "" {
+			path = defaultBoltPath
+		}
+
+		db := factory.CreateBoltDB(path)
+		r, err := boltRepository.NewRepository(db)
+		if err != nil {
+			logrus.Fatalln("Failed to create bolt repository", err)
+		}
+
+		return r, func() {
+			if err := db.Close(); err != nil {
+				logrus.Errorf("Bolt db close failed. Error: %s", err)
+			}
+		}
+	case backendPostgres:
+		db := factory.CreatePostgresDB(os.Getenv("POSTGRES_URL"))
+		migratePostgres(db)
 
-	wishRepository := mongo2.NewRepository(mongoClient)
-	catalogGateway := catalog.NewGateway(retryablehttp.NewClient(), os.Getenv("CATALOG_API_HOST"))
+		return postgresRepository.NewRepository(db, repositoryTimeouts()), func() {
+			if err := db.Close(); err != nil {
+				logrus.Errorf("Postgres close failed. Error: %s", err)
+			}
+		}
+	case backendMemory:
+		logrus.Warnln("Using the in-memory repository, the data is lost on exit")
+		return memoryRepository.NewRepository(), func() {}
+	default:
+		logrus.Fatalf("Unknown REPOSITORY_BACKEND %q", backend)
+		return nil, nil
+	}
+}
+
+// migrate runs the schema migrations of the storage backend chosen by REPOSITORY_BACKEND and exits,
+// e.g. before a deploy which adds a unique index
+func migrate() {
+	switch backend := os.Getenv("REPOSITORY_BACKEND"); backend {
+	case "", backendMongo:
+		if err := mongo2.Migrate(createMongoClient()); err != nil {
+			logrus.Fatalln("Failed to migrate MongoDB", err)
+		}
+	case backendPostgres:
+		db := factory.CreatePostgresDB(os.Getenv("POSTGRES_URL"))
+		defer db.Close()
+		migratePostgres(db)
+	case backendBolt, backendMemory:
+		logrus.Infof("Nothing to migrate for the %s backend", backend)
+		return
+	default:
+		logrus.Fatalf("Unknown REPOSITORY_BACKEND %q", backend)
+	}
+
+	logrus.Infoln("Migration completed")
+}
+
+// deadLetters lists or replays the dead-lettered events, of one exchange or of all:
+// dead-letters list|replay [exchange]
+func deadLetters(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		logrus.Fatalf("Usage: %s list|replay [exchange]", deadLettersCommand)
+	}
+
+	exchanges := amqpReceiver.Exchanges
+	if len(args) == 2 {
+		exchanges = []string{args[1]}
+	}
+
+	// the connection lives until the command exits, only the wait for it is bounded
+	conn := rabbitmq.Dial(context.Background(), amqpURL())
 
-	wishController := controller.New(wishRepository, catalogGateway)
+	ctx, cancel := context.WithTimeout(context.Background(), deadLettersConnectTimeout)
+	defer cancel()
 
-	amqpCh := factory.CreateAmqpChannel(fmt.Sprintf(
+	if !awaitConnected(ctx, conn) {
+		logrus.Fatalf("Failed to connect to RabbitMQ within %s", deadLettersConnectTimeout)
+	}
+
+	letters := amqpReceiver.NewDeadLetters(conn)
+
+	switch args[0] {
+	case "list":
+		enc := json.NewEncoder(os.Stdout)
+		for _, ex := range exchanges {
+			list, err := letters.List(ex, deadLettersListLimit)
+			if err != nil {
+				logrus.Fatalf("Failed to list the dead letters of %s. Error: %s", ex, err)
+			}
+
+			for _, l := range list {
+				if err := enc.Encode(l); err != nil {
+					logrus.Fatalln("Failed to encode dead letter", err)
+				}
+			}
+		}
+	case "replay":
+		for _, ex := range exchanges {
+			n, err := letters.Replay(ex)
+			if err != nil {
+				logrus.Fatalf("Failed to replay the dead letters of %s. Error: %s", ex, err)
+			}
+
+			logrus.Infof("Replayed %d dead letters of %s", n, ex)
+		}
+	default:
+		logrus.Fatalf("Usage: %s list|replay [exchange]", deadLettersCommand)
+	}
+}
+
+func migratePostgres(db *sql.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresMigrateTimeout)
+	defer cancel()
+
+	if err := postgresRepository.Migrate(ctx, db); err != nil {
+		logrus.Fatalln("Failed to migrate Postgres", err)
+	}
+}
+
+// awaitConnected waits until the connection is up or the context is done
+func awaitConnected(ctx context.Context, conn rabbitmq.Connection) bool {
+	for !conn.Connected() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	return true
+}
+
+func amqpURL() string {
+	return fmt.Sprintf(
 		"amqp://%s:%s@%s:%s/%s",
 		os.Getenv("RABBITMQ_USER"),
 		os.Getenv("RABBITMQ_PASSWORD"),
 		os.Getenv("RABBITMQ_HOST"),
 		os.Getenv("RABBITMQ_PORT"),
 		os.Getenv("RABBITMQ_VHOST"),
+	)
+}
+
+func createMongoClient() *mongo.Client {
+	return factory.CreateMongoClient(fmt.Sprintf(
+		"mongodb://%s:%s",
+		os.Getenv("MONGO_HOST"),
+		os.Getenv("MONGO_PORT"),
 	))
+}
 
-	amqpHandler := amqpReceiver.NewHandler(wishController)
-	receiver := amqpReceiver.NewReceiver(amqpCh, amqpHandler)
-	// Receive events in goroutines
-	receiver.Receive()
+// repositoryTimeouts overrides the default storage timeouts from the env, e.g. REPOSITORY_QUERY_TIMEOUT=5s,
+// MongoDB and Postgres apply them, bbolt has no deadlines as it takes no context
+func repositoryTimeouts() repository.Timeouts {
+	t := repository.DefaultTimeouts
+	t.Operation = envDuration("REPOSITORY_OPERATION_TIMEOUT", t.Operation)
+	t.Query = envDuration("REPOSITORY_QUERY_TIMEOUT", t.Query)
+	return t
+}
 
-	// ToDo mongo shutdown
-	ctx := signals.Context()
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
 
-	serverAPI := api.NewServer(wishController)
-	serverAPI.Run(ctx)
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logrus.Fatalf("Invalid %s %q. Error: %s", key, v, err)
+	}
 
-	logrus.Infof("allowing %s for graceful shutdown to complete", serverShutdownTimeout)
-	<-time.After(serverShutdownTimeout)
+	return d
 }
 
 func initLogger() {
@@ -1,10 +1,75 @@
 package model
 
-type List []*Item
+import (
+	"encoding/json"
+	"time"
+)
+
+type List struct {
+	Id      string `json:"id,omitempty"`
+	UserId  string `json:"user_id,omitempty"`
+	Name    string `json:"name"`
+	Default bool   `json:"default,omitempty"`
+	Items   Items  `json:"items,omitempty"`
+
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type Items []*Item
+
+const (
+	SortAdded    = "added"
+	SortPrice    = "price"
+	SortName     = "name"
+	SortPriority = "priority"
+
+	// SortRecent is the added order with the most recently added items first
+	SortRecent = "recent"
+)
+
+// ItemQuery filters, sorts and pages the items of a list
+// nil and empty filter fields don't filter
+type ItemQuery struct {
+	Active   *bool
+	Brand    string
+	MinPrice *float32
+	MaxPrice *float32
+	Reserved *bool
+
+	AddedSince *time.Time
+
+	Sort   string
+	Desc   bool
+	Limit  int
+	Cursor string
+}
 
 type Item struct {
 	*Product
-	Active bool `json:"active"`
+	Active                bool              `json:"active"`
+	Quantity              int               `json:"quantity"`
+	Priority              string            `json:"priority"`
+	Note                  string            `json:"note,omitempty"`
+	Variant               map[string]string `json:"variant,omitempty"`
+	PriceWhenAdded        float32           `json:"price_when_added,omitempty"`
+	LowestPriceSinceAdded float32           `json:"lowest_price_since_added,omitempty"`
+	Reservation           *Reservation      `json:"reservation,omitempty"`
+	AlertRule             *AlertRule        `json:"alert_rule,omitempty"`
+	CreatedAt             time.Time         `json:"created_at"`
+	UpdatedAt             time.Time         `json:"updated_at"`
+}
+
+const (
+	PriorityMustHave   = "must_have"
+	PriorityNiceToHave = "nice_to_have"
+)
+
+// ItemDetails is a partial update of the item metadata, nil fields are left unchanged
+type ItemDetails struct {
+	Quantity *int
+	Priority *string
+	Note     *string
+	Variant  map[string]string
 }
 
 type Product struct {
@@ -14,3 +79,98 @@ type Product struct {
 	Price     float32 `json:"price"`
 	Image     string  `json:"image"`
 }
+
+type PricePoint struct {
+	Price      float32   `json:"price"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+type Share struct {
+	Token     string    `json:"token"`
+	UserId    string    `json:"user_id"`
+	ListId    string    `json:"list_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	ReservationStatusReserved  = "reserved"
+	ReservationStatusPurchased = "purchased"
+)
+
+// Reservation is the public state of a claimed item, it never reveals the claimer
+type Reservation struct {
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Claim is handed out only to the visitor who reserved the item
+type Claim struct {
+	Id        string    `json:"claim_id"`
+	ProductId string    `json:"product_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AlertRule triggers a price alert when the price drops to the target price
+// or by the drop percentage compared to the price when the rule was set
+type AlertRule struct {
+	TargetPrice    float32 `json:"target_price,omitempty"`
+	DropPercentage float32 `json:"drop_percentage,omitempty"`
+	ReferencePrice float32 `json:"reference_price"`
+}
+
+// PriceWatch is a wish-listed copy of a product with an alert rule
+type PriceWatch struct {
+	UserId    string
+	ListId    string
+	ProductId string
+	Price     float32
+	Rule      *AlertRule
+}
+
+type PriceAlert struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"user_id"`
+	ListId      string    `json:"list_id"`
+	ProductId   string    `json:"product_id"`
+	OldPrice    float32   `json:"old_price"`
+	NewPrice    float32   `json:"new_price"`
+	TargetPrice float32   `json:"target_price"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Event is a domain event, it waits in the outbox until it's published
+type Event struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// OutboxStats tell how far the publishing is behind, Oldest is zero when nothing is pending
+type OutboxStats struct {
+	Pending int
+	Oldest  time.Time
+}
+
+const (
+	ErasureSourceEvent = "user_deleted"
+	ErasureSourceAdmin = "admin"
+)
+
+// Erasure is the audit record of erasing the wish list data of a user, it keeps only what was erased.
+// PricePoints are the price history of the products no other user has on a list,
+// Events are the outbox events about the user which were not published yet.
+type Erasure struct {
+	Id           string    `json:"id"`
+	UserId       string    `json:"user_id"`
+	Source       string    `json:"source"`
+	Lists        int       `json:"lists"`
+	Items        int       `json:"items"`
+	Reservations int       `json:"reservations"`
+	Shares       int       `json:"shares"`
+	Alerts       int       `json:"alerts"`
+	PricePoints  int       `json:"price_points"`
+	Events       int       `json:"events"`
+	ErasedAt     time.Time `json:"erased_at"`
+}
@@ -0,0 +1,28 @@
+package log
+
+import (
+	"context"
+
+	"github.com/pejovski/wish-list/model"
+	ntf "github.com/pejovski/wish-list/notifier"
+	"github.com/sirupsen/logrus"
+)
+
+// notifier only logs the alerts, it is used until a real delivery channel is plugged in
+type notifier struct{}
+
+func NewNotifier() ntf.Notifier {
+	return notifier{}
+}
+
+func (n notifier) Notify(ctx context.Context, alert *model.PriceAlert) error {
+	logrus.WithFields(logrus.Fields{
+		"user_id":    alert.UserId,
+		"list_id":    alert.ListId,
+		"product_id": alert.ProductId,
+		"old_price":  alert.OldPrice,
+		"new_price":  alert.NewPrice,
+	}).Infof("Price alert %s triggered", alert.Id)
+
+	return nil
+}
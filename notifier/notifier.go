@@ -0,0 +1,11 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+type Notifier interface {
+	Notify(ctx context.Context, alert *model.PriceAlert) error
+}
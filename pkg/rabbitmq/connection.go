@@ -0,0 +1,188 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+var ErrDisconnected = errors.New("rabbitmq is disconnected")
+
+// Setup prepares a channel, e.g. declares the queues and starts the consumers.
+// It runs on every channel the connection opens, so the consumers resume after a reconnect.
+type Setup func(ch *amqp.Channel) error
+
+// Connection keeps a channel to RabbitMQ open.
+//
+// It dials in the background and redials with backoff whenever the connection or the channel closes,
+// the service keeps running meanwhile and Connected reports the state.
+type Connection interface {
+	// Setup registers the setup, it runs right away if the connection is up
+	Setup(s Setup)
+	// Channel returns the open channel or ErrDisconnected
+	Channel() (*amqp.Channel, error)
+	// Open opens another channel on the connection, e.g. for publisher confirms, the caller closes it
+	Open() (*amqp.Channel, error)
+	Connected() bool
+}
+
+type connection struct {
+	url  string
+	dial func(url string) (*amqp.Connection, error)
+
+	// the redial waits from minBackoff, doubling up to maxBackoff
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu     sync.Mutex
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	setups []Setup
+}
+
+// Dial connects until the context is done
+func Dial(ctx context.Context, url string) Connection {
+	c := &connection{url: url, dial: amqp.Dial, minBackoff: minBackoff, maxBackoff: maxBackoff}
+	go c.run(ctx)
+
+	return c
+}
+
+func (c *connection) Setup(s Setup) {
+	c.mu.Lock()
+	c.setups = append(c.setups, s)
+	ch := c.ch
+	c.mu.Unlock()
+
+	// a channel opened before the setup was registered doesn't run it
+	if ch != nil {
+		if err := s(ch); err != nil {
+			logrus.Errorf("RabbitMQ setup failed, reconnecting. Error: %s", err)
+			_ = ch.Close()
+		}
+	}
+}
+
+func (c *connection) Channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ch == nil {
+		return nil, ErrDisconnected
+	}
+
+	return c.ch, nil
+}
+
+func (c *connection) Open() (*amqp.Channel, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil, ErrDisconnected
+	}
+
+	return conn.Channel()
+}
+
+func (c *connection) Connected() bool {
+	_, err := c.Channel()
+	return err == nil
+}
+
+func (c *connection) run(ctx context.Context) {
+	backoff := c.minBackoff
+	for {
+		err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			// the connection was up, it's redialed right away
+			backoff = c.minBackoff
+			continue
+		}
+
+		logrus.Errorf("Failed to connect to RabbitMQ, retrying in %s. Error: %s", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// connect opens a channel, runs the setups on it and waits until the channel or the connection closes
+func (c *connection) connect(ctx context.Context) error {
+	conn, ch, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	c.mu.Lock()
+	c.conn = conn
+	c.ch = ch
+	setups := append([]Setup{}, c.setups...)
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.ch = nil
+		c.mu.Unlock()
+	}()
+
+	for _, s := range setups {
+		if err := s(ch); err != nil {
+			return err
+		}
+	}
+
+	logrus.Infoln("Connected to RabbitMQ!")
+
+	select {
+	case <-ctx.Done():
+	case err := <-connClosed:
+		logrus.Errorf("RabbitMQ connection closed, reconnecting. Error: %s", err)
+	case err := <-chClosed:
+		logrus.Errorf("RabbitMQ channel closed, reconnecting. Error: %s", err)
+	}
+
+	return nil
+}
+
+func (c *connection) open() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := c.dial(c.url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, ch, nil
+}
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrQueueFull = errors.New("worker queue is full")
+	ErrStopped   = errors.New("worker pool is stopped")
+	ErrAborted   = errors.New("worker job aborted on shutdown")
+)
+
+// Job is a unit of background work.
+// Run gets a context which is canceled when the shutdown deadline is exceeded, it must return then.
+// It is retried with backoff until it succeeds, returns a Permanent error
+// or the attempts are exhausted, in the last two cases Failed is called.
+// A job aborted by the shutdown deadline, or still queued then, is dropped and Failed is called with ErrAborted,
+// so it can clean up what the job would have finished.
+type Job struct {
+	Name   string
+	Run    func(ctx context.Context) error
+	Failed func(err error)
+}
+
+// Pool runs jobs on a bounded number of workers.
+//
+// Submit never blocks, when the queue is full it returns ErrQueueFull so the
+// caller can push back on its own client. Shutdown stops accepting jobs and
+// waits for the queued and in-flight ones to finish, when its deadline is exceeded
+// it aborts them and waits for their Failed calls.
+type Pool interface {
+	Submit(job Job) error
+	Shutdown(ctx context.Context) error
+}
+
+// Config of the pool, Workers and MaxAttempts below 1 default to 1
+type Config struct {
+	Workers     int
+	QueueSize   int
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+type pool struct {
+	config Config
+	jobs   chan Job
+
+	// abort cancels the retry backoffs when the shutdown deadline is exceeded
+	abortCtx context.Context
+	abort    context.CancelFunc
+
+	mu      sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func NewPool(c Config) Pool {
+	if c.Workers < 1 {
+		c.Workers = 1
+	}
+	if c.MaxAttempts < 1 {
+		c.MaxAttempts = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &pool{
+		config:   c,
+		jobs:     make(chan Job, c.QueueSize),
+		abortCtx: ctx,
+		abort:    cancel,
+	}
+
+	for i := 0; i < c.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *pool) Submit(job Job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logrus.Infoln("Worker pool drained")
+		return nil
+	case <-ctx.Done():
+		p.abort()
+		logrus.Warnf("Worker pool shutdown timed out with %d queued jobs", len(p.jobs))
+
+		// the dropped and aborted jobs clean up before the process exits
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *pool) work() {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		// the jobs still queued when the shutdown deadline is exceeded are dropped
+		if p.abortCtx.Err() != nil {
+			logrus.Errorf("Job %s dropped on shutdown", job.Name)
+			fail(job, ErrAborted)
+			continue
+		}
+
+		p.run(job)
+	}
+}
+
+func (p *pool) run(job Job) {
+	backoff := p.config.Backoff
+
+	var err error
+	for attempt := 1; attempt <= p.config.MaxAttempts; attempt++ {
+		err = job.Run(p.abortCtx)
+		if err == nil {
+			return
+		}
+
+		// the job failed because it was canceled, not because of its work
+		if p.abortCtx.Err() != nil {
+			logrus.Errorf("Job %s aborted on shutdown. Error: %s", job.Name, err)
+			fail(job, ErrAborted)
+			return
+		}
+
+		if IsPermanent(err) {
+			break
+		}
+
+		logrus.Warnf("Job %s attempt %d/%d failed. Error: %s", job.Name, attempt, p.config.MaxAttempts, err)
+
+		if attempt == p.config.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-p.abortCtx.Done():
+			logrus.Errorf("Job %s aborted on shutdown", job.Name)
+			fail(job, ErrAborted)
+			return
+		}
+
+		backoff *= 2
+		if backoff > p.config.MaxBackoff {
+			backoff = p.config.MaxBackoff
+		}
+	}
+
+	logrus.Errorf("Job %s failed. Error: %s", job.Name, err)
+	fail(job, err)
+}
+
+func fail(job Job, err error) {
+	if job.Failed != nil {
+		job.Failed(err)
+	}
+}
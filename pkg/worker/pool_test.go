@@ -0,0 +1,280 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errJob = errors.New("job failed")
+
+func testConfig() Config {
+	return Config{
+		Workers:     1,
+		QueueSize:   10,
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  2 * time.Millisecond,
+	}
+}
+
+// result collects how a job ended
+type result struct {
+	attempts int32
+	failed   chan error
+	done     chan struct{}
+}
+
+func newResult() *result {
+	return &result{failed: make(chan error, 1), done: make(chan struct{}, 1)}
+}
+
+// job fails with the errors in order and succeeds once they run out
+func (r *result) job(errs ...error) Job {
+	return Job{
+		Name: "test",
+		Run: func(ctx context.Context) error {
+			n := atomic.AddInt32(&r.attempts, 1)
+			if int(n) <= len(errs) {
+				return errs[n-1]
+			}
+			r.done <- struct{}{}
+			return nil
+		},
+		Failed: func(err error) {
+			r.failed <- err
+		},
+	}
+}
+
+func (r *result) waitFailed(t *testing.T) error {
+	t.Helper()
+
+	select {
+	case err := <-r.failed:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Failed was not called")
+		return nil
+	}
+}
+
+func (r *result) waitDone(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-r.done:
+	case err := <-r.failed:
+		t.Fatalf("job failed: %s", err)
+	case <-time.After(time.Second):
+		t.Fatal("job did not succeed")
+	}
+}
+
+func shutdown(t *testing.T, p Pool) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	p := NewPool(testConfig())
+	defer shutdown(t, p)
+
+	r := newResult()
+	if err := p.Submit(r.job(errJob, errJob)); err != nil {
+		t.Fatalf("Submit failed: %s", err)
+	}
+
+	r.waitDone(t)
+	if n := atomic.LoadInt32(&r.attempts); n != 3 {
+		t.Fatalf("attempts = %d, want 3", n)
+	}
+}
+
+func TestAttemptsExhausted(t *testing.T) {
+	p := NewPool(testConfig())
+	defer shutdown(t, p)
+
+	r := newResult()
+	if err := p.Submit(r.job(errJob, errJob, errJob, errJob)); err != nil {
+		t.Fatalf("Submit failed: %s", err)
+	}
+
+	if err := r.waitFailed(t); !errors.Is(err, errJob) {
+		t.Fatalf("Failed got %v, want %v", err, errJob)
+	}
+	if n := atomic.LoadInt32(&r.attempts); n != 3 {
+		t.Fatalf("attempts = %d, want 3", n)
+	}
+}
+
+func TestPermanentErrorIsNotRetried(t *testing.T) {
+	p := NewPool(testConfig())
+	defer shutdown(t, p)
+
+	// a permanent error wrapped on the way up is still permanent
+	wrapped := fmt.Errorf("enrich: %w", Permanent(errJob))
+
+	r := newResult()
+	if err := p.Submit(r.job(wrapped)); err != nil {
+		t.Fatalf("Submit failed: %s", err)
+	}
+
+	if err := r.waitFailed(t); !errors.Is(err, errJob) {
+		t.Fatalf("Failed got %v, want %v", err, errJob)
+	}
+	if n := atomic.LoadInt32(&r.attempts); n != 1 {
+		t.Fatalf("attempts = %d, want 1", n)
+	}
+}
+
+func TestMaxAttemptsDefault(t *testing.T) {
+	c := testConfig()
+	c.MaxAttempts = 0
+	p := NewPool(c)
+	defer shutdown(t, p)
+
+	r := newResult()
+	if err := p.Submit(r.job(errJob)); err != nil {
+		t.Fatalf("Submit failed: %s", err)
+	}
+
+	if err := r.waitFailed(t); !errors.Is(err, errJob) {
+		t.Fatalf("Failed got %v, want %v", err, errJob)
+	}
+	if n := atomic.LoadInt32(&r.attempts); n != 1 {
+		t.Fatalf("attempts = %d, want 1", n)
+	}
+}
+
+// blocker is a job which holds the only worker until it is released or canceled
+func blocker(started chan<- struct{}, release <-chan struct{}, canceled chan<- error, failed chan<- error) Job {
+	return Job{
+		Name: "blocker",
+		Run: func(ctx context.Context) error {
+			started <- struct{}{}
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				canceled <- ctx.Err()
+				return ctx.Err()
+			}
+		},
+		Failed: func(err error) {
+			failed <- err
+		},
+	}
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	c := testConfig()
+	c.QueueSize = 1
+	p := NewPool(c)
+	defer shutdown(t, p)
+
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	if err := p.Submit(blocker(started, release, make(chan error, 1), make(chan error, 1))); err != nil {
+		t.Fatalf("Submit failed: %s", err)
+	}
+	<-started
+
+	queued := newResult()
+	if err := p.Submit(queued.job()); err != nil {
+		t.Fatalf("Submit to a free queue slot failed: %s", err)
+	}
+
+	if err := p.Submit(newResult().job()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit to a full queue = %v, want ErrQueueFull", err)
+	}
+
+	close(release)
+	queued.waitDone(t)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	c := testConfig()
+	c.Workers = 2
+	p := NewPool(c)
+
+	results := []*result{}
+	for i := 0; i < 5; i++ {
+		r := newResult()
+		results = append(results, r)
+		if err := p.Submit(r.job(errJob)); err != nil {
+			t.Fatalf("Submit failed: %s", err)
+		}
+	}
+
+	shutdown(t, p)
+
+	for i, r := range results {
+		select {
+		case <-r.done:
+		default:
+			t.Fatalf("job %d did not finish before Shutdown returned", i)
+		}
+	}
+
+	if err := p.Submit(newResult().job()); !errors.Is(err, ErrStopped) {
+		t.Fatalf("Submit after Shutdown = %v, want ErrStopped", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	p := NewPool(testConfig())
+
+	started, canceled, failed := make(chan struct{}, 1), make(chan error, 1), make(chan error, 1)
+	if err := p.Submit(blocker(started, make(chan struct{}), canceled, failed)); err != nil {
+		t.Fatalf("Submit failed: %s", err)
+	}
+	<-started
+
+	queued := newResult()
+	if err := p.Submit(queued.job()); err != nil {
+		t.Fatalf("Submit failed: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("in-flight job context = %v, want Canceled", err)
+	}
+
+	// Shutdown returns after the aborted and dropped jobs cleaned up
+	select {
+	case err := <-failed:
+		if !errors.Is(err, ErrAborted) {
+			t.Fatalf("Failed of the in-flight job got %v, want ErrAborted", err)
+		}
+	default:
+		t.Fatal("Failed was not called for the in-flight job")
+	}
+
+	select {
+	case err := <-queued.failed:
+		if !errors.Is(err, ErrAborted) {
+			t.Fatalf("Failed of the queued job got %v, want ErrAborted", err)
+		}
+	default:
+		t.Fatal("Failed was not called for the dropped job")
+	}
+
+	if n := atomic.LoadInt32(&queued.attempts); n != 0 {
+		t.Fatalf("queued job ran %d times after the deadline", n)
+	}
+}
@@ -0,0 +1,161 @@
+package amqp
+
+import (
+	"time"
+
+	"github.com/pejovski/wish-list/pkg/rabbitmq"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// DeadLetter is a delivery which exhausted its retries or can't succeed
+type DeadLetter struct {
+	Exchange  string    `json:"exchange"`
+	MessageId string    `json:"message_id,omitempty"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	FailedAt  time.Time `json:"failed_at"`
+	Body      string    `json:"body"`
+}
+
+// DeadLetters inspects and replays the dead letter queue of an exchange
+type DeadLetters interface {
+	// List returns up to limit dead letters, they stay in the queue
+	List(ex string, limit int) ([]*DeadLetter, error)
+	// Replay moves the dead letters back to the queue with fresh retries and returns their count
+	Replay(ex string) (int, error)
+}
+
+// deadLetters opens a channel per call, a closed channel fails the call instead of the process
+type deadLetters struct {
+	conn rabbitmq.Connection
+}
+
+func NewDeadLetters(conn rabbitmq.Connection) DeadLetters {
+	return deadLetters{conn: conn}
+}
+
+func (dl deadLetters) List(ex string, limit int) ([]*DeadLetter, error) {
+	ch, err := dl.conn.Open()
+	if err != nil {
+		logrus.Errorf("Failed to open channel. Error: %s", err)
+		return nil, err
+	}
+	defer ch.Close()
+
+	dead := deadLetterQueue(ex)
+	if err := declareDeadLetterQueue(ch, ex); err != nil {
+		logrus.Errorf("Failed to declare queue %s. Error: %s", dead, err)
+		return nil, err
+	}
+
+	letters := []*DeadLetter{}
+	var last uint64
+	for len(letters) < limit {
+		d, ok, err := ch.Get(dead, false)
+		if err != nil {
+			logrus.Errorf("Failed to get from queue %s. Error: %s", dead, err)
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		last = d.DeliveryTag
+		letters = append(letters, mapDeliveryToDeadLetter(ex, &d))
+	}
+
+	// the fetched dead letters are returned to the queue
+	if last != 0 {
+		if err := ch.Nack(last, true, true); err != nil {
+			logrus.Errorf("Failed to requeue to queue %s. Error: %s", dead, err)
+			return nil, err
+		}
+	}
+
+	return letters, nil
+}
+
+func (dl deadLetters) Replay(ex string) (int, error) {
+	ch, err := dl.conn.Open()
+	if err != nil {
+		logrus.Errorf("Failed to open channel. Error: %s", err)
+		return 0, err
+	}
+	defer ch.Close()
+
+	dead := deadLetterQueue(ex)
+	if err := declareDeadLetterQueue(ch, ex); err != nil {
+		logrus.Errorf("Failed to declare queue %s. Error: %s", dead, err)
+		return 0, err
+	}
+
+	// only the dead letters queued now are replayed, the ones failing again meanwhile wait for the next replay
+	q, err := ch.QueueInspect(dead)
+	if err != nil {
+		logrus.Errorf("Failed to inspect queue %s. Error: %s", dead, err)
+		return 0, err
+	}
+
+	replayed := 0
+	for replayed < q.Messages {
+		d, ok, err := ch.Get(dead, false)
+		if err != nil {
+			logrus.Errorf("Failed to get from queue %s. Error: %s", dead, err)
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		delete(headers, headerAttempts)
+
+		// published to the queue directly, the other consumers of the exchange got the message already
+		err = ch.Publish("", queue(ex), false, false, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Timestamp:    d.Timestamp,
+			Body:         d.Body,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to publish message %s to %s. Error: %s", d.MessageId, queue(ex), err)
+			if err := d.Reject(true); err != nil {
+				logrus.Errorln("Failed to reject msg", err)
+			}
+			return replayed, err
+		}
+
+		if err := d.Ack(false); err != nil {
+			logrus.Errorln("Failed to ack msg", err)
+			return replayed, err
+		}
+
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+func mapDeliveryToDeadLetter(ex string, d *amqp.Delivery) *DeadLetter {
+	letter := &DeadLetter{
+		Exchange:  ex,
+		MessageId: d.MessageId,
+		Attempts:  deliveryAttempts(d),
+		Body:      string(d.Body),
+	}
+
+	if v, ok := d.Headers[headerError].(string); ok {
+		letter.Error = v
+	}
+	if v, ok := d.Headers[headerFailedAt].(time.Time); ok {
+		letter.FailedAt = v
+	}
+
+	return letter
+}
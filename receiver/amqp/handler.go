@@ -1,106 +1,301 @@
 package amqp
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pejovski/wish-list/controller"
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	"github.com/pejovski/wish-list/pkg/rabbitmq"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 	"time"
 )
 
+const (
+	// deliveryTimeout bounds the handling of a single delivery
+	deliveryTimeout = 10 * time.Second
+
+	headerAttempts = "x-attempts"
+	headerError    = "x-error"
+	headerFailedAt = "x-failed-at"
+)
+
 type Handler interface {
-	ProductUpdated(d *amqp.Delivery)
-	ProductDeleted(d *amqp.Delivery)
-	ProductPriceUpdated(d *amqp.Delivery)
+	ProductUpdated(ctx context.Context, d *amqp.Delivery)
+	ProductDeleted(ctx context.Context, d *amqp.Delivery)
+	ProductPriceUpdated(ctx context.Context, d *amqp.Delivery)
+	ProductAvailabilityUpdated(ctx context.Context, d *amqp.Delivery)
+	UserDeleted(ctx context.Context, d *amqp.Delivery)
+}
+
+// publisher is the part of the channel the retries and the dead letters are published on
+type publisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
 }
 
 type handler struct {
 	controller controller.Controller
+	// channel returns the open channel of the connection
+	channel func() (publisher, error)
+
+	// dedupRetention is how long an applied event is remembered to skip its redeliveries
+	dedupRetention time.Duration
 }
 
-func NewHandler(c controller.Controller) Handler {
+func NewHandler(c controller.Controller, conn rabbitmq.Connection, dedupRetention time.Duration) Handler {
 	s := handler{
 		controller: c,
+		channel: func() (publisher, error) {
+			ch, err := conn.Channel()
+			if err != nil {
+				return nil, err
+			}
+			return ch, nil
+		},
+		dedupRetention: dedupRetention,
 	}
 
 	return s
 }
 
-func (h handler) ProductUpdated(d *amqp.Delivery) {
+func (h handler) ProductUpdated(ctx context.Context, d *amqp.Delivery) {
+	h.handle(ctx, exProductUpdated, d, func(ctx context.Context) error {
+		msg := struct {
+			Id string `json:"id"`
+		}{}
 
-	msg := struct {
-		Id string `json:"id"`
-	}{}
+		err := json.Unmarshal(d.Body, &msg)
+		if err != nil {
+			logrus.Errorln("Failed to read body", err)
+			return myerr.Wrap(myerr.ErrInvalidMessage, err)
+		}
 
-	err := json.Unmarshal(d.Body, &msg)
-	if err != nil {
-		logrus.Errorln("Failed to read body", err)
-		h.reject(d)
-		return
+		err = h.controller.UpdateProduct(ctx, msg.Id)
+		if err != nil {
+			logrus.Errorln("Failed to update product", err)
+			return err
+		}
+
+		logrus.Infof("Product %s successfully updated", msg.Id)
+		return nil
+	})
+}
+
+func (h handler) ProductDeleted(ctx context.Context, d *amqp.Delivery) {
+	h.handle(ctx, exProductDeleted, d, func(ctx context.Context) error {
+		msg := struct {
+			Id string `json:"id"`
+		}{}
+
+		err := json.Unmarshal(d.Body, &msg)
+		if err != nil {
+			logrus.Errorln("Failed to read body", err)
+			return myerr.Wrap(myerr.ErrInvalidMessage, err)
+		}
+
+		err = h.controller.DeleteProduct(ctx, msg.Id)
+		if err != nil {
+			logrus.Errorln("Failed to delete product", err)
+			return err
+		}
+
+		logrus.Infof("Product %s successfully deleted", msg.Id)
+		return nil
+	})
+}
+
+func (h handler) ProductPriceUpdated(ctx context.Context, d *amqp.Delivery) {
+	h.handle(ctx, exProductPriceUpdated, d, func(ctx context.Context) error {
+		msg := struct {
+			Id        string     `json:"id"`
+			Price     float32    `json:"price"`
+			UpdatedAt *time.Time `json:"updated_at"`
+		}{}
+
+		err := json.Unmarshal(d.Body, &msg)
+		if err != nil {
+			logrus.Errorln("Failed to read body", err)
+			return myerr.Wrap(myerr.ErrInvalidMessage, err)
+		}
+
+		err = h.controller.UpdateProductPrice(ctx, msg.Id, msg.Price, priceTime(msg.UpdatedAt, d))
+		if err != nil {
+			logrus.Errorln("Failed to update product price", err)
+			return err
+		}
+
+		logrus.Infof("Price of product %s successfully updated", msg.Id)
+		return nil
+	})
+}
+
+func (h handler) ProductAvailabilityUpdated(ctx context.Context, d *amqp.Delivery) {
+	h.handle(ctx, exProductAvailabilityUpdated, d, func(ctx context.Context) error {
+		msg := struct {
+			Id        string `json:"id"`
+			Available bool   `json:"available"`
+		}{}
+
+		err := json.Unmarshal(d.Body, &msg)
+		if err != nil {
+			logrus.Errorln("Failed to read body", err)
+			return myerr.Wrap(myerr.ErrInvalidMessage, err)
+		}
+
+		err = h.controller.UpdateProductAvailability(ctx, msg.Id, msg.Available)
+		if err != nil {
+			logrus.Errorln("Failed to update product availability", err)
+			return err
+		}
+
+		logrus.Infof("Availability of product %s successfully updated", msg.Id)
+		return nil
+	})
+}
+
+// UserDeleted erases the wish list data of the user, a user without data gets an empty audit record
+func (h handler) UserDeleted(ctx context.Context, d *amqp.Delivery) {
+	h.handle(ctx, exUserDeleted, d, func(ctx context.Context) error {
+		msg := struct {
+			Id string `json:"id"`
+		}{}
+
+		err := json.Unmarshal(d.Body, &msg)
+		if err != nil {
+			logrus.Errorln("Failed to read body", err)
+			return myerr.Wrap(myerr.ErrInvalidMessage, err)
+		}
+
+		if msg.Id == "" {
+			logrus.Errorln("Failed to read body, missing user id")
+			return myerr.ErrInvalidMessage
+		}
+
+		_, err = h.controller.EraseUser(ctx, msg.Id, model.ErasureSourceEvent)
+		if err != nil {
+			logrus.Errorln("Failed to erase user", err)
+			return err
+		}
+
+		logrus.Infof("User %s successfully erased", msg.Id)
+		return nil
+	})
+}
+
+// handle applies the event once, a redelivery of an applied event within the retention is acked and skipped
+// an event without a message id can't be told from a repeat with the same payload, so it is applied every time
+func (h handler) handle(ctx context.Context, ex string, d *amqp.Delivery, apply func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	id := eventId(ex, d)
+
+	if id != "" {
+		processed, err := h.controller.EventProcessed(ctx, id)
+		if err != nil {
+			h.reject(ex, d, err)
+			return
+		}
+
+		if processed {
+			logrus.Infof("Event %s already processed, skipped", id)
+			h.ack(d)
+			return
+		}
 	}
 
-	err = h.controller.UpdateProduct(msg.Id)
-	if err != nil {
-		logrus.Errorln("Failed to update product", err)
-		h.reject(d)
+	if err := apply(ctx); err != nil {
+		h.reject(ex, d, err)
 		return
 	}
 
-	logrus.Infof("Product %s successfully updated", msg.Id)
+	// the event is applied, a failed mark only means a redelivery would be applied again
+	if id != "" {
+		_ = h.controller.MarkEventProcessed(ctx, id, h.dedupRetention)
+	}
+
 	h.ack(d)
 }
 
-func (h handler) ProductDeleted(d *amqp.Delivery) {
-	msg := struct {
-		Id string `json:"id"`
-	}{}
+// eventId identifies the event by its message id within the exchange, it is empty when the publisher sets no id
+func eventId(ex string, d *amqp.Delivery) string {
+	if d.MessageId == "" {
+		return ""
+	}
 
-	err := json.Unmarshal(d.Body, &msg)
-	if err != nil {
-		logrus.Errorln("Failed to read body", err)
-		h.reject(d)
-		return
+	return ex + ":" + d.MessageId
+}
+
+// priceTime is when the price was set, from the payload, the message timestamp or else the delivery time
+func priceTime(updatedAt *time.Time, d *amqp.Delivery) time.Time {
+	if updatedAt != nil {
+		return *updatedAt
 	}
 
-	err = h.controller.DeleteProduct(msg.Id)
-	if err != nil {
-		logrus.Errorln("Failed to delete product", err)
-		h.reject(d)
-		return
+	if !d.Timestamp.IsZero() {
+		return d.Timestamp
 	}
 
-	logrus.Infof("Product %s successfully deleted", msg.Id)
-	h.ack(d)
+	return time.Now().UTC()
 }
 
-func (h handler) ProductPriceUpdated(d *amqp.Delivery) {
-	msg := struct {
-		Id    string  `json:"id"`
-		Price float32 `json:"price"`
-	}{}
+// reject schedules a retry of a transient failure with a growing delay, the delivery is dead-lettered
+// when it failed maxAttempts times or the failure is permanent
+func (h handler) reject(ex string, d *amqp.Delivery, cause error) {
+	attempts := deliveryAttempts(d) + 1
 
-	err := json.Unmarshal(d.Body, &msg)
-	if err != nil {
-		logrus.Errorln("Failed to read body", err)
-		h.reject(d)
-		return
+	queue := deadLetterQueue(ex)
+	if myerr.IsTransient(cause) && attempts < maxAttempts {
+		queue = retryQueue(ex, attempts)
+		logrus.Warnf("Retrying message %s from %s in %s, attempt %d. Error: %s", d.MessageId, ex, retryDelay(attempts), attempts, cause)
+	} else {
+		logrus.Errorf("Dead-lettering message %s from %s after %d attempts. Error: %s", d.MessageId, ex, attempts, cause)
 	}
 
-	err = h.controller.UpdateProductPrice(msg.Id, msg.Price)
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[headerAttempts] = int32(attempts)
+	headers[headerError] = cause.Error()
+	headers[headerFailedAt] = time.Now().UTC()
+
+	// the delivery is redelivered anyway if the channel is gone meanwhile
+	ch, err := h.channel()
+	if err == nil {
+		err = ch.Publish("", queue, false, false, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Timestamp:    d.Timestamp,
+			Body:         d.Body,
+		})
+	}
+	// the delivery goes back to its queue, it's handled again without counting the attempt
 	if err != nil {
-		logrus.Errorln("Failed to update product price", err)
-		h.reject(d)
+		logrus.Errorf("Failed to publish message %s to %s, requeued. Error: %s", d.MessageId, queue, err)
+		if err := d.Nack(false, true); err != nil {
+			logrus.Errorln("Failed to nack msg", err)
+		}
 		return
 	}
 
-	logrus.Infof("Price of product %s successfully updated", msg.Id)
 	h.ack(d)
 }
 
-func (h handler) reject(d *amqp.Delivery) {
-	time.Sleep(5 * time.Second)
-	if err := d.Reject(true); err != nil {
-		logrus.Errorln("Failed to reject msg", err)
+// deliveryAttempts is how many times the delivery failed before
+func deliveryAttempts(d *amqp.Delivery) int {
+	switch v := d.Headers[headerAttempts].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
 	}
 }
 
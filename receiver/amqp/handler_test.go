@@ -0,0 +1,363 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pejovski/wish-list/controller"
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	"github.com/streadway/amqp"
+)
+
+// fakeController handles the events the tests send, the other controller methods are not called
+type fakeController struct {
+	controller.Controller
+
+	err     error
+	updated []string
+	deleted []string
+	erased  []string
+
+	processed map[string]bool
+}
+
+func (c *fakeController) UpdateProduct(ctx context.Context, productId string) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.updated = append(c.updated, productId)
+	return nil
+}
+
+func (c *fakeController) DeleteProduct(ctx context.Context, productId string) error {
+	c.deleted = append(c.deleted, productId)
+	return nil
+}
+
+func (c *fakeController) EraseUser(ctx context.Context, userId string, source string) (*model.Erasure, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if source != model.ErasureSourceEvent {
+		return nil, errors.New("erasure source " + source)
+	}
+
+	c.erased = append(c.erased, userId)
+	return &model.Erasure{UserId: userId, Source: source}, nil
+}
+
+func (c *fakeController) EventProcessed(ctx context.Context, eventId string) (bool, error) {
+	return c.processed[eventId], nil
+}
+
+func (c *fakeController) MarkEventProcessed(ctx context.Context, eventId string, retention time.Duration) error {
+	if c.processed == nil {
+		c.processed = map[string]bool{}
+	}
+
+	c.processed[eventId] = true
+	return nil
+}
+
+// fakeChannel records the published retries and dead letters
+type fakeChannel struct {
+	err       error
+	published map[string][]amqp.Publishing
+}
+
+func (ch *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if ch.err != nil {
+		return ch.err
+	}
+
+	if ch.published == nil {
+		ch.published = map[string][]amqp.Publishing{}
+	}
+
+	ch.published[key] = append(ch.published[key], msg)
+	return nil
+}
+
+// acknowledger records how a delivery was settled
+type acknowledger struct {
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked, a.requeue = true, requeue
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func newTestHandler(c controller.Controller, ch *fakeChannel) handler {
+	return handler{
+		controller:     c,
+		channel:        func() (publisher, error) { return ch, nil },
+		dedupRetention: time.Hour,
+	}
+}
+
+func delivery(body string, headers amqp.Table) (*amqp.Delivery, *acknowledger) {
+	a := &acknowledger{}
+	return &amqp.Delivery{
+		Acknowledger: a,
+		Headers:      headers,
+		MessageId:    "m1",
+		Body:         []byte(body),
+	}, a
+}
+
+func TestHandlerRetriesTransientFailure(t *testing.T) {
+	ch := &fakeChannel{}
+	h := newTestHandler(&fakeController{err: errors.New("connection reset")}, ch)
+
+	d, a := delivery(`{"id": "p1"}`, amqp.Table{headerAttempts: int32(2)})
+	h.ProductUpdated(context.Background(), d)
+
+	if !a.acked {
+		t.Fatal("delivery moved to a retry queue was not acked")
+	}
+
+	retry := ch.published[retryQueue(exProductUpdated, 3)]
+	if len(retry) != 1 {
+		t.Fatalf("published %v, want one message on the third retry queue", ch.published)
+	}
+	if n := deliveryAttempts(&amqp.Delivery{Headers: retry[0].Headers}); n != 3 {
+		t.Errorf("attempts header = %d, want 3", n)
+	}
+	if retry[0].Headers[headerError] != "connection reset" {
+		t.Errorf("error header = %v, want the cause", retry[0].Headers[headerError])
+	}
+	if retry[0].MessageId != "m1" || string(retry[0].Body) != `{"id": "p1"}` {
+		t.Errorf("retry lost the message: %+v", retry[0])
+	}
+}
+
+func TestHandlerDeadLetters(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		err      error
+		attempts int32
+	}{
+		{"exhausted retries", `{"id": "p1"}`, errors.New("connection reset"), maxAttempts - 1},
+		{"permanent failure", `{"id": "p1"}`, myerr.ErrProductNotFound, 0},
+		{"malformed message", `{"id":`, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{}
+			h := newTestHandler(&fakeController{err: tt.err}, ch)
+
+			d, a := delivery(tt.body, amqp.Table{headerAttempts: tt.attempts})
+			h.ProductUpdated(context.Background(), d)
+
+			if !a.acked {
+				t.Fatal("dead-lettered delivery was not acked")
+			}
+
+			dead := ch.published[deadLetterQueue(exProductUpdated)]
+			if len(ch.published) != 1 || len(dead) != 1 {
+				t.Fatalf("published %v, want one dead letter", ch.published)
+			}
+			if n := deliveryAttempts(&amqp.Delivery{Headers: dead[0].Headers}); n != int(tt.attempts)+1 {
+				t.Errorf("attempts header = %d, want %d", n, tt.attempts+1)
+			}
+		})
+	}
+}
+
+func TestHandlerRequeuesWhenPublishFails(t *testing.T) {
+	ch := &fakeChannel{err: amqp.ErrClosed}
+	h := newTestHandler(&fakeController{err: errors.New("connection reset")}, ch)
+
+	d, a := delivery(`{"id": "p1"}`, nil)
+
+	done := make(chan struct{})
+	go func() {
+		h.ProductUpdated(context.Background(), d)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler blocked on the failed publish")
+	}
+
+	if a.acked || !a.nacked || !a.requeue {
+		t.Errorf("delivery settled as %+v, want nacked with requeue", *a)
+	}
+}
+
+func TestHandlerAcksAppliedEvent(t *testing.T) {
+	ch := &fakeChannel{}
+	c := &fakeController{}
+	h := newTestHandler(c, ch)
+
+	d, a := delivery(`{"id": "p1"}`, nil)
+	h.ProductUpdated(context.Background(), d)
+
+	if !a.acked || len(ch.published) != 0 {
+		t.Errorf("delivery settled as %+v and published %v, want acked only", *a, ch.published)
+	}
+	if len(c.updated) != 1 || c.updated[0] != "p1" {
+		t.Errorf("updated %v, want p1", c.updated)
+	}
+}
+
+func TestHandlerSkipsProcessedEvent(t *testing.T) {
+	ch := &fakeChannel{}
+	c := &fakeController{}
+	h := newTestHandler(c, ch)
+
+	first, _ := delivery(`{"id": "p1"}`, nil)
+	h.ProductUpdated(context.Background(), first)
+
+	redelivered, a := delivery(`{"id": "p1"}`, nil)
+	redelivered.Redelivered = true
+	h.ProductUpdated(context.Background(), redelivered)
+
+	if !a.acked {
+		t.Error("redelivery of a processed event was not acked")
+	}
+	if len(c.updated) != 1 {
+		t.Errorf("event applied %d times, want once", len(c.updated))
+	}
+
+	// the same message id on another exchange is another event
+	other, _ := delivery(`{"id": "p1"}`, nil)
+	h.ProductDeleted(context.Background(), other)
+	if len(c.deleted) != 1 {
+		t.Error("event of another exchange was skipped")
+	}
+}
+
+// equal payloads without a message id are separate events, e.g. the same product updated twice
+func TestHandlerAppliesEventsWithoutMessageId(t *testing.T) {
+	ch := &fakeChannel{}
+	c := &fakeController{}
+	h := newTestHandler(c, ch)
+
+	for i := 0; i < 2; i++ {
+		d, a := delivery(`{"id": "p1"}`, nil)
+		d.MessageId = ""
+		h.ProductUpdated(context.Background(), d)
+
+		if !a.acked {
+			t.Fatalf("delivery %d was not acked", i+1)
+		}
+	}
+
+	if len(c.updated) != 2 {
+		t.Errorf("event applied %d times, want twice", len(c.updated))
+	}
+	if len(c.processed) != 0 {
+		t.Errorf("marked processed %v, want nothing without a message id", c.processed)
+	}
+}
+
+// priceController keeps the latest price of every product and skips the older ones like the repository does
+type priceController struct {
+	fakeController
+
+	prices map[string]float32
+	at     map[string]time.Time
+}
+
+func (c *priceController) UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) error {
+	if at.Before(c.at[productId]) {
+		return nil
+	}
+
+	c.prices[productId], c.at[productId] = price, at
+	return nil
+}
+
+func TestHandlerSkipsStalePrice(t *testing.T) {
+	ch := &fakeChannel{}
+	c := &priceController{prices: map[string]float32{}, at: map[string]time.Time{}}
+	h := newTestHandler(c, ch)
+
+	newer, _ := delivery(`{"id": "p1", "price": 90, "updated_at": "2020-01-02T10:00:00Z"}`, nil)
+	newer.MessageId = "m2"
+	h.ProductPriceUpdated(context.Background(), newer)
+
+	// the older price is delivered late, it carries no updated_at so its timestamp orders it
+	stale, a := delivery(`{"id": "p1", "price": 100}`, nil)
+	stale.Timestamp = time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC)
+	h.ProductPriceUpdated(context.Background(), stale)
+
+	if !a.acked || len(ch.published) != 0 {
+		t.Errorf("stale price settled as %+v and published %v, want acked only", *a, ch.published)
+	}
+	if c.prices["p1"] != 90 {
+		t.Errorf("price = %v, want the newer 90", c.prices["p1"])
+	}
+	if !c.processed[eventId(exProductPriceUpdated, stale)] {
+		t.Error("stale price was not marked processed")
+	}
+}
+
+func TestHandlerErasesDeletedUser(t *testing.T) {
+	ch := &fakeChannel{}
+	c := &fakeController{}
+	h := newTestHandler(c, ch)
+
+	d, a := delivery(`{"id": "u1"}`, nil)
+	h.UserDeleted(context.Background(), d)
+
+	if !a.acked || len(ch.published) != 0 {
+		t.Errorf("delivery settled as %+v and published %v, want acked only", *a, ch.published)
+	}
+	if len(c.erased) != 1 || c.erased[0] != "u1" {
+		t.Errorf("erased %v, want u1", c.erased)
+	}
+}
+
+func TestHandlerUserDeletedFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		err   error
+		queue string
+	}{
+		{"missing user id", `{}`, nil, deadLetterQueue(exUserDeleted)},
+		{"malformed message", `{"id":`, nil, deadLetterQueue(exUserDeleted)},
+		{"storage failure", `{"id": "u1"}`, errors.New("connection reset"), retryQueue(exUserDeleted, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{}
+			c := &fakeController{err: tt.err}
+			h := newTestHandler(c, ch)
+
+			d, a := delivery(tt.body, nil)
+			h.UserDeleted(context.Background(), d)
+
+			if !a.acked || len(ch.published[tt.queue]) != 1 {
+				t.Errorf("delivery settled as %+v and published %v, want it moved to %s", *a, ch.published, tt.queue)
+			}
+			if len(c.erased) != 0 {
+				t.Errorf("erased %v, want nothing", c.erased)
+			}
+		})
+	}
+}
@@ -1,10 +1,10 @@
 package amqp
 
 import (
-	"fmt"
+	"context"
+	"github.com/pejovski/wish-list/pkg/rabbitmq"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
-	"log"
 )
 
 const (
@@ -12,6 +12,10 @@ const (
 	exProductDeleted      = "product_deleted"
 	exProductPriceUpdated = "product_price_updated"
 
+	exProductAvailabilityUpdated = "product_availability_updated"
+
+	exUserDeleted = "user_deleted"
+
 	queueName = "wish-list"
 
 	exKind        = "fanout"
@@ -19,69 +23,89 @@ const (
 )
 
 type Receiver interface {
-	Receive()
+	Receive(ctx context.Context)
 }
 
 type receiver struct {
-	ch      *amqp.Channel
+	conn    rabbitmq.Connection
 	handler Handler
 }
 
-func NewReceiver(ch *amqp.Channel, h Handler) Receiver {
+func NewReceiver(conn rabbitmq.Connection, h Handler) Receiver {
 	s := receiver{
-		ch:      ch,
+		conn:    conn,
 		handler: h,
 	}
 
 	return s
 }
 
-func (r receiver) Receive() {
-	if err := r.ch.Qos(
+// Receive consumes on every channel the connection opens, the consumers stop when their channel closes
+func (r receiver) Receive(ctx context.Context) {
+	r.conn.Setup(func(ch *amqp.Channel) error {
+		return r.consume(ctx, ch)
+	})
+}
+
+func (r receiver) consume(ctx context.Context, ch *amqp.Channel) error {
+	if err := ch.Qos(
 		prefetchCount,
 		0,
 		false,
 	); err != nil {
-		logrus.Fatalln("Failed to set Qos", err)
+		logrus.Errorln("Failed to set Qos", err)
+		return err
 	}
 
-	exchanges := []string{exProductUpdated, exProductDeleted, exProductPriceUpdated}
-
-	for _, ex := range exchanges {
+	for _, ex := range Exchanges {
 
-		dCh := r.deliveryCh(ex)
+		dCh, err := r.deliveryCh(ch, ex)
+		if err != nil {
+			return err
+		}
 
 		switch ex {
 		case exProductUpdated:
 			go func() {
 				for d := range dCh {
-					r.handler.ProductUpdated(&d)
+					r.handler.ProductUpdated(ctx, &d)
 				}
 			}()
 		case exProductDeleted:
 			go func() {
 				for d := range dCh {
-					r.handler.ProductDeleted(&d)
+					r.handler.ProductDeleted(ctx, &d)
 				}
 			}()
 
 		case exProductPriceUpdated:
 			go func() {
 				for d := range dCh {
-					r.handler.ProductPriceUpdated(&d)
+					r.handler.ProductPriceUpdated(ctx, &d)
+				}
+			}()
+		case exProductAvailabilityUpdated:
+			go func() {
+				for d := range dCh {
+					r.handler.ProductAvailabilityUpdated(ctx, &d)
+				}
+			}()
+		case exUserDeleted:
+			go func() {
+				for d := range dCh {
+					r.handler.UserDeleted(ctx, &d)
 				}
 			}()
-		default:
-			return
 		}
 	}
 
+	return nil
 }
 
-func (r *receiver) deliveryCh(ex string) <-chan amqp.Delivery {
-	queue := fmt.Sprintf("%s:%s", ex, queueName)
+func (r receiver) deliveryCh(ch *amqp.Channel, ex string) (<-chan amqp.Delivery, error) {
+	queue := queue(ex)
 
-	_, err := r.ch.QueueDeclare(
+	_, err := ch.QueueDeclare(
 		queue,
 		true,
 		false,
@@ -90,10 +114,11 @@ func (r *receiver) deliveryCh(ex string) <-chan amqp.Delivery {
 		nil,
 	)
 	if err != nil {
-		logrus.Fatalln("Failed to declare a queue", err)
+		logrus.Errorln("Failed to declare a queue", err)
+		return nil, err
 	}
 
-	err = r.ch.ExchangeDeclare(
+	err = ch.ExchangeDeclare(
 		ex,
 		exKind,
 		true,
@@ -103,10 +128,11 @@ func (r *receiver) deliveryCh(ex string) <-chan amqp.Delivery {
 		nil,
 	)
 	if err != nil {
-		log.Fatalf("%s %s: %s", "Failed to declare an exchange", ex, err)
+		logrus.Errorf("%s %s: %s", "Failed to declare an exchange", ex, err)
+		return nil, err
 	}
 
-	err = r.ch.QueueBind(
+	err = ch.QueueBind(
 		queue,
 		"",
 		ex,
@@ -114,12 +140,23 @@ func (r *receiver) deliveryCh(ex string) <-chan amqp.Delivery {
 		nil,
 	)
 	if err != nil {
-		logrus.Fatalln("Failed to bind a queue", err)
+		logrus.Errorln("Failed to bind a queue", err)
+		return nil, err
+	}
+
+	if err := declareRetryQueues(ch, ex); err != nil {
+		logrus.Errorln("Failed to declare the retry queues", err)
+		return nil, err
+	}
+
+	if err := declareDeadLetterQueue(ch, ex); err != nil {
+		logrus.Errorln("Failed to declare the dead letter queue", err)
+		return nil, err
 	}
 
 	logrus.Infof("RabbitMQ queue %s declared\n", queue)
 
-	msgs, err := r.ch.Consume(
+	msgs, err := ch.Consume(
 		queue,
 		"",
 		false,
@@ -129,8 +166,9 @@ func (r *receiver) deliveryCh(ex string) <-chan amqp.Delivery {
 		nil,
 	)
 	if err != nil {
-		logrus.Fatalln("Failed to register a consumer", err)
+		logrus.Errorln("Failed to register a consumer", err)
+		return nil, err
 	}
 
-	return msgs
+	return msgs, nil
 }
@@ -0,0 +1,72 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// maxAttempts is how many times a delivery is handled before it's dead-lettered
+	maxAttempts = 5
+	// retryBaseDelay is the delay of the first retry, every next retry waits twice as long
+	retryBaseDelay = time.Second
+)
+
+// Exchanges are the product and user events the wish list consumes
+var Exchanges = []string{exProductUpdated, exProductDeleted, exProductPriceUpdated, exProductAvailabilityUpdated, exUserDeleted}
+
+func queue(ex string) string {
+	return fmt.Sprintf("%s:%s", ex, queueName)
+}
+
+// retryQueue holds the deliveries of the queue failed the given number of times until their delay expires,
+// then they are dead-lettered back to the queue through the default exchange
+func retryQueue(ex string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue(ex), attempt)
+}
+
+// deadLetterQueue keeps the deliveries which exhausted the retries or can't succeed, until they are replayed
+func deadLetterQueue(ex string) string {
+	return fmt.Sprintf("%s.dead", queue(ex))
+}
+
+func retryDelay(attempt int) time.Duration {
+	return retryBaseDelay << uint(attempt-1)
+}
+
+// declareRetryQueues declares a retry queue per attempt, the delays grow exponentially
+func declareRetryQueues(ch *amqp.Channel, ex string) error {
+	for attempt := 1; attempt < maxAttempts; attempt++ {
+		_, err := ch.QueueDeclare(
+			retryQueue(ex, attempt),
+			true,
+			false,
+			false,
+			false,
+			amqp.Table{
+				"x-message-ttl":             int32(retryDelay(attempt) / time.Millisecond),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue(ex),
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func declareDeadLetterQueue(ch *amqp.Channel, ex string) error {
+	_, err := ch.QueueDeclare(
+		deadLetterQueue(ex),
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	return err
+}
@@ -0,0 +1,100 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// every document bucket has index buckets keyed by the field and the document key,
+// the index values are empty, a prefix scan of the field finds the documents
+var (
+	itemsBucket          = []byte("items")
+	itemsByProductBucket = []byte("items_by_product")
+	listsBucket          = []byte("lists")
+	listsByUserBucket    = []byte("lists_by_user")
+	sharesBucket         = []byte("shares")
+	sharesByListBucket   = []byte("shares_by_list")
+	alertsBucket         = []byte("alerts")
+	pricesBucket         = []byte("price_history")
+	eventsBucket         = []byte("events")
+	eventsByExpiryBucket = []byte("events_by_expiry")
+	outboxBucket         = []byte("outbox")
+	erasuresBucket       = []byte("erasures")
+)
+
+var buckets = [][]byte{
+	itemsBucket,
+	itemsByProductBucket,
+	listsBucket,
+	listsByUserBucket,
+	sharesBucket,
+	sharesByListBucket,
+	alertsBucket,
+	pricesBucket,
+	eventsBucket,
+	eventsByExpiryBucket,
+	outboxBucket,
+	erasuresBucket,
+}
+
+const keySeparator = "\x00"
+
+// key joins the parts, ids never contain the separator
+func key(parts ...string) []byte {
+	return []byte(strings.Join(parts, keySeparator))
+}
+
+// prefix is the key of the parts followed by the separator, so "list-1" doesn't match "list-10"
+func prefix(parts ...string) []byte {
+	return append(key(parts...), keySeparator...)
+}
+
+// lastPart returns the document key stored at the end of an index key
+func lastPart(k []byte) string {
+	i := bytes.LastIndex(k, []byte(keySeparator))
+	return string(k[i+1:])
+}
+
+// timeKey orders the keys by time, seq keeps the keys of the same time unique
+func timeKey(t time.Time, seq uint64) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(b[8:], seq)
+	return b
+}
+
+// scan calls fn for every key with the prefix in key order
+func scan(b *bbolt.Bucket, p []byte, fn func(k []byte, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// get decodes the document into v, it returns false when there is none
+func get(b *bbolt.Bucket, k []byte, v interface{}) (bool, error) {
+	data := b.Get(k)
+	if data == nil {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(data, v)
+}
+
+func put(b *bbolt.Bucket, k []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(k, data)
+}
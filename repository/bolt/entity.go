@@ -0,0 +1,135 @@
+package bolt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+type Item struct {
+	Seq       uint64  `json:"seq"`
+	UserId    string  `json:"user_id"`
+	ListId    string  `json:"list_id"`
+	ProductId string  `json:"product_id"`
+	Name      string  `json:"name"`
+	Brand     string  `json:"brand"`
+	Price     float32 `json:"price"`
+	Image     string  `json:"image"`
+	Active    bool    `json:"active"`
+
+	Quantity int               `json:"quantity"`
+	Priority string            `json:"priority"`
+	Note     string            `json:"note,omitempty"`
+	Variant  map[string]string `json:"variant,omitempty"`
+
+	// Priced is false until the item gets its first price from the catalog
+	Priced                bool    `json:"priced"`
+	PriceWhenAdded        float32 `json:"price_when_added"`
+	LowestPriceSinceAdded float32 `json:"lowest_price_since_added"`
+	// PriceAt is when the price was set, an older price doesn't overwrite it
+	PriceAt time.Time `json:"price_at"`
+
+	Reservation *Reservation `json:"reservation,omitempty"`
+	AlertRule   *AlertRule   `json:"alert_rule,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Reservation struct {
+	ClaimId   string    `json:"claim_id"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type List struct {
+	Id      string `json:"id"`
+	UserId  string `json:"user_id"`
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+}
+
+type Share struct {
+	Token     string    `json:"token"`
+	UserId    string    `json:"user_id"`
+	ListId    string    `json:"list_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AlertRule struct {
+	TargetPrice    float32 `json:"target_price"`
+	DropPercentage float32 `json:"drop_percentage"`
+	ReferencePrice float32 `json:"reference_price"`
+}
+
+type Alert struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"user_id"`
+	ListId      string    `json:"list_id"`
+	ProductId   string    `json:"product_id"`
+	OldPrice    float32   `json:"old_price"`
+	NewPrice    float32   `json:"new_price"`
+	TargetPrice float32   `json:"target_price"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type PricePoint struct {
+	Price      float32   `json:"price"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+type Event struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+func (i *Item) setProduct(product *model.Product) {
+	i.Name = product.Name
+	i.Brand = product.Brand
+	i.Image = product.Image
+	i.setPrice(product.Price)
+	i.PriceAt = time.Now().UTC()
+}
+
+// setPrice keeps the first known price and the lowest one since the item was added
+func (i *Item) setPrice(price float32) {
+	i.Price = price
+
+	if !i.Priced {
+		i.Priced = true
+		i.PriceWhenAdded = price
+		i.LowestPriceSinceAdded = price
+		return
+	}
+
+	if price < i.LowestPriceSinceAdded {
+		i.LowestPriceSinceAdded = price
+	}
+}
+
+// reserved treats expired reservations as not reserved
+func (i *Item) reserved(now time.Time) bool {
+	if i.Reservation == nil {
+		return false
+	}
+
+	return i.Reservation.Status != model.ReservationStatusReserved || i.Reservation.ExpiresAt.After(now)
+}
+
+type Erasure struct {
+	Id           string    `json:"id"`
+	UserId       string    `json:"user_id"`
+	Source       string    `json:"source"`
+	Lists        int       `json:"lists"`
+	Items        int       `json:"items"`
+	Reservations int       `json:"reservations"`
+	Shares       int       `json:"shares"`
+	Alerts       int       `json:"alerts"`
+	PricePoints  int       `json:"price_points"`
+	Events       int       `json:"events"`
+	ErasedAt     time.Time `json:"erased_at"`
+}
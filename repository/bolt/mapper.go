@@ -0,0 +1,204 @@
+package bolt
+
+import (
+	"time"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+func mapItemToDomainItem(item *Item) *model.Item {
+	return &model.Item{
+		Product:               mapItemToDomainProduct(item),
+		Active:                item.Active,
+		Quantity:              item.Quantity,
+		Priority:              item.Priority,
+		Note:                  item.Note,
+		Variant:               item.Variant,
+		PriceWhenAdded:        item.PriceWhenAdded,
+		LowestPriceSinceAdded: item.LowestPriceSinceAdded,
+		Reservation:           mapReservationToDomainReservation(item.Reservation),
+		AlertRule:             mapAlertRuleToDomainAlertRule(item.AlertRule),
+		CreatedAt:             item.CreatedAt,
+		UpdatedAt:             item.UpdatedAt,
+	}
+}
+
+func mapItemToDomainProduct(item *Item) *model.Product {
+	return &model.Product{
+		ProductId: item.ProductId,
+		Name:      item.Name,
+		Brand:     item.Brand,
+		Price:     item.Price,
+		Image:     item.Image,
+	}
+}
+
+func mapItemToDomainPriceWatch(item *Item) *model.PriceWatch {
+	return &model.PriceWatch{
+		UserId:    item.UserId,
+		ListId:    item.ListId,
+		ProductId: item.ProductId,
+		Price:     item.Price,
+		Rule:      mapAlertRuleToDomainAlertRule(item.AlertRule),
+	}
+}
+
+func mapAlertRuleToDomainAlertRule(rule *AlertRule) *model.AlertRule {
+	if rule == nil {
+		return nil
+	}
+
+	return &model.AlertRule{
+		TargetPrice:    rule.TargetPrice,
+		DropPercentage: rule.DropPercentage,
+		ReferencePrice: rule.ReferencePrice,
+	}
+}
+
+func mapDomainAlertRuleToAlertRule(rule *model.AlertRule) *AlertRule {
+	return &AlertRule{
+		TargetPrice:    rule.TargetPrice,
+		DropPercentage: rule.DropPercentage,
+		ReferencePrice: rule.ReferencePrice,
+	}
+}
+
+// expired reservations are returned to the pool, so they are mapped as no reservation
+func mapReservationToDomainReservation(reservation *Reservation) *model.Reservation {
+	if reservation == nil {
+		return nil
+	}
+
+	if reservation.Status != model.ReservationStatusReserved {
+		return &model.Reservation{Status: reservation.Status}
+	}
+
+	if !reservation.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+
+	expiresAt := reservation.ExpiresAt
+	return &model.Reservation{
+		Status:    reservation.Status,
+		ExpiresAt: &expiresAt,
+	}
+}
+
+func mapListToDomainList(list *List) *model.List {
+	return &model.List{
+		Id:      list.Id,
+		UserId:  list.UserId,
+		Name:    list.Name,
+		Default: list.Default,
+	}
+}
+
+func mapShareToDomainShare(share *Share) *model.Share {
+	return &model.Share{
+		Token:     share.Token,
+		UserId:    share.UserId,
+		ListId:    share.ListId,
+		CreatedAt: share.CreatedAt,
+	}
+}
+
+func mapDomainShareToShare(share *model.Share) *Share {
+	return &Share{
+		Token:     share.Token,
+		UserId:    share.UserId,
+		ListId:    share.ListId,
+		CreatedAt: share.CreatedAt,
+	}
+}
+
+func mapAlertToDomainAlert(alert *Alert) *model.PriceAlert {
+	return &model.PriceAlert{
+		Id:          alert.Id,
+		UserId:      alert.UserId,
+		ListId:      alert.ListId,
+		ProductId:   alert.ProductId,
+		OldPrice:    alert.OldPrice,
+		NewPrice:    alert.NewPrice,
+		TargetPrice: alert.TargetPrice,
+		CreatedAt:   alert.CreatedAt,
+	}
+}
+
+func mapDomainAlertToAlert(alert *model.PriceAlert) *Alert {
+	return &Alert{
+		Id:          alert.Id,
+		UserId:      alert.UserId,
+		ListId:      alert.ListId,
+		ProductId:   alert.ProductId,
+		OldPrice:    alert.OldPrice,
+		NewPrice:    alert.NewPrice,
+		TargetPrice: alert.TargetPrice,
+		CreatedAt:   alert.CreatedAt,
+	}
+}
+
+func mapPricePointToDomainPricePoint(point *PricePoint) *model.PricePoint {
+	return &model.PricePoint{
+		Price:      point.Price,
+		RecordedAt: point.RecordedAt,
+	}
+}
+
+func mapDomainPricePointToPricePoint(point *model.PricePoint) *PricePoint {
+	return &PricePoint{
+		Price:      point.Price,
+		RecordedAt: point.RecordedAt,
+	}
+}
+
+func mapEventToDomainEvent(event *Event) *model.Event {
+	return &model.Event{
+		Id:         event.Id,
+		Type:       event.Type,
+		Version:    event.Version,
+		OccurredAt: event.OccurredAt,
+		Data:       event.Data,
+	}
+}
+
+func mapDomainEventToEvent(event *model.Event) *Event {
+	return &Event{
+		Id:         event.Id,
+		Type:       event.Type,
+		Version:    event.Version,
+		OccurredAt: event.OccurredAt,
+		Data:       event.Data,
+	}
+}
+
+func mapErasureToDomainErasure(erasure *Erasure) *model.Erasure {
+	return &model.Erasure{
+		Id:           erasure.Id,
+		UserId:       erasure.UserId,
+		Source:       erasure.Source,
+		Lists:        erasure.Lists,
+		Items:        erasure.Items,
+		Reservations: erasure.Reservations,
+		Shares:       erasure.Shares,
+		Alerts:       erasure.Alerts,
+		PricePoints:  erasure.PricePoints,
+		Events:       erasure.Events,
+		ErasedAt:     erasure.ErasedAt,
+	}
+}
+
+func mapDomainErasureToErasure(erasure *model.Erasure) *Erasure {
+	return &Erasure{
+		Id:           erasure.Id,
+		UserId:       erasure.UserId,
+		Source:       erasure.Source,
+		Lists:        erasure.Lists,
+		Items:        erasure.Items,
+		Reservations: erasure.Reservations,
+		Shares:       erasure.Shares,
+		Alerts:       erasure.Alerts,
+		PricePoints:  erasure.PricePoints,
+		Events:       erasure.Events,
+		ErasedAt:     erasure.ErasedAt,
+	}
+}
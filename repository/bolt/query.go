@@ -0,0 +1,27 @@
+package bolt
+
+import (
+	"time"
+
+	"github.com/pejovski/wish-list/repository/itemquery"
+)
+
+func queryItem(item *Item, now time.Time) itemquery.Item {
+	return itemquery.Item{
+		Key:       sortKey(item),
+		Priced:    item.Priced,
+		Brand:     item.Brand,
+		Active:    item.Active,
+		Reserved:  item.reserved(now),
+		CreatedAt: item.CreatedAt,
+	}
+}
+
+func sortKey(item *Item) itemquery.Key {
+	return itemquery.Key{
+		Seq:      item.Seq,
+		Price:    item.Price,
+		Name:     item.Name,
+		Priority: item.Priority,
+	}
+}
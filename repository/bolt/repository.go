@@ -0,0 +1,1284 @@
+package bolt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	repo "github.com/pejovski/wish-list/repository"
+	"github.com/pejovski/wish-list/repository/itemquery"
+)
+
+const defaultListName = "Wish List"
+
+type repository struct {
+	db *bbolt.DB
+}
+
+// NewRepository stores the wish lists in an embedded bbolt file, so the service runs without MongoDB
+func NewRepository(db *bbolt.DB) (repo.Repository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Failed to create bolt buckets Error: %s", err)
+		return nil, err
+	}
+
+	return repository{db: db}, nil
+}
+
+// get product with full data
+func (r repository) Product(ctx context.Context, productId string) (*model.Product, error) {
+
+	var product *model.Product
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return forProductItems(tx, productId, func(item *Item) (bool, error) {
+			if item.Priced && product == nil {
+				product = mapItemToDomainProduct(item)
+			}
+			return false, nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Get failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (r repository) UpdateProduct(ctx context.Context, product *model.Product) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		if err := recordPrice(tx, product.ProductId, product.Price); err != nil {
+			return err
+		}
+
+		// a product the catalog returned is active again
+		now := time.Now().UTC()
+		return forProductItems(tx, product.ProductId, func(item *Item) (bool, error) {
+			item.setProduct(product)
+			item.Active = true
+			item.UpdatedAt = now
+			return true, nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for product %s; Error: %s", product.ProductId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeactivateProduct(ctx context.Context, productId string) error {
+	return r.setProductActive(productId, false)
+}
+
+func (r repository) ActivateProduct(ctx context.Context, productId string) error {
+	return r.setProductActive(productId, true)
+}
+
+func (r repository) setProductActive(productId string, active bool) error {
+	err := r.updateProductItems(productId, func(item *Item) {
+		item.Active = active
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for active %t of product %s; Error: %s", active, productId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteProduct(ctx context.Context, productId string) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		listIds, err := productListIds(tx, productId)
+		if err != nil {
+			return err
+		}
+
+		for _, listId := range listIds {
+			if err := deleteItem(tx, listId, productId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Delete failed for product %s; Error: %s", productId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) (bool, error) {
+	stale := false
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		// the items which are not enriched yet get the price with the product
+		priced := false
+		err := forProductItems(tx, productId, func(item *Item) (bool, error) {
+			priced = priced || item.Priced
+			stale = stale || (item.Priced && item.PriceAt.After(at))
+			return false, nil
+		})
+		if err != nil || stale || !priced {
+			return err
+		}
+
+		if err := recordPrice(tx, productId, price); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		return forProductItems(tx, productId, func(item *Item) (bool, error) {
+			if !item.Priced {
+				return false, nil
+			}
+
+			item.setPrice(price)
+			item.PriceAt = at
+			item.UpdatedAt = now
+			return true, nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for price of product %s; Error: %s", productId, err)
+		return false, err
+	}
+
+	return stale, nil
+}
+
+// updateProductItems applies the update to the product in every list through the product index
+func (r repository) updateProductItems(productId string, update func(item *Item)) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		now := time.Now().UTC()
+		return forProductItems(tx, productId, func(item *Item) (bool, error) {
+			update(item)
+			item.UpdatedAt = now
+			return true, nil
+		})
+	})
+}
+
+func (r repository) PriceHistory(ctx context.Context, productId string) ([]*model.PricePoint, error) {
+
+	points := []*model.PricePoint{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return scan(tx.Bucket(pricesBucket), prefix(productId), func(k []byte, v []byte) error {
+			var point *PricePoint
+			if err := json.Unmarshal(v, &point); err != nil {
+				return err
+			}
+			points = append(points, mapPricePointToDomainPricePoint(point))
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Scan price history failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return points, nil
+}
+
+func (r repository) Item(ctx context.Context, listId string, productId string) (*model.Item, error) {
+
+	var item *Item
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		item, err = getItem(tx, listId, productId)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Get failed for product %s, list %s Error: %s", productId, listId, err)
+		return nil, err
+	}
+
+	if item == nil {
+		logrus.Infof("No document for product %s, list %s", productId, listId)
+		return nil, nil
+	}
+
+	return mapItemToDomainItem(item), nil
+}
+
+func (r repository) CreateItem(ctx context.Context, userId string, listId string, productId string) error {
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		existing, err := getItem(tx, listId, productId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return myerr.ErrItemAlreadyExist
+		}
+
+		seq, err := tx.Bucket(itemsBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		return putItem(tx, &Item{
+			Seq:       seq,
+			UserId:    userId,
+			ListId:    listId,
+			ProductId: productId,
+			Active:    true,
+			Quantity:  1,
+			Priority:  model.PriorityNiceToHave,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	})
+	if errors.Is(err, myerr.ErrItemAlreadyExist) {
+		return err
+	}
+	if err != nil {
+		logrus.Errorf("Put failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteItem(ctx context.Context, listId string, productId string, event *model.Event) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		item, err := getItem(tx, listId, productId)
+		if err != nil || item == nil {
+			// nothing removed, nothing to tell
+			return err
+		}
+
+		if err := deleteItem(tx, listId, productId); err != nil {
+			return err
+		}
+		return putEvent(tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Delete failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) UpdateItem(ctx context.Context, listId string, product *model.Product, event *model.Event) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		item, err := getItem(tx, listId, product.ProductId)
+		if err != nil {
+			return err
+		}
+
+		if item != nil {
+			item.setProduct(product)
+			item.UpdatedAt = time.Now().UTC()
+			if err := putItem(tx, item); err != nil {
+				return err
+			}
+		}
+
+		return putEvent(tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for product %s, list %s; Error: %s", product.ProductId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) UpdateItemDetails(ctx context.Context, listId string, productId string, details *model.ItemDetails) error {
+
+	if details.Quantity == nil && details.Priority == nil && details.Note == nil && details.Variant == nil {
+		return nil
+	}
+
+	_, err := r.updateItem(listId, productId, func(item *Item) bool {
+		if details.Quantity != nil {
+			item.Quantity = *details.Quantity
+		}
+		if details.Priority != nil {
+			item.Priority = *details.Priority
+		}
+		if details.Note != nil {
+			item.Note = *details.Note
+		}
+		if details.Variant != nil {
+			item.Variant = details.Variant
+		}
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for details of product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+// updateItem applies the update when the item exists and the update accepts it,
+// the check and the write run in one transaction
+func (r repository) updateItem(listId string, productId string, update func(item *Item) bool) (bool, error) {
+	updated := false
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		item, err := getItem(tx, listId, productId)
+		if err != nil || item == nil {
+			return err
+		}
+
+		if !update(item) {
+			return nil
+		}
+
+		item.UpdatedAt = time.Now().UTC()
+		updated = true
+		return putItem(tx, item)
+	})
+
+	return updated, err
+}
+
+func (r repository) Items(ctx context.Context, listId string, query *model.ItemQuery) (model.Items, string, error) {
+
+	var after *itemquery.Key
+	if query.Cursor != "" {
+		c, err := itemquery.DecodeCursor(query.Cursor)
+		if err != nil {
+			logrus.Errorf("Scan items failed for list %s Error: %s", listId, err)
+			return nil, "", err
+		}
+		after = c
+	}
+
+	matched := []*Item{}
+	now := time.Now()
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return scan(tx.Bucket(itemsBucket), prefix(listId), func(k []byte, v []byte) error {
+			var item *Item
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+
+			if !itemquery.Matches(queryItem(item, now), query) {
+				return nil
+			}
+			if after != nil && itemquery.Compare(sortKey(item), *after, query) <= 0 {
+				return nil
+			}
+
+			matched = append(matched, item)
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Scan items failed for list %s Error: %s", listId, err)
+		return nil, "", err
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return itemquery.Compare(sortKey(matched[i]), sortKey(matched[j]), query) < 0
+	})
+
+	items := model.Items{}
+	for _, item := range matched {
+		if len(items) == query.Limit {
+			return items, itemquery.EncodeCursor(sortKey(matched[len(items)-1])), nil
+		}
+
+		items = append(items, mapItemToDomainItem(item))
+	}
+
+	return items, "", nil
+}
+
+func (r repository) Lists(ctx context.Context, userId string) ([]*model.List, error) {
+
+	lists := []*model.List{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return scan(tx.Bucket(listsByUserBucket), prefix(userId), func(k []byte, v []byte) error {
+			var list *List
+			if _, err := get(tx.Bucket(listsBucket), []byte(lastPart(k)), &list); err != nil {
+				return err
+			}
+			lists = append(lists, mapListToDomainList(list))
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Scan lists failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (r repository) List(ctx context.Context, userId string, listId string) (*model.List, error) {
+
+	var list *List
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		list, err = getList(tx, userId, listId)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Get failed for list %s, user %s Error: %s", listId, userId, err)
+		return nil, err
+	}
+
+	if list == nil {
+		logrus.Infof("No document for list %s, user %s", listId, userId)
+		return nil, nil
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+func (r repository) DefaultList(ctx context.Context, userId string) (*model.List, error) {
+
+	var list *List
+	err := r.db.View(func(tx *bbolt.Tx) (err error) {
+		list, err = defaultList(tx, userId)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("View failed for default list, user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	if list == nil {
+		return nil, nil
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+// EnsureDefaultList returns the user's default list, creating it on first access
+func (r repository) EnsureDefaultList(ctx context.Context, userId string) (*model.List, error) {
+
+	var list *List
+	err := r.db.Update(func(tx *bbolt.Tx) (err error) {
+		list, err = defaultList(tx, userId)
+		if err != nil || list != nil {
+			return err
+		}
+
+		list = &List{
+			Id:      newId(),
+			UserId:  userId,
+			Name:    defaultListName,
+			Default: true,
+		}
+		return putList(tx, list)
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for default list, user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+func (r repository) CreateList(ctx context.Context, userId string, name string) (*model.List, error) {
+
+	list := &List{
+		Id:     newId(),
+		UserId: userId,
+		Name:   name,
+	}
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		return putList(tx, list)
+	})
+	if err != nil {
+		logrus.Errorf("Put failed for list %s, user %s Error: %s", name, userId, err)
+		return nil, err
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+func (r repository) RenameList(ctx context.Context, userId string, listId string, name string) error {
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		list, err := getList(tx, userId, listId)
+		if err != nil || list == nil {
+			return err
+		}
+
+		list.Name = name
+		return putList(tx, list)
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteList(ctx context.Context, userId string, listId string) error {
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		list, err := getList(tx, userId, listId)
+		if err != nil || list == nil {
+			return err
+		}
+
+		return deleteList(tx, userId, listId, &model.Erasure{})
+	})
+	if err != nil {
+		logrus.Errorf("Delete failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Share(ctx context.Context, token string) (*model.Share, error) {
+
+	var share *Share
+	var found bool
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		found, err = get(tx.Bucket(sharesBucket), []byte(token), &share)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Get failed for share token Error: %s", err)
+		return nil, err
+	}
+
+	if !found {
+		logrus.Infoln("No document for share token")
+		return nil, nil
+	}
+
+	return mapShareToDomainShare(share), nil
+}
+
+func (r repository) Shares(ctx context.Context, listId string) ([]*model.Share, error) {
+
+	shares := []*model.Share{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		tokens, err := listShareTokens(tx, listId)
+		if err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			var share *Share
+			if _, err := get(tx.Bucket(sharesBucket), []byte(token), &share); err != nil {
+				return err
+			}
+			shares = append(shares, mapShareToDomainShare(share))
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Scan shares failed for list %s Error: %s", listId, err)
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+func (r repository) CreateShare(ctx context.Context, share *model.Share, event *model.Event) error {
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		if err := put(tx.Bucket(sharesBucket), []byte(share.Token), mapDomainShareToShare(share)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(sharesByListBucket).Put(key(share.ListId, share.Token), []byte{}); err != nil {
+			return err
+		}
+		return putEvent(tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Put failed for share of list %s Error: %s", share.ListId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteShare(ctx context.Context, listId string, token string) error {
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		return deleteShare(tx, listId, token)
+	})
+	if err != nil {
+		logrus.Errorf("Delete failed for share of list %s Error: %s", listId, err)
+		return err
+	}
+
+	return nil
+}
+
+// ReserveItem claims the item only if it's not reserved or the reservation has expired
+func (r repository) ReserveItem(ctx context.Context, listId string, productId string, claimId string, expiresAt time.Time) (bool, error) {
+
+	reserved, err := r.updateItem(listId, productId, func(item *Item) bool {
+		if item.reserved(time.Now()) {
+			return false
+		}
+
+		item.Reservation = &Reservation{
+			ClaimId:   claimId,
+			Status:    model.ReservationStatusReserved,
+			ExpiresAt: expiresAt,
+		}
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for reserving product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	return reserved, nil
+}
+
+func (r repository) ReleaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+
+	released, err := r.updateItem(listId, productId, func(item *Item) bool {
+		if !claimed(item, claimId) {
+			return false
+		}
+
+		item.Reservation = nil
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for releasing product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	return released, nil
+}
+
+func (r repository) PurchaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+
+	purchased, err := r.updateItem(listId, productId, func(item *Item) bool {
+		if !claimed(item, claimId) {
+			return false
+		}
+
+		item.Reservation.Status = model.ReservationStatusPurchased
+		item.Reservation.ExpiresAt = time.Time{}
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for purchasing product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	return purchased, nil
+}
+
+// claimed tells if the item is reserved with the claim
+func claimed(item *Item, claimId string) bool {
+	return item.Reservation != nil &&
+		item.Reservation.ClaimId == claimId &&
+		item.Reservation.Status == model.ReservationStatusReserved
+}
+
+// SetAlertRule sets the alert rule of the item or removes it when the rule is nil
+func (r repository) SetAlertRule(ctx context.Context, listId string, productId string, rule *model.AlertRule) error {
+
+	_, err := r.updateItem(listId, productId, func(item *Item) bool {
+		item.AlertRule = nil
+		if rule != nil {
+			item.AlertRule = mapDomainAlertRuleToAlertRule(rule)
+		}
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for alert rule of product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) PriceWatches(ctx context.Context, productId string) ([]*model.PriceWatch, error) {
+
+	watches := []*model.PriceWatch{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return forProductItems(tx, productId, func(item *Item) (bool, error) {
+			if item.AlertRule != nil {
+				watches = append(watches, mapItemToDomainPriceWatch(item))
+			}
+			return false, nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Scan price watches failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return watches, nil
+}
+
+func (r repository) CreateAlert(ctx context.Context, alert *model.PriceAlert, event *model.Event) error {
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(alertsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		k := append(prefix(alert.UserId), timeKey(alert.CreatedAt, seq)...)
+		if err := put(b, k, mapDomainAlertToAlert(alert)); err != nil {
+			return err
+		}
+		return putEvent(tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Put failed for alert of product %s, user %s Error: %s", alert.ProductId, alert.UserId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Alerts(ctx context.Context, userId string) ([]*model.PriceAlert, error) {
+
+	alerts := []*model.PriceAlert{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return scan(tx.Bucket(alertsBucket), prefix(userId), func(k []byte, v []byte) error {
+			var alert *Alert
+			if err := json.Unmarshal(v, &alert); err != nil {
+				return err
+			}
+			alerts = append(alerts, mapAlertToDomainAlert(alert))
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Scan alerts failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	// the keys are in the created order, the newest alerts come first
+	for i, j := 0, len(alerts)-1; i < j; i, j = i+1, j-1 {
+		alerts[i], alerts[j] = alerts[j], alerts[i]
+	}
+
+	return alerts, nil
+}
+
+func getItem(tx *bbolt.Tx, listId string, productId string) (*Item, error) {
+	var item *Item
+	found, err := get(tx.Bucket(itemsBucket), key(listId, productId), &item)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// putItem writes the item and its product index entry
+func putItem(tx *bbolt.Tx, item *Item) error {
+	if err := put(tx.Bucket(itemsBucket), key(item.ListId, item.ProductId), item); err != nil {
+		return err
+	}
+
+	return tx.Bucket(itemsByProductBucket).Put(key(item.ProductId, item.ListId), []byte{})
+}
+
+func deleteItem(tx *bbolt.Tx, listId string, productId string) error {
+	if err := tx.Bucket(itemsBucket).Delete(key(listId, productId)); err != nil {
+		return err
+	}
+
+	return tx.Bucket(itemsByProductBucket).Delete(key(productId, listId))
+}
+
+// productListIds returns the lists the product is in from the product index
+func productListIds(tx *bbolt.Tx, productId string) ([]string, error) {
+	listIds := []string{}
+	err := scan(tx.Bucket(itemsByProductBucket), prefix(productId), func(k []byte, v []byte) error {
+		listIds = append(listIds, lastPart(k))
+		return nil
+	})
+
+	return listIds, err
+}
+
+// forProductItems calls fn for every item of the product, the item is written back when fn returns true
+// recordPrice appends the price to the history of a wish-listed product if it differs from the stored one,
+// it is called before the items get the price
+func recordPrice(tx *bbolt.Tx, productId string, price float32) error {
+	listed, changed := false, true
+	err := forProductItems(tx, productId, func(item *Item) (bool, error) {
+		listed = true
+		if item.Priced && item.Price == price {
+			changed = false
+		}
+		return false, nil
+	})
+	if err != nil || !listed || !changed {
+		return err
+	}
+
+	b := tx.Bucket(pricesBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	point := &model.PricePoint{Price: price, RecordedAt: time.Now().UTC()}
+	k := append(prefix(productId), timeKey(point.RecordedAt, seq)...)
+	return put(b, k, mapDomainPricePointToPricePoint(point))
+}
+
+func forProductItems(tx *bbolt.Tx, productId string, fn func(item *Item) (bool, error)) error {
+	listIds, err := productListIds(tx, productId)
+	if err != nil {
+		return err
+	}
+
+	for _, listId := range listIds {
+		item, err := getItem(tx, listId, productId)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			continue
+		}
+
+		write, err := fn(item)
+		if err != nil {
+			return err
+		}
+
+		if write {
+			if err := putItem(tx, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteList deletes the list with its items and shares and counts them into the erasure
+func deleteList(tx *bbolt.Tx, userId string, listId string, erasure *model.Erasure) error {
+	if err := tx.Bucket(listsBucket).Delete([]byte(listId)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(listsByUserBucket).Delete(key(userId, listId)); err != nil {
+		return err
+	}
+	erasure.Lists++
+
+	items := []*Item{}
+	err := scan(tx.Bucket(itemsBucket), prefix(listId), func(k []byte, v []byte) error {
+		var item *Item
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := deleteItem(tx, listId, item.ProductId); err != nil {
+			return err
+		}
+		erasure.Items++
+		if item.Reservation != nil {
+			erasure.Reservations++
+		}
+	}
+
+	tokens, err := listShareTokens(tx, listId)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := deleteShare(tx, listId, token); err != nil {
+			return err
+		}
+		erasure.Shares++
+	}
+
+	return nil
+}
+
+func getList(tx *bbolt.Tx, userId string, listId string) (*List, error) {
+	var list *List
+	found, err := get(tx.Bucket(listsBucket), []byte(listId), &list)
+	if err != nil || !found || list.UserId != userId {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func defaultList(tx *bbolt.Tx, userId string) (*List, error) {
+	var list *List
+	err := scan(tx.Bucket(listsByUserBucket), prefix(userId), func(k []byte, v []byte) error {
+		var l *List
+		if _, err := get(tx.Bucket(listsBucket), []byte(lastPart(k)), &l); err != nil {
+			return err
+		}
+		if l.Default {
+			list = l
+		}
+		return nil
+	})
+
+	return list, err
+}
+
+func putList(tx *bbolt.Tx, list *List) error {
+	if err := put(tx.Bucket(listsBucket), []byte(list.Id), list); err != nil {
+		return err
+	}
+
+	return tx.Bucket(listsByUserBucket).Put(key(list.UserId, list.Id), []byte{})
+}
+
+func listShareTokens(tx *bbolt.Tx, listId string) ([]string, error) {
+	tokens := []string{}
+	err := scan(tx.Bucket(sharesByListBucket), prefix(listId), func(k []byte, v []byte) error {
+		tokens = append(tokens, lastPart(k))
+		return nil
+	})
+
+	return tokens, err
+}
+
+func deleteShare(tx *bbolt.Tx, listId string, token string) error {
+	var share *Share
+	found, err := get(tx.Bucket(sharesBucket), []byte(token), &share)
+	if err != nil || !found || share.ListId != listId {
+		return err
+	}
+
+	if err := tx.Bucket(sharesBucket).Delete([]byte(token)); err != nil {
+		return err
+	}
+
+	return tx.Bucket(sharesByListBucket).Delete(key(listId, token))
+}
+
+// newId returns a random id with the length of a hex ObjectID
+func newId() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func (r repository) EventProcessed(ctx context.Context, eventId string) (bool, error) {
+	var expiresAt time.Time
+	found := false
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		found, err = get(tx.Bucket(eventsBucket), key(eventId), &expiresAt)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Get failed for event %s Error: %s", eventId, err)
+		return false, err
+	}
+
+	return found && expiresAt.After(time.Now()), nil
+}
+
+// MarkEventProcessed marks the event and removes the expired marks through the expiry index
+func (r repository) MarkEventProcessed(ctx context.Context, eventId string, expiresAt time.Time) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+		byExpiry := tx.Bucket(eventsByExpiryBucket)
+
+		now := uint64(time.Now().UnixNano())
+		c := byExpiry.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= now; k, _ = c.First() {
+			if err := events.Delete(key(lastPart(k))); err != nil {
+				return err
+			}
+			if err := byExpiry.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		var previous time.Time
+		found, err := get(events, key(eventId), &previous)
+		if err != nil {
+			return err
+		}
+		if found {
+			if err := byExpiry.Delete(eventExpiryKey(previous, eventId)); err != nil {
+				return err
+			}
+		}
+
+		if err := byExpiry.Put(eventExpiryKey(expiresAt, eventId), []byte{}); err != nil {
+			return err
+		}
+
+		return put(events, key(eventId), expiresAt)
+	})
+	if err != nil {
+		logrus.Errorf("Put failed for event %s Error: %s", eventId, err)
+		return err
+	}
+
+	return nil
+}
+
+func eventExpiryKey(expiresAt time.Time, eventId string) []byte {
+	return append(timeKey(expiresAt, 0), key("", eventId)...)
+}
+
+func (r repository) PendingEvents(ctx context.Context, limit int) ([]*model.Event, error) {
+	events := []*model.Event{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, v := c.First(); k != nil && len(events) < limit; k, v = c.Next() {
+			var event *Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, mapEventToDomainEvent(event))
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Scan failed for outbox Error: %s", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r repository) MarkEventsPublished(ctx context.Context, events []*model.Event) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		for _, e := range events {
+			if err := b.Delete(outboxKey(e)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Delete failed for outbox Error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) OutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	stats := &model.OutboxStats{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		stats.Pending = b.Stats().KeyN
+
+		k, _ := b.Cursor().First()
+		if k != nil {
+			stats.Oldest = time.Unix(0, int64(binary.BigEndian.Uint64(k))).UTC()
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Stats failed for outbox Error: %s", err)
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// putEvent stores the event in the outbox within the transaction of the change
+func putEvent(tx *bbolt.Tx, event *model.Event) error {
+	if event == nil {
+		return nil
+	}
+
+	return put(tx.Bucket(outboxBucket), outboxKey(event), mapDomainEventToEvent(event))
+}
+
+// outboxKey orders the outbox by the time the events occurred
+func outboxKey(event *model.Event) []byte {
+	return append(timeKey(event.OccurredAt, 0), key("", event.Id)...)
+}
+
+func (r repository) EraseUser(ctx context.Context, erasure *model.Erasure) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		listIds := []string{}
+		err := scan(tx.Bucket(listsByUserBucket), prefix(erasure.UserId), func(k []byte, v []byte) error {
+			listIds = append(listIds, lastPart(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		productIds := map[string]bool{}
+		for _, listId := range listIds {
+			err := scan(tx.Bucket(itemsBucket), prefix(listId), func(k []byte, v []byte) error {
+				productIds[lastPart(k)] = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, listId := range listIds {
+			if err := deleteList(tx, erasure.UserId, listId, erasure); err != nil {
+				return err
+			}
+		}
+
+		if err := erasePriceHistory(tx, productIds, erasure); err != nil {
+			return err
+		}
+
+		if err := eraseEvents(tx, erasure); err != nil {
+			return err
+		}
+
+		alerts := tx.Bucket(alertsBucket)
+		keys := [][]byte{}
+		err = scan(alerts, prefix(erasure.UserId), func(k []byte, v []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := alerts.Delete(k); err != nil {
+				return err
+			}
+			erasure.Alerts++
+		}
+
+		b := tx.Bucket(erasuresBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		k := append(prefix(erasure.UserId), timeKey(erasure.ErasedAt, seq)...)
+		return put(b, k, mapDomainErasureToErasure(erasure))
+	})
+	if err != nil {
+		logrus.Errorf("Erase failed for user %s Error: %s", erasure.UserId, err)
+		return err
+	}
+
+	return nil
+}
+
+// erasePriceHistory deletes the price history of the products which are on no list after the erasure
+func erasePriceHistory(tx *bbolt.Tx, productIds map[string]bool, erasure *model.Erasure) error {
+	prices := tx.Bucket(pricesBucket)
+	for productId := range productIds {
+		listIds, err := productListIds(tx, productId)
+		if err != nil {
+			return err
+		}
+		if len(listIds) > 0 {
+			continue
+		}
+
+		keys := [][]byte{}
+		err = scan(prices, prefix(productId), func(k []byte, v []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := prices.Delete(k); err != nil {
+				return err
+			}
+			erasure.PricePoints++
+		}
+	}
+
+	return nil
+}
+
+// eraseEvents deletes the pending outbox events about the user
+func eraseEvents(tx *bbolt.Tx, erasure *model.Erasure) error {
+	outbox := tx.Bucket(outboxBucket)
+	keys := [][]byte{}
+	err := scan(outbox, nil, func(k []byte, v []byte) error {
+		var event *Event
+		if err := json.Unmarshal(v, &event); err != nil {
+			return err
+		}
+		if repo.EventUserId(mapEventToDomainEvent(event)) == erasure.UserId {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := outbox.Delete(k); err != nil {
+			return err
+		}
+		erasure.Events++
+	}
+
+	return nil
+}
+
+func (r repository) Erasures(ctx context.Context, userId string) ([]*model.Erasure, error) {
+
+	erasures := []*model.Erasure{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return scan(tx.Bucket(erasuresBucket), prefix(userId), func(k []byte, v []byte) error {
+			var erasure *Erasure
+			if err := json.Unmarshal(v, &erasure); err != nil {
+				return err
+			}
+			erasures = append(erasures, mapErasureToDomainErasure(erasure))
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Scan erasures failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	// the keys are in the erased order, the newest erasures come first
+	for i, j := 0, len(erasures)-1; i < j; i, j = i+1, j-1 {
+		erasures[i], erasures[j] = erasures[j], erasures[i]
+	}
+
+	return erasures, nil
+}
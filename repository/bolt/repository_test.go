@@ -0,0 +1,37 @@
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+
+	repo "github.com/pejovski/wish-list/repository"
+	"github.com/pejovski/wish-list/repository/repositorytest"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) (repo.Repository, func()) {
+		dir, err := ioutil.TempDir("", "wish-list-bolt")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %s", err)
+		}
+
+		db, err := bbolt.Open(filepath.Join(dir, "wish.db"), 0600, nil)
+		if err != nil {
+			t.Fatalf("Failed to open bolt db: %s", err)
+		}
+
+		r, err := NewRepository(db)
+		if err != nil {
+			t.Fatalf("NewRepository failed: %s", err)
+		}
+
+		return r, func() {
+			db.Close()
+			os.RemoveAll(dir)
+		}
+	})
+}
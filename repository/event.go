@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+// EventUserId returns the user_id of the event data, every wish list event has one,
+// the erasure deletes the pending events of the user by it
+func EventUserId(event *model.Event) string {
+	data := struct {
+		UserId string `json:"user_id"`
+	}{}
+
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return ""
+	}
+
+	return data.UserId
+}
@@ -0,0 +1,128 @@
+// Package itemquery filters, orders and pages the items of a list for the backends which do it in process.
+package itemquery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+)
+
+// Key orders the items, Seq is the added order and breaks the ties of the sort field.
+// The cursor is the key of the last item of a page.
+type Key struct {
+	Seq      uint64  `json:"seq"`
+	Price    float32 `json:"price,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	Priority string  `json:"priority,omitempty"`
+}
+
+// Item is what the query matches an item by
+type Item struct {
+	Key
+
+	// Priced is false until the item is enriched with the product data
+	Priced    bool
+	Brand     string
+	Active    bool
+	Reserved  bool
+	CreatedAt time.Time
+}
+
+// Matches tells if the item is listed by the query, items which are not enriched yet are not listed
+func Matches(i Item, query *model.ItemQuery) bool {
+	if !i.Priced {
+		return false
+	}
+
+	if query.Active != nil && i.Active != *query.Active {
+		return false
+	}
+
+	if query.Brand != "" && i.Brand != query.Brand {
+		return false
+	}
+
+	if query.MinPrice != nil && i.Price < *query.MinPrice {
+		return false
+	}
+
+	if query.MaxPrice != nil && i.Price > *query.MaxPrice {
+		return false
+	}
+
+	if query.Reserved != nil && i.Reserved != *query.Reserved {
+		return false
+	}
+
+	if query.AddedSince != nil && i.CreatedAt.Before(*query.AddedSince) {
+		return false
+	}
+
+	return true
+}
+
+// Compare orders two keys by the sort field of the query and then by the added order
+func Compare(a Key, b Key, query *model.ItemQuery) int {
+	c := 0
+	switch query.Sort {
+	case model.SortPrice:
+		c = compareFloat(a.Price, b.Price)
+	case model.SortName:
+		c = strings.Compare(a.Name, b.Name)
+	case model.SortPriority:
+		c = strings.Compare(a.Priority, b.Priority)
+	}
+
+	if c == 0 {
+		c = compareSeq(a.Seq, b.Seq)
+	}
+
+	if query.Desc {
+		return -c
+	}
+
+	return c
+}
+
+func EncodeCursor(k Key) string {
+	b, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(value string) (*Key, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	var k Key
+	if err := json.Unmarshal(b, &k); err != nil || k.Seq == 0 {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	return &k, nil
+}
+
+func compareFloat(a float32, b float32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareSeq(a uint64, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
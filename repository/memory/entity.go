@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+type item struct {
+	seq       uint64
+	userId    string
+	listId    string
+	productId string
+	name      string
+	brand     string
+	price     float32
+	image     string
+	active    bool
+
+	quantity int
+	priority string
+	note     string
+	variant  map[string]string
+
+	// priced is false until the item gets its first price from the catalog
+	priced                bool
+	priceWhenAdded        float32
+	lowestPriceSinceAdded float32
+	// priceAt is when the price was set, an older price doesn't overwrite it
+	priceAt time.Time
+
+	reservation *reservation
+	alertRule   *model.AlertRule
+
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+type reservation struct {
+	claimId   string
+	status    string
+	expiresAt time.Time
+}
+
+func (i *item) setProduct(product *model.Product) {
+	i.name = product.Name
+	i.brand = product.Brand
+	i.image = product.Image
+	i.setPrice(product.Price)
+	i.priceAt = time.Now().UTC()
+	i.touch()
+}
+
+// setPrice keeps the first known price and the lowest one since the item was added
+func (i *item) setPrice(price float32) {
+	i.price = price
+
+	if !i.priced {
+		i.priced = true
+		i.priceWhenAdded = price
+		i.lowestPriceSinceAdded = price
+		return
+	}
+
+	if price < i.lowestPriceSinceAdded {
+		i.lowestPriceSinceAdded = price
+	}
+}
+
+func (i *item) touch() {
+	i.updatedAt = time.Now().UTC()
+}
+
+func (i *item) product() *model.Product {
+	return &model.Product{
+		ProductId: i.productId,
+		Name:      i.name,
+		Brand:     i.brand,
+		Price:     i.price,
+		Image:     i.image,
+	}
+}
+
+// reserved treats expired reservations as not reserved
+func (i *item) reserved(now time.Time) bool {
+	if i.reservation == nil {
+		return false
+	}
+
+	return i.reservation.status != model.ReservationStatusReserved || i.reservation.expiresAt.After(now)
+}
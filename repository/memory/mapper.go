@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+func mapItemToDomainItem(i *item) *model.Item {
+	return &model.Item{
+		Product:               i.product(),
+		Active:                i.active,
+		Quantity:              i.quantity,
+		Priority:              i.priority,
+		Note:                  i.note,
+		Variant:               copyVariant(i.variant),
+		PriceWhenAdded:        i.priceWhenAdded,
+		LowestPriceSinceAdded: i.lowestPriceSinceAdded,
+		Reservation:           mapReservationToDomainReservation(i.reservation),
+		AlertRule:             copyAlertRule(i.alertRule),
+		CreatedAt:             i.createdAt,
+		UpdatedAt:             i.updatedAt,
+	}
+}
+
+func mapItemToDomainPriceWatch(i *item) *model.PriceWatch {
+	return &model.PriceWatch{
+		UserId:    i.userId,
+		ListId:    i.listId,
+		ProductId: i.productId,
+		Price:     i.price,
+		Rule:      copyAlertRule(i.alertRule),
+	}
+}
+
+// expired reservations are returned to the pool, so they are mapped as no reservation
+func mapReservationToDomainReservation(reservation *reservation) *model.Reservation {
+	if reservation == nil {
+		return nil
+	}
+
+	if reservation.status != model.ReservationStatusReserved {
+		return &model.Reservation{Status: reservation.status}
+	}
+
+	if !reservation.expiresAt.After(time.Now()) {
+		return nil
+	}
+
+	expiresAt := reservation.expiresAt
+	return &model.Reservation{
+		Status:    reservation.status,
+		ExpiresAt: &expiresAt,
+	}
+}
+
+func copyAlertRule(rule *model.AlertRule) *model.AlertRule {
+	if rule == nil {
+		return nil
+	}
+
+	r := *rule
+	return &r
+}
+
+func copyVariant(variant map[string]string) map[string]string {
+	if variant == nil {
+		return nil
+	}
+
+	v := make(map[string]string, len(variant))
+	for k, value := range variant {
+		v[k] = value
+	}
+
+	return v
+}
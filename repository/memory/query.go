@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/pejovski/wish-list/repository/itemquery"
+)
+
+func queryItem(i *item, now time.Time) itemquery.Item {
+	return itemquery.Item{
+		Key:       sortKey(i),
+		Priced:    i.priced,
+		Brand:     i.brand,
+		Active:    i.active,
+		Reserved:  i.reserved(now),
+		CreatedAt: i.createdAt,
+	}
+}
+
+func sortKey(i *item) itemquery.Key {
+	return itemquery.Key{
+		Seq:      i.seq,
+		Price:    i.price,
+		Name:     i.name,
+		Priority: i.priority,
+	}
+}
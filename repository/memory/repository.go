@@ -0,0 +1,821 @@
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	repo "github.com/pejovski/wish-list/repository"
+	"github.com/pejovski/wish-list/repository/itemquery"
+)
+
+const defaultListName = "Wish List"
+
+// repository keeps everything in process memory, it is meant for local development and tests
+type repository struct {
+	mu sync.RWMutex
+
+	// seq gives the items their added order, like the ObjectID does in MongoDB
+	seq    uint64
+	items  map[itemKey]*item
+	lists  map[string]*model.List
+	shares map[string]*model.Share
+	alerts []*model.PriceAlert
+
+	priceHistory map[string][]*model.PricePoint
+
+	// events are the processed event ids and the expiry of their marks
+	events map[string]time.Time
+	// outbox keeps the events to publish in the order they were stored
+	outbox []*model.Event
+
+	erasures []*model.Erasure
+}
+
+type itemKey struct {
+	listId    string
+	productId string
+}
+
+func NewRepository() repo.Repository {
+	return &repository{
+		items:        map[itemKey]*item{},
+		lists:        map[string]*model.List{},
+		shares:       map[string]*model.Share{},
+		priceHistory: map[string][]*model.PricePoint{},
+		events:       map[string]time.Time{},
+	}
+}
+
+// get product with full data
+func (r *repository) Product(ctx context.Context, productId string) (*model.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, i := range r.items {
+		if i.productId == productId && i.priced {
+			return i.product(), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *repository) UpdateProduct(ctx context.Context, product *model.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := r.productItems(product.ProductId)
+	r.recordPrice(product.ProductId, items, product.Price)
+
+	// a product the catalog returned is active again
+	for _, i := range items {
+		i.setProduct(product)
+		i.active = true
+	}
+
+	return nil
+}
+
+func (r *repository) DeactivateProduct(ctx context.Context, productId string) error {
+	return r.setProductActive(productId, false)
+}
+
+func (r *repository) ActivateProduct(ctx context.Context, productId string) error {
+	return r.setProductActive(productId, true)
+}
+
+func (r *repository) setProductActive(productId string, active bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.productItems(productId) {
+		i.active = active
+		i.touch()
+	}
+
+	return nil
+}
+
+func (r *repository) DeleteProduct(ctx context.Context, productId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, i := range r.items {
+		if i.productId == productId {
+			delete(r.items, k)
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the items which are not enriched yet get the price with the product
+	items := []*item{}
+	for _, i := range r.productItems(productId) {
+		if i.priced {
+			items = append(items, i)
+		}
+	}
+
+	for _, i := range items {
+		if i.priceAt.After(at) {
+			return true, nil
+		}
+	}
+
+	r.recordPrice(productId, items, price)
+
+	for _, i := range items {
+		i.setPrice(price)
+		i.priceAt = at
+		i.touch()
+	}
+
+	return false, nil
+}
+
+// recordPrice appends the price to the history of a wish-listed product if it differs from the stored one,
+// it is called before the items get the price and the caller holds the lock
+func (r *repository) recordPrice(productId string, items []*item, price float32) {
+	if len(items) == 0 {
+		return
+	}
+
+	for _, i := range items {
+		if i.priced && i.price == price {
+			return
+		}
+	}
+
+	r.priceHistory[productId] = append(r.priceHistory[productId], &model.PricePoint{
+		Price:      price,
+		RecordedAt: time.Now().UTC(),
+	})
+}
+
+func (r *repository) productItems(productId string) []*item {
+	items := []*item{}
+	for _, i := range r.items {
+		if i.productId == productId {
+			items = append(items, i)
+		}
+	}
+
+	return items
+}
+
+func (r *repository) PriceHistory(ctx context.Context, productId string) ([]*model.PricePoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	points := []*model.PricePoint{}
+	for _, point := range r.priceHistory[productId] {
+		p := *point
+		points = append(points, &p)
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].RecordedAt.Before(points[j].RecordedAt)
+	})
+
+	return points, nil
+}
+
+func (r *repository) Item(ctx context.Context, listId string, productId string) (*model.Item, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, ok := r.items[itemKey{listId, productId}]
+	if !ok {
+		return nil, nil
+	}
+
+	return mapItemToDomainItem(i), nil
+}
+
+func (r *repository) CreateItem(ctx context.Context, userId string, listId string, productId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := itemKey{listId, productId}
+	if _, ok := r.items[key]; ok {
+		return myerr.ErrItemAlreadyExist
+	}
+
+	r.seq++
+	now := time.Now().UTC()
+	r.items[key] = &item{
+		seq:       r.seq,
+		userId:    userId,
+		listId:    listId,
+		productId: productId,
+		active:    true,
+		quantity:  1,
+		priority:  model.PriorityNiceToHave,
+		createdAt: now,
+		updatedAt: now,
+	}
+
+	return nil
+}
+
+func (r *repository) DeleteItem(ctx context.Context, listId string, productId string, event *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := itemKey{listId, productId}
+	if _, ok := r.items[key]; !ok {
+		// nothing removed, nothing to tell
+		return nil
+	}
+
+	delete(r.items, key)
+	r.addEvent(event)
+
+	return nil
+}
+
+func (r *repository) UpdateItem(ctx context.Context, listId string, product *model.Product, event *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i, ok := r.items[itemKey{listId, product.ProductId}]; ok {
+		i.setProduct(product)
+	}
+	r.addEvent(event)
+
+	return nil
+}
+
+func (r *repository) UpdateItemDetails(ctx context.Context, listId string, productId string, details *model.ItemDetails) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.items[itemKey{listId, productId}]
+	if !ok {
+		return nil
+	}
+
+	if details.Quantity == nil && details.Priority == nil && details.Note == nil && details.Variant == nil {
+		return nil
+	}
+
+	if details.Quantity != nil {
+		i.quantity = *details.Quantity
+	}
+	if details.Priority != nil {
+		i.priority = *details.Priority
+	}
+	if details.Note != nil {
+		i.note = *details.Note
+	}
+	if details.Variant != nil {
+		i.variant = copyVariant(details.Variant)
+	}
+	i.touch()
+
+	return nil
+}
+
+func (r *repository) Items(ctx context.Context, listId string, query *model.ItemQuery) (model.Items, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var after *itemquery.Key
+	if query.Cursor != "" {
+		c, err := itemquery.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = c
+	}
+
+	matched := []*item{}
+	now := time.Now()
+	for _, i := range r.items {
+		if i.listId != listId || !itemquery.Matches(queryItem(i, now), query) {
+			continue
+		}
+
+		if after != nil && itemquery.Compare(sortKey(i), *after, query) <= 0 {
+			continue
+		}
+
+		matched = append(matched, i)
+	}
+
+	sort.Slice(matched, func(a, b int) bool {
+		return itemquery.Compare(sortKey(matched[a]), sortKey(matched[b]), query) < 0
+	})
+
+	items := model.Items{}
+	for _, i := range matched {
+		if len(items) == query.Limit {
+			return items, itemquery.EncodeCursor(sortKey(matched[len(items)-1])), nil
+		}
+
+		items = append(items, mapItemToDomainItem(i))
+	}
+
+	return items, "", nil
+}
+
+func (r *repository) Lists(ctx context.Context, userId string) ([]*model.List, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lists := []*model.List{}
+	for _, l := range r.lists {
+		if l.UserId == userId {
+			list := *l
+			lists = append(lists, &list)
+		}
+	}
+
+	// list ids are random, the default list comes first and the others by name
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Default != lists[j].Default {
+			return lists[i].Default
+		}
+		return lists[i].Name < lists[j].Name
+	})
+
+	return lists, nil
+}
+
+func (r *repository) List(ctx context.Context, userId string, listId string) (*model.List, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l, ok := r.lists[listId]
+	if !ok || l.UserId != userId {
+		return nil, nil
+	}
+
+	list := *l
+	return &list, nil
+}
+
+func (r *repository) DefaultList(ctx context.Context, userId string) (*model.List, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.defaultList(userId), nil
+}
+
+// EnsureDefaultList returns the user's default list, creating it on first access
+func (r *repository) EnsureDefaultList(ctx context.Context, userId string) (*model.List, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if list := r.defaultList(userId); list != nil {
+		return list, nil
+	}
+
+	l := &model.List{
+		Id:      newId(),
+		UserId:  userId,
+		Name:    defaultListName,
+		Default: true,
+	}
+	r.lists[l.Id] = l
+
+	list := *l
+	return &list, nil
+}
+
+// defaultList returns a copy of the user's default list, the caller holds the lock
+func (r *repository) defaultList(userId string) *model.List {
+	for _, l := range r.lists {
+		if l.UserId == userId && l.Default {
+			list := *l
+			return &list
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) CreateList(ctx context.Context, userId string, name string) (*model.List, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := &model.List{
+		Id:     newId(),
+		UserId: userId,
+		Name:   name,
+	}
+	r.lists[l.Id] = l
+
+	list := *l
+	return &list, nil
+}
+
+func (r *repository) RenameList(ctx context.Context, userId string, listId string, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if l, ok := r.lists[listId]; ok && l.UserId == userId {
+		l.Name = name
+	}
+
+	return nil
+}
+
+func (r *repository) DeleteList(ctx context.Context, userId string, listId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if l, ok := r.lists[listId]; !ok || l.UserId != userId {
+		return nil
+	}
+	delete(r.lists, listId)
+
+	for k, i := range r.items {
+		if i.listId == listId {
+			delete(r.items, k)
+		}
+	}
+
+	for token, s := range r.shares {
+		if s.ListId == listId {
+			delete(r.shares, token)
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) Share(ctx context.Context, token string) (*model.Share, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.shares[token]
+	if !ok {
+		return nil, nil
+	}
+
+	share := *s
+	return &share, nil
+}
+
+func (r *repository) Shares(ctx context.Context, listId string) ([]*model.Share, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	shares := []*model.Share{}
+	for _, s := range r.shares {
+		if s.ListId == listId {
+			share := *s
+			shares = append(shares, &share)
+		}
+	}
+
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].CreatedAt.Before(shares[j].CreatedAt)
+	})
+
+	return shares, nil
+}
+
+func (r *repository) CreateShare(ctx context.Context, share *model.Share, event *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := *share
+	r.shares[s.Token] = &s
+	r.addEvent(event)
+
+	return nil
+}
+
+func (r *repository) DeleteShare(ctx context.Context, listId string, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.shares[token]; ok && s.ListId == listId {
+		delete(r.shares, token)
+	}
+
+	return nil
+}
+
+// ReserveItem claims the item only if it's not reserved or the reservation has expired
+func (r *repository) ReserveItem(ctx context.Context, listId string, productId string, claimId string, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.items[itemKey{listId, productId}]
+	if !ok {
+		return false, nil
+	}
+
+	if i.reserved(time.Now()) {
+		return false, nil
+	}
+
+	i.reservation = &reservation{
+		claimId:   claimId,
+		status:    model.ReservationStatusReserved,
+		expiresAt: expiresAt,
+	}
+	i.touch()
+
+	return true, nil
+}
+
+func (r *repository) ReleaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.claimedItem(listId, productId, claimId)
+	if i == nil {
+		return false, nil
+	}
+
+	i.reservation = nil
+	i.touch()
+
+	return true, nil
+}
+
+func (r *repository) PurchaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.claimedItem(listId, productId, claimId)
+	if i == nil {
+		return false, nil
+	}
+
+	i.reservation.status = model.ReservationStatusPurchased
+	i.reservation.expiresAt = time.Time{}
+	i.touch()
+
+	return true, nil
+}
+
+// claimedItem returns the item reserved with the claim, nil when the claim doesn't hold it
+func (r *repository) claimedItem(listId string, productId string, claimId string) *item {
+	i, ok := r.items[itemKey{listId, productId}]
+	if !ok || i.reservation == nil {
+		return nil
+	}
+
+	if i.reservation.claimId != claimId || i.reservation.status != model.ReservationStatusReserved {
+		return nil
+	}
+
+	return i
+}
+
+// SetAlertRule sets the alert rule of the item or removes it when the rule is nil
+func (r *repository) SetAlertRule(ctx context.Context, listId string, productId string, rule *model.AlertRule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.items[itemKey{listId, productId}]
+	if !ok {
+		return nil
+	}
+
+	i.alertRule = nil
+	if rule != nil {
+		ar := *rule
+		i.alertRule = &ar
+	}
+	i.touch()
+
+	return nil
+}
+
+func (r *repository) PriceWatches(ctx context.Context, productId string) ([]*model.PriceWatch, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	watches := []*model.PriceWatch{}
+	for _, i := range r.productItems(productId) {
+		if i.alertRule != nil {
+			watches = append(watches, mapItemToDomainPriceWatch(i))
+		}
+	}
+
+	return watches, nil
+}
+
+func (r *repository) CreateAlert(ctx context.Context, alert *model.PriceAlert, event *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a := *alert
+	r.alerts = append(r.alerts, &a)
+	r.addEvent(event)
+
+	return nil
+}
+
+func (r *repository) Alerts(ctx context.Context, userId string) ([]*model.PriceAlert, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	alerts := []*model.PriceAlert{}
+	for _, a := range r.alerts {
+		if a.UserId == userId {
+			alert := *a
+			alerts = append(alerts, &alert)
+		}
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].CreatedAt.After(alerts[j].CreatedAt)
+	})
+
+	return alerts, nil
+}
+
+// newId returns a random id with the length of a hex ObjectID
+func newId() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func (r *repository) EventProcessed(ctx context.Context, eventId string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	expiresAt, ok := r.events[eventId]
+	return ok && expiresAt.After(time.Now()), nil
+}
+
+func (r *repository) MarkEventProcessed(ctx context.Context, eventId string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, e := range r.events {
+		if !e.After(now) {
+			delete(r.events, id)
+		}
+	}
+
+	r.events[eventId] = expiresAt
+	return nil
+}
+
+// addEvent appends the event to the outbox, the caller holds the lock
+func (r *repository) addEvent(event *model.Event) {
+	if event == nil {
+		return
+	}
+
+	e := *event
+	r.outbox = append(r.outbox, &e)
+}
+
+func (r *repository) PendingEvents(ctx context.Context, limit int) ([]*model.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []*model.Event{}
+	for _, e := range r.outbox {
+		if len(events) == limit {
+			break
+		}
+
+		c := *e
+		events = append(events, &c)
+	}
+
+	return events, nil
+}
+
+func (r *repository) MarkEventsPublished(ctx context.Context, events []*model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	published := map[string]bool{}
+	for _, e := range events {
+		published[e.Id] = true
+	}
+
+	pending := []*model.Event{}
+	for _, e := range r.outbox {
+		if !published[e.Id] {
+			pending = append(pending, e)
+		}
+	}
+	r.outbox = pending
+
+	return nil
+}
+
+func (r *repository) OutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := &model.OutboxStats{Pending: len(r.outbox)}
+	if len(r.outbox) > 0 {
+		stats.Oldest = r.outbox[0].OccurredAt
+	}
+
+	return stats, nil
+}
+
+func (r *repository) EraseUser(ctx context.Context, erasure *model.Erasure) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, l := range r.lists {
+		if l.UserId != erasure.UserId {
+			continue
+		}
+
+		delete(r.lists, id)
+		erasure.Lists++
+
+		for token, s := range r.shares {
+			if s.ListId == id {
+				delete(r.shares, token)
+				erasure.Shares++
+			}
+		}
+	}
+
+	products := map[string]bool{}
+	for k, i := range r.items {
+		if i.userId != erasure.UserId {
+			continue
+		}
+
+		delete(r.items, k)
+		products[i.productId] = true
+		erasure.Items++
+		if i.reservation != nil {
+			erasure.Reservations++
+		}
+	}
+
+	// the history of a product another list has is kept
+	for productId := range products {
+		if len(r.productItems(productId)) > 0 {
+			continue
+		}
+
+		erasure.PricePoints += len(r.priceHistory[productId])
+		delete(r.priceHistory, productId)
+	}
+
+	outbox := []*model.Event{}
+	for _, e := range r.outbox {
+		if repo.EventUserId(e) == erasure.UserId {
+			erasure.Events++
+			continue
+		}
+		outbox = append(outbox, e)
+	}
+	r.outbox = outbox
+
+	alerts := []*model.PriceAlert{}
+	for _, a := range r.alerts {
+		if a.UserId == erasure.UserId {
+			erasure.Alerts++
+			continue
+		}
+		alerts = append(alerts, a)
+	}
+	r.alerts = alerts
+
+	e := *erasure
+	r.erasures = append(r.erasures, &e)
+
+	return nil
+}
+
+func (r *repository) Erasures(ctx context.Context, userId string) ([]*model.Erasure, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	erasures := []*model.Erasure{}
+	for i := len(r.erasures) - 1; i >= 0; i-- {
+		if r.erasures[i].UserId == userId {
+			e := *r.erasures[i]
+			erasures = append(erasures, &e)
+		}
+	}
+
+	return erasures, nil
+}
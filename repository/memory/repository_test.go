@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	repo "github.com/pejovski/wish-list/repository"
+	"github.com/pejovski/wish-list/repository/repositorytest"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) (repo.Repository, func()) {
+		return NewRepository(), func() {}
+	})
+}
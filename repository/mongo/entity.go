@@ -1,10 +1,123 @@
 package mongo
 
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Item references the catalog data in the products collection by product_id
 type Item struct {
-	ProductId string  `bson:"product_id"`
-	Name      string  `bson:"name"`
-	Brand     string  `bson:"brand"`
-	Price     float32 `bson:"price"`
-	Image     string  `bson:"image"`
-	Active    bool    `bson:"active"`
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	UserId    string             `bson:"user_id"`
+	ListId    string             `bson:"list_id"`
+	ProductId string             `bson:"product_id"`
+
+	Quantity int               `bson:"quantity"`
+	Priority string            `bson:"priority"`
+	Note     string            `bson:"note"`
+	Variant  map[string]string `bson:"variant,omitempty"`
+
+	PriceWhenAdded        float32 `bson:"price_when_added"`
+	LowestPriceSinceAdded float32 `bson:"lowest_price_since_added"`
+
+	Reservation *Reservation `bson:"reservation,omitempty"`
+	AlertRule   *AlertRule   `bson:"alert_rule,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Product is the single copy of the catalog data of a wish-listed product
+type Product struct {
+	Id        string    `bson:"_id"`
+	Name      string    `bson:"name"`
+	Brand     string    `bson:"brand"`
+	Price     float32   `bson:"price"`
+	Image     string    `bson:"image"`
+	Active    bool      `bson:"active"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// ItemProduct is an item joined with its product
+type ItemProduct struct {
+	Item    `bson:",inline"`
+	Product Product `bson:"product"`
+}
+
+// legacyItem is an item document from before the products collection, it carried its own copy of the product
+type legacyItem struct {
+	ProductId string    `bson:"product_id"`
+	Name      string    `bson:"name"`
+	Brand     string    `bson:"brand"`
+	Price     float32   `bson:"price"`
+	Image     string    `bson:"image"`
+	Active    bool      `bson:"active"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+type Reservation struct {
+	ClaimId   string    `bson:"claim_id"`
+	Status    string    `bson:"status"`
+	ExpiresAt time.Time `bson:"expires_at,omitempty"`
+}
+
+type List struct {
+	Id      string `bson:"_id"`
+	UserId  string `bson:"user_id"`
+	Name    string `bson:"name"`
+	Default bool   `bson:"default"`
+}
+
+type Share struct {
+	Token     string    `bson:"_id"`
+	UserId    string    `bson:"user_id"`
+	ListId    string    `bson:"list_id"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type AlertRule struct {
+	TargetPrice    float32 `bson:"target_price"`
+	DropPercentage float32 `bson:"drop_percentage"`
+	ReferencePrice float32 `bson:"reference_price"`
+}
+
+type Alert struct {
+	Id          string    `bson:"_id"`
+	UserId      string    `bson:"user_id"`
+	ListId      string    `bson:"list_id"`
+	ProductId   string    `bson:"product_id"`
+	OldPrice    float32   `bson:"old_price"`
+	NewPrice    float32   `bson:"new_price"`
+	TargetPrice float32   `bson:"target_price"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
+
+type PricePoint struct {
+	ProductId  string    `bson:"product_id"`
+	Price      float32   `bson:"price"`
+	RecordedAt time.Time `bson:"recorded_at"`
+}
+
+// Event is an outbox event, the data is kept as the JSON it's published as
+type Event struct {
+	Id         string    `bson:"_id"`
+	Type       string    `bson:"type"`
+	Version    int       `bson:"version"`
+	OccurredAt time.Time `bson:"occurred_at"`
+	Data       string    `bson:"data"`
+}
+
+type Erasure struct {
+	Id           string    `bson:"_id"`
+	UserId       string    `bson:"user_id"`
+	Source       string    `bson:"source"`
+	Lists        int       `bson:"lists"`
+	Items        int       `bson:"items"`
+	Reservations int       `bson:"reservations"`
+	Shares       int       `bson:"shares"`
+	Alerts       int       `bson:"alerts"`
+	PricePoints  int       `bson:"price_points"`
+	Events       int       `bson:"events"`
+	ErasedAt     time.Time `bson:"erased_at"`
 }
@@ -0,0 +1,161 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const indexTimeout = time.Minute
+
+// index is a declared index, the name identifies it so applying the indexes again changes nothing
+type index struct {
+	Name    string
+	Keys    bson.D
+	Unique  bool
+	Partial bson.M
+	// Expires makes it a TTL index, the documents are removed once the indexed date passes
+	Expires bool
+}
+
+// indexes are the indexes of every collection.
+// The list sort on product fields happens after the join with the products, so only item fields are indexed.
+// Items stored before named lists existed have no list_id until they are moved into their user's default list,
+// the unique indexes are partial so those items don't collide.
+var indexes = map[string][]index{
+	itemsCollection: {
+		{
+			Name:    "list_id_product_id_unique",
+			Keys:    bson.D{{Key: "list_id", Value: 1}, {Key: "product_id", Value: 1}},
+			Unique:  true,
+			Partial: bson.M{"list_id": bson.M{"$exists": true}},
+		},
+		{Name: "list_id_id", Keys: bson.D{{Key: "list_id", Value: 1}, {Key: "_id", Value: 1}}},
+		{Name: "list_id_priority_id", Keys: bson.D{{Key: "list_id", Value: 1}, {Key: "priority", Value: 1}, {Key: "_id", Value: 1}}},
+		{Name: "product_id", Keys: bson.D{{Key: "product_id", Value: 1}}},
+		{Name: "user_id", Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	listsCollection: {
+		{
+			Name:    "user_id_default_unique",
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Unique:  true,
+			Partial: bson.M{"default": true},
+		},
+		{Name: "user_id", Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	sharesCollection: {
+		{Name: "list_id", Keys: bson.D{{Key: "list_id", Value: 1}}},
+	},
+	alertsCollection: {
+		{Name: "user_id_created_at", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	pricesCollection: {
+		{Name: "product_id_recorded_at", Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "recorded_at", Value: 1}}},
+	},
+	eventsCollection: {
+		{Name: "expires_at_ttl", Keys: bson.D{{Key: "expires_at", Value: 1}}, Expires: true},
+	},
+	outboxCollection: {
+		{Name: "occurred_at_id", Keys: bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}}},
+	},
+	erasuresCollection: {
+		{Name: "user_id_erased_at", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "erased_at", Value: -1}}},
+	},
+}
+
+func (i index) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.Name)
+	if i.Unique {
+		opts.SetUnique(true)
+	}
+	if i.Partial != nil {
+		opts.SetPartialFilterExpression(i.Partial)
+	}
+	if i.Expires {
+		opts.SetExpireAfterSeconds(0)
+	}
+
+	return mongo.IndexModel{Keys: i.Keys, Options: opts}
+}
+
+// ensureIndexes creates the declared indexes which don't exist yet, existing ones are left as they are.
+// It keeps going when an index fails, e.g. a unique index over duplicates, and returns the first error,
+// the startup fails then rather than running without the unique indexes.
+func ensureIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+
+	var firstErr error
+	for collection, declared := range indexes {
+		existing, err := indexNames(ctx, db.Collection(collection))
+		if err != nil {
+			logrus.Errorf("List indexes failed for %s Error: %s", collection, err)
+			return err
+		}
+
+		for _, i := range declared {
+			if existing[i.Name] {
+				continue
+			}
+
+			_, err := db.Collection(collection).Indexes().CreateOne(ctx, i.model())
+			if err != nil {
+				logrus.Errorf("CreateOne index %s failed for %s Error: %s", i.Name, collection, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+
+			logrus.Infof("Index %s created for %s", i.Name, collection)
+		}
+	}
+
+	return firstErr
+}
+
+func indexNames(ctx context.Context, c *mongo.Collection) (map[string]bool, error) {
+	cur, err := c.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	names := map[string]bool{}
+	for cur.Next(ctx) {
+		var i struct {
+			Name string `bson:"name"`
+		}
+		if err := cur.Decode(&i); err != nil {
+			return nil, err
+		}
+		names[i.Name] = true
+	}
+
+	return names, cur.Err()
+}
+
+// isDuplicateKeyError tells if a write failed on a unique index
+func isDuplicateKeyError(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if isDuplicateKeyCode(int32(we.Code)) {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		return isDuplicateKeyCode(e.Code)
+	}
+
+	return false
+}
+
+func isDuplicateKeyCode(code int32) bool {
+	return code == 11000 || code == 11001 || code == 12582
+}
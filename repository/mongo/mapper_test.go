@@ -7,17 +7,25 @@ import (
 func TestMapItemToDomainItem(t *testing.T) {
 
 	i := &Item{
-		ProductId: "",
-		Name:      "Galaxy",
-		Brand:     "Samsung",
-		Price:     800,
-		Image:     "galaxy.jpg",
-		Active:    true,
+		ProductId: "galaxy",
 	}
 
-	di := mapItemToDomainItem(i)
+	p := &Product{
+		Id:     "galaxy",
+		Name:   "Galaxy",
+		Brand:  "Samsung",
+		Price:  800,
+		Image:  "galaxy.jpg",
+		Active: true,
+	}
+
+	di := mapItemToDomainItem(i, p)
 
 	if i.ProductId != di.ProductId {
 		t.Error("ProductId not equal")
 	}
+
+	if p.Name != di.Name || p.Price != di.Price {
+		t.Error("Product data not joined")
+	}
 }
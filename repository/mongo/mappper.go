@@ -1,18 +1,239 @@
 package mongo
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/pejovski/wish-list/model"
 )
 
-func mapItemToDomainItem(item *Item) *model.Item {
-	return &model.Item{
+// mapItemToDomainItem maps the item with its product, the product is nil until the item is enriched
+func mapItemToDomainItem(item *Item, product *Product) *model.Item {
+
+	// items created before the metadata existed
+	if item.Quantity == 0 {
+		item.Quantity = 1
+	}
+	if item.Priority == "" {
+		item.Priority = model.PriorityNiceToHave
+	}
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = item.Id.Timestamp()
+	}
+	if item.UpdatedAt.IsZero() {
+		item.UpdatedAt = item.CreatedAt
+	}
+
+	i := &model.Item{
 		Product: &model.Product{
 			ProductId: item.ProductId,
-			Name:      item.Name,
-			Brand:     item.Brand,
-			Price:     item.Price,
-			Image:     item.Image,
 		},
-		Active: item.Active,
+		Active:                true,
+		Quantity:              item.Quantity,
+		Priority:              item.Priority,
+		Note:                  item.Note,
+		Variant:               item.Variant,
+		PriceWhenAdded:        item.PriceWhenAdded,
+		LowestPriceSinceAdded: item.LowestPriceSinceAdded,
+		Reservation:           mapReservationToDomainReservation(item.Reservation),
+		AlertRule:             mapAlertRuleToDomainAlertRule(item.AlertRule),
+		CreatedAt:             item.CreatedAt,
+		UpdatedAt:             item.UpdatedAt,
+	}
+
+	if product != nil {
+		i.Name = product.Name
+		i.Brand = product.Brand
+		i.Price = product.Price
+		i.Image = product.Image
+		i.Active = product.Active
+
+		// a catalog update changes the item as seen by the user
+		if product.UpdatedAt.After(i.UpdatedAt) {
+			i.UpdatedAt = product.UpdatedAt
+		}
+	}
+
+	return i
+}
+
+func mapProductToDomainProduct(product *Product) *model.Product {
+	return &model.Product{
+		ProductId: product.Id,
+		Name:      product.Name,
+		Brand:     product.Brand,
+		Price:     product.Price,
+		Image:     product.Image,
+	}
+}
+
+func mapItemToDomainPriceWatch(item *Item, price float32) *model.PriceWatch {
+	return &model.PriceWatch{
+		UserId:    item.UserId,
+		ListId:    item.ListId,
+		ProductId: item.ProductId,
+		Price:     price,
+		Rule:      mapAlertRuleToDomainAlertRule(item.AlertRule),
+	}
+}
+
+func mapAlertRuleToDomainAlertRule(rule *AlertRule) *model.AlertRule {
+	if rule == nil {
+		return nil
+	}
+
+	return &model.AlertRule{
+		TargetPrice:    rule.TargetPrice,
+		DropPercentage: rule.DropPercentage,
+		ReferencePrice: rule.ReferencePrice,
+	}
+}
+
+func mapDomainAlertRuleToAlertRule(rule *model.AlertRule) *AlertRule {
+	return &AlertRule{
+		TargetPrice:    rule.TargetPrice,
+		DropPercentage: rule.DropPercentage,
+		ReferencePrice: rule.ReferencePrice,
+	}
+}
+
+// expired reservations are returned to the pool, so they are mapped as no reservation
+func mapReservationToDomainReservation(reservation *Reservation) *model.Reservation {
+	if reservation == nil {
+		return nil
+	}
+
+	if reservation.Status != model.ReservationStatusReserved {
+		return &model.Reservation{Status: reservation.Status}
+	}
+
+	if !reservation.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+
+	expiresAt := reservation.ExpiresAt
+	return &model.Reservation{
+		Status:    reservation.Status,
+		ExpiresAt: &expiresAt,
+	}
+}
+
+func mapListToDomainList(list *List) *model.List {
+	return &model.List{
+		Id:      list.Id,
+		UserId:  list.UserId,
+		Name:    list.Name,
+		Default: list.Default,
+	}
+}
+
+func mapShareToDomainShare(share *Share) *model.Share {
+	return &model.Share{
+		Token:     share.Token,
+		UserId:    share.UserId,
+		ListId:    share.ListId,
+		CreatedAt: share.CreatedAt,
+	}
+}
+
+func mapDomainShareToShare(share *model.Share) *Share {
+	return &Share{
+		Token:     share.Token,
+		UserId:    share.UserId,
+		ListId:    share.ListId,
+		CreatedAt: share.CreatedAt,
+	}
+}
+
+func mapAlertToDomainAlert(alert *Alert) *model.PriceAlert {
+	return &model.PriceAlert{
+		Id:          alert.Id,
+		UserId:      alert.UserId,
+		ListId:      alert.ListId,
+		ProductId:   alert.ProductId,
+		OldPrice:    alert.OldPrice,
+		NewPrice:    alert.NewPrice,
+		TargetPrice: alert.TargetPrice,
+		CreatedAt:   alert.CreatedAt,
+	}
+}
+
+func mapDomainAlertToAlert(alert *model.PriceAlert) *Alert {
+	return &Alert{
+		Id:          alert.Id,
+		UserId:      alert.UserId,
+		ListId:      alert.ListId,
+		ProductId:   alert.ProductId,
+		OldPrice:    alert.OldPrice,
+		NewPrice:    alert.NewPrice,
+		TargetPrice: alert.TargetPrice,
+		CreatedAt:   alert.CreatedAt,
+	}
+}
+
+func mapPricePointToDomainPricePoint(point *PricePoint) *model.PricePoint {
+	return &model.PricePoint{
+		Price:      point.Price,
+		RecordedAt: point.RecordedAt,
+	}
+}
+
+func mapDomainPricePointToPricePoint(productId string, point *model.PricePoint) *PricePoint {
+	return &PricePoint{
+		ProductId:  productId,
+		Price:      point.Price,
+		RecordedAt: point.RecordedAt,
+	}
+}
+
+func mapEventToDomainEvent(event *Event) *model.Event {
+	return &model.Event{
+		Id:         event.Id,
+		Type:       event.Type,
+		Version:    event.Version,
+		OccurredAt: event.OccurredAt,
+		Data:       json.RawMessage(event.Data),
+	}
+}
+
+func mapDomainEventToEvent(event *model.Event) *Event {
+	return &Event{
+		Id:         event.Id,
+		Type:       event.Type,
+		Version:    event.Version,
+		OccurredAt: event.OccurredAt,
+		Data:       string(event.Data),
+	}
+}
+
+func mapErasureToDomainErasure(erasure *Erasure) *model.Erasure {
+	return &model.Erasure{
+		Id:           erasure.Id,
+		UserId:       erasure.UserId,
+		Source:       erasure.Source,
+		Lists:        erasure.Lists,
+		Items:        erasure.Items,
+		Reservations: erasure.Reservations,
+		Shares:       erasure.Shares,
+		Alerts:       erasure.Alerts,
+		PricePoints:  erasure.PricePoints,
+		Events:       erasure.Events,
+		ErasedAt:     erasure.ErasedAt,
+	}
+}
+
+func mapDomainErasureToErasure(erasure *model.Erasure) *Erasure {
+	return &Erasure{
+		Id:           erasure.Id,
+		UserId:       erasure.UserId,
+		Source:       erasure.Source,
+		Lists:        erasure.Lists,
+		Items:        erasure.Items,
+		Reservations: erasure.Reservations,
+		Shares:       erasure.Shares,
+		Alerts:       erasure.Alerts,
+		PricePoints:  erasure.PricePoints,
+		Events:       erasure.Events,
+		ErasedAt:     erasure.ErasedAt,
 	}
 }
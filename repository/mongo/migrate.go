@@ -0,0 +1,280 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	repo "github.com/pejovski/wish-list/repository"
+)
+
+const migrationTimeout = time.Minute
+
+// Migrate brings the database up to the current schema: it moves the embedded products out of the items,
+// removes the duplicate items and default lists which would break the unique indexes, moves the items stored
+// before named lists into their user's default list and creates the missing indexes.
+// It is safe to run again.
+func Migrate(c *mongo.Client) error {
+	return migrate(c.Database(database))
+}
+
+func migrate(db *mongo.Database) error {
+	return openRepository(db, repo.DefaultTimeouts).migrate()
+}
+
+func (r repository) migrate() error {
+	if err := r.migrateEmbeddedProducts(); err != nil {
+		return err
+	}
+
+	// the duplicates are removed first, or the unique indexes can't be built
+	if err := r.removeDuplicateItems(); err != nil {
+		return err
+	}
+
+	if err := r.removeDuplicateDefaultLists(); err != nil {
+		return err
+	}
+
+	if err := r.migrateLegacyItems(); err != nil {
+		return err
+	}
+
+	return ensureIndexes(r.items.Database())
+}
+
+// legacyProductFields are the product fields items carried before the products collection
+var legacyProductFields = []string{"name", "brand", "price", "image", "active"}
+
+// migrateEmbeddedProducts moves the product data copied on the items into the products collection.
+// The most recently updated copy of a product wins, products already in the collection are kept.
+// It is idempotent, once the items are migrated it finds nothing to do.
+func (r repository) migrateEmbeddedProducts() error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	legacy := bson.A{}
+	for _, f := range legacyProductFields {
+		legacy = append(legacy, bson.M{f: bson.M{"$exists": true}})
+	}
+
+	// items which were never enriched have no product to migrate
+	filter := bson.M{"name": bson.M{"$nin": bson.A{"", nil}}}
+	opts := options.Find().SetSort(bson.M{"updated_at": -1})
+
+	cur, err := r.items.Find(ctx, filter, opts)
+	if err != nil {
+		logrus.Errorf("Find legacy items failed Error: %s", err)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	migrated := map[string]bool{}
+	for cur.Next(ctx) {
+
+		var item *legacyItem
+		if err := cur.Decode(&item); err != nil {
+			logrus.Errorf("Find legacy items decode failed Error: %s", err)
+			return err
+		}
+
+		if migrated[item.ProductId] {
+			continue
+		}
+
+		_, err := r.products.UpdateOne(
+			ctx,
+			bson.M{"_id": item.ProductId},
+			bson.M{"$setOnInsert": bson.M{
+				"name":       item.Name,
+				"brand":      item.Brand,
+				"price":      item.Price,
+				"image":      item.Image,
+				"active":     item.Active,
+				"updated_at": item.UpdatedAt,
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			logrus.Errorf("UpdateOne failed for migrating product %s Error: %s", item.ProductId, err)
+			return err
+		}
+
+		migrated[item.ProductId] = true
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Find legacy items failed Error: %s", err)
+		return err
+	}
+
+	unset := bson.M{}
+	for _, f := range legacyProductFields {
+		unset[f] = ""
+	}
+
+	result, err := r.items.UpdateMany(ctx, bson.M{"$or": legacy}, bson.M{"$unset": unset})
+	if err != nil {
+		logrus.Errorf("UpdateMany failed for unsetting legacy product fields Error: %s", err)
+		return err
+	}
+
+	if len(migrated) > 0 || result.ModifiedCount > 0 {
+		logrus.Infof("Migrated %d products out of %d items", len(migrated), result.ModifiedCount)
+	}
+
+	return nil
+}
+
+// removeDuplicateItems keeps the first added item of every product in a list and deletes the others.
+// The items stored before named lists have no list_id yet, they are deduplicated per user,
+// since they all end up in the user's default list.
+func (r repository) removeDuplicateItems() error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	if err := r.removeDuplicates(ctx, bson.M{"list_id": bson.M{"$exists": true}}, "list_id"); err != nil {
+		return err
+	}
+
+	return r.removeDuplicates(ctx, bson.M{"list_id": bson.M{"$exists": false}}, "user_id")
+}
+
+// removeDuplicates deletes all but the first added item of every product among the matched items grouped by the owner field
+func (r repository) removeDuplicates(ctx context.Context, match bson.M, owner string) error {
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"owner": "$" + owner, "product_id": "$product_id"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+
+	cur, err := r.items.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		logrus.Errorf("Aggregate duplicate items failed Error: %s", err)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		var duplicate struct {
+			Key struct {
+				Owner     string `bson:"owner"`
+				ProductId string `bson:"product_id"`
+			} `bson:"_id"`
+			Ids []interface{} `bson:"ids"`
+		}
+		if err := cur.Decode(&duplicate); err != nil {
+			logrus.Errorf("Aggregate duplicate items decode failed Error: %s", err)
+			return err
+		}
+
+		result, err := r.items.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicate.Ids[1:]}})
+		if err != nil {
+			logrus.Errorf("DeleteMany failed for duplicates of product %s, %s %s Error: %s", duplicate.Key.ProductId, owner, duplicate.Key.Owner, err)
+			return err
+		}
+
+		logrus.Infof("Removed %d duplicates of product %s, %s %s", result.DeletedCount, duplicate.Key.ProductId, owner, duplicate.Key.Owner)
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Aggregate duplicate items failed Error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// removeDuplicateDefaultLists keeps the first created default list of every user,
+// the others become named lists so their items are kept
+func (r repository) removeDuplicateDefaultLists() error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	// the list ids are ObjectID hex strings, so they sort in the created order
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"default": true}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$user_id",
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+
+	cur, err := r.lists.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("Aggregate duplicate default lists failed Error: %s", err)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		var duplicate struct {
+			UserId string   `bson:"_id"`
+			Ids    []string `bson:"ids"`
+		}
+		if err := cur.Decode(&duplicate); err != nil {
+			logrus.Errorf("Aggregate duplicate default lists decode failed Error: %s", err)
+			return err
+		}
+
+		_, err := r.lists.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": duplicate.Ids[1:]}}, bson.M{"$set": bson.M{"default": false}})
+		if err != nil {
+			logrus.Errorf("UpdateMany failed for duplicate default lists of user %s Error: %s", duplicate.UserId, err)
+			return err
+		}
+
+		logrus.Infof("Made %d duplicate default lists of user %s named lists", len(duplicate.Ids)-1, duplicate.UserId)
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Aggregate duplicate default lists failed Error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// migrateLegacyItems moves the items stored before named lists into their user's default list,
+// so they are listed without waiting for the user's next write
+func (r repository) migrateLegacyItems() error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	userIds, err := r.items.Distinct(ctx, "user_id", bson.M{"list_id": bson.M{"$exists": false}})
+	if err != nil {
+		logrus.Errorf("Distinct users of legacy items failed Error: %s", err)
+		return err
+	}
+
+	for _, id := range userIds {
+		userId, ok := id.(string)
+		if !ok {
+			continue
+		}
+
+		if _, err := r.EnsureDefaultList(ctx, userId); err != nil {
+			return err
+		}
+	}
+
+	if len(userIds) > 0 {
+		logrus.Infof("Moved the legacy items of %d users into their default lists", len(userIds))
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package mongo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// sortFields maps the domain sort to the field of the item joined with its product
+// priorities sort alphabetically, so must_have comes before nice_to_have
+// items are added with an ObjectID, so _id is the added order
+var sortFields = map[string]string{
+	model.SortAdded:    "_id",
+	model.SortPrice:    "product.price",
+	model.SortName:     "product.name",
+	model.SortPriority: "priority",
+}
+
+// cursor points after the last item of a page, _id breaks the ties of the sort field
+type cursor struct {
+	Id    string      `json:"id"`
+	Value interface{} `json:"v,omitempty"`
+}
+
+// itemsPipeline joins the list items with their products, filters, sorts and limits them to the page
+// items which are not enriched yet have no product, the unwind leaves them out
+func itemsPipeline(listId string, query *model.ItemQuery) (mongo.Pipeline, error) {
+
+	// the item fields are matched before the join, so the list_id index is used
+	filter := bson.M{"list_id": listId}
+
+	if query.Reserved != nil {
+		filter["$and"] = bson.A{reservedFilter(*query.Reserved)}
+	}
+
+	// the ObjectID carries the creation time, so items added before created_at existed are matched too
+	if query.AddedSince != nil {
+		filter["_id"] = bson.M{"$gte": primitive.NewObjectIDFromTimestamp(*query.AddedSince)}
+	}
+
+	productFilter := bson.M{}
+
+	if query.Active != nil {
+		productFilter["product.active"] = *query.Active
+	}
+
+	if query.Brand != "" {
+		productFilter["product.brand"] = query.Brand
+	}
+
+	price := bson.M{}
+	if query.MinPrice != nil {
+		price["$gte"] = *query.MinPrice
+	}
+	if query.MaxPrice != nil {
+		price["$lte"] = *query.MaxPrice
+	}
+	if len(price) > 0 {
+		productFilter["product.price"] = price
+	}
+
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor, query)
+		if err != nil {
+			return nil, err
+		}
+		productFilter["$and"] = bson.A{c}
+	}
+
+	// one more item is fetched to know if there is a next page
+	return mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         productsCollection,
+			"localField":   "product_id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$unwind", Value: "$product"}},
+		{{Key: "$match", Value: productFilter}},
+		{{Key: "$sort", Value: itemsSort(query)}},
+		{{Key: "$limit", Value: query.Limit + 1}},
+	}, nil
+}
+
+// reservedFilter treats expired reservations as not reserved
+func reservedFilter(reserved bool) bson.M {
+	now := time.Now()
+
+	if reserved {
+		return bson.M{"$or": bson.A{
+			bson.M{"reservation.status": model.ReservationStatusPurchased},
+			bson.M{
+				"reservation.status":     model.ReservationStatusReserved,
+				"reservation.expires_at": bson.M{"$gt": now},
+			},
+		}}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{"reservation": bson.M{"$exists": false}},
+		bson.M{
+			"reservation.status":     model.ReservationStatusReserved,
+			"reservation.expires_at": bson.M{"$lte": now},
+		},
+	}}
+}
+
+func itemsSort(query *model.ItemQuery) bson.D {
+	dir := 1
+	if query.Desc {
+		dir = -1
+	}
+
+	field := sortFields[query.Sort]
+	if field == "_id" {
+		return bson.D{{Key: "_id", Value: dir}}
+	}
+
+	return bson.D{{Key: field, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+func encodeCursor(item *ItemProduct, query *model.ItemQuery) string {
+	c := cursor{Id: item.Id.Hex()}
+
+	switch query.Sort {
+	case model.SortPrice:
+		// the price is stored as the double of the float32, the cursor keeps that double so the ties match exactly
+		c.Value = float64(item.Product.Price)
+	case model.SortName:
+		c.Value = item.Product.Name
+	case model.SortPriority:
+		c.Value = item.Priority
+	}
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the filter matching the items after the cursor
+func decodeCursor(value string, query *model.ItemQuery) (bson.M, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Id)
+	if err != nil {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	op := "$gt"
+	if query.Desc {
+		op = "$lt"
+	}
+
+	field := sortFields[query.Sort]
+	if field == "_id" {
+		return bson.M{"_id": bson.M{op: id}}, nil
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: c.Value}},
+		bson.M{field: c.Value, "_id": bson.M{op: id}},
+	}}, nil
+}
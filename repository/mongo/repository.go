@@ -2,44 +2,114 @@ package mongo
 
 import (
 	"context"
+	"errors"
 	"github.com/pejovski/wish-list/model"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
+	myerr "github.com/pejovski/wish-list/error"
 	repo "github.com/pejovski/wish-list/repository"
 )
 
 const (
-	database   = "wish"
-	collection = "items"
+	database           = "wish"
+	itemsCollection    = "items"
+	productsCollection = "products"
+	listsCollection    = "lists"
+	sharesCollection   = "shares"
+	alertsCollection   = "alerts"
+	pricesCollection   = "price_history"
+	eventsCollection   = "processed_events"
+	outboxCollection   = "outbox"
+	erasuresCollection = "erasures"
+
+	defaultListName = "Wish List"
 )
 
 type repository struct {
-	collection *mongo.Collection
+	items    *mongo.Collection
+	products *mongo.Collection
+	lists    *mongo.Collection
+	shares   *mongo.Collection
+	alerts   *mongo.Collection
+
+	priceHistory *mongo.Collection
+	events       *mongo.Collection
+	outbox       *mongo.Collection
+	erasures     *mongo.Collection
+
+	client *mongo.Client
+
+	timeouts repo.Timeouts
 }
 
-func NewRepository(c *mongo.Client) repo.Repository {
-	return repository{collection: c.Database(database).Collection(collection)}
+// errStandalone is returned on a standalone server, the outbox events need the transaction of the change
+var errStandalone = errors.New("MongoDB doesn't support transactions, run it as a replica set, e.g. mongod --replSet rs0")
+
+// NewRepository migrates the database and fails if it can't, the service doesn't run without the unique indexes
+// or on a standalone server
+func NewRepository(c *mongo.Client, t repo.Timeouts) (repo.Repository, error) {
+	return newRepository(c.Database(database), t)
 }
 
-// get product with full data
-func (r repository) Product(productId string) (*model.Product, error) {
-	filter := bson.M{
-		"product_id": productId,
-		"price": bson.M{
-			"$exists": true,
-		},
+func newRepository(db *mongo.Database, t repo.Timeouts) (repository, error) {
+	if err := requireTransactions(db); err != nil {
+		return repository{}, err
+	}
+
+	r := openRepository(db, t)
+
+	// the items stored before named lists are moved into the default lists too, so the reads find them
+	if err := r.migrate(); err != nil {
+		return repository{}, err
+	}
+
+	return r, nil
+}
+
+func openRepository(db *mongo.Database, t repo.Timeouts) repository {
+	return repository{
+		items:    db.Collection(itemsCollection),
+		products: db.Collection(productsCollection),
+		lists:    db.Collection(listsCollection),
+		shares:   db.Collection(sharesCollection),
+		alerts:   db.Collection(alertsCollection),
+
+		priceHistory: db.Collection(pricesCollection),
+		events:       db.Collection(eventsCollection),
+		outbox:       db.Collection(outboxCollection),
+		erasures:     db.Collection(erasuresCollection),
+
+		client: db.Client(),
+
+		timeouts: t,
+	}
+}
+
+func (r repository) Product(ctx context.Context, productId string) (*model.Product, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	product, err := r.product(ctx, productId)
+	if err != nil || product == nil {
+		return nil, err
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
+	return mapProductToDomainProduct(product), nil
+}
+
+func (r repository) product(ctx context.Context, productId string) (*Product, error) {
 
-	result := r.collection.FindOne(ctx, filter)
+	result := r.products.FindOne(ctx, bson.M{"_id": productId})
 	if result.Err() != nil {
 
-		if result.Err() == mongo.ErrNoDocuments {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
 			logrus.Infof("No document for product %s", productId)
 			return nil, nil
 		}
@@ -48,206 +118,1319 @@ func (r repository) Product(productId string) (*model.Product, error) {
 		return nil, result.Err()
 	}
 
-	var product *model.Product
+	var product *Product
 	err := result.Decode(&product)
 	if err != nil {
 		logrus.Errorf("FindOne failed for product %s. Error: %s", productId, err)
 		return nil, err
 	}
 
-	// ToDo - check why productId was not set
-	product.ProductId = productId
-
 	return product, nil
 }
 
-func (r repository) UpdateProduct(product *model.Product) error {
-	filter := bson.M{
-		"product_id": bson.M{
-			"$eq": product.ProductId,
-		},
-	}
+// UpdateProduct stores the catalog data once in the products collection,
+// the product is stored only while some list has it and a changed price is appended to its history
+func (r repository) UpdateProduct(ctx context.Context, product *model.Product) error {
 
-	update := bson.M{"$set": bson.M{
-		"name":  product.Name,
-		"brand": product.Brand,
-		"price": product.Price,
-		"image": product.Image,
-	}}
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
 
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-	_, err := r.collection.UpdateMany(
+	return r.inTx(ctx, func(ctx context.Context) error {
+		var previous *Product
+		err := r.products.FindOneAndUpdate(ctx, bson.M{"_id": product.ProductId}, productUpdate(product, true)).Decode(&previous)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			logrus.Errorf("FindOneAndUpdate failed for product %s; Error: %s", product.ProductId, err)
+			return err
+		}
+
+		if previous == nil {
+			listed, err := r.items.CountDocuments(ctx, bson.M{"product_id": product.ProductId}, options.Count().SetLimit(1))
+			if err != nil {
+				logrus.Errorf("CountDocuments failed for product %s; Error: %s", product.ProductId, err)
+				return err
+			}
+
+			if listed == 0 {
+				return nil
+			}
+
+			if err := r.upsertProduct(ctx, product, true); err != nil {
+				return err
+			}
+		}
+
+		if previous == nil || previous.Price != product.Price {
+			if err := r.insertPricePoint(ctx, product.ProductId, product.Price); err != nil {
+				return err
+			}
+		}
+
+		return r.trackItemPrices(ctx, bson.M{"product_id": product.ProductId}, product.Price)
+	})
+}
+
+func (r repository) upsertProduct(ctx context.Context, product *model.Product, activate bool) error {
+	_, err := r.products.UpdateOne(
 		ctx,
-		filter,
-		update,
+		bson.M{"_id": product.ProductId},
+		productUpdate(product, activate),
+		options.Update().SetUpsert(true),
 	)
-
 	if err != nil {
-		logrus.Errorf("UpdateMany failed for product %s; Error: %s", product.ProductId, err)
+		logrus.Errorf("UpdateOne upsert failed for product %s; Error: %s", product.ProductId, err)
 		return err
 	}
 
 	return nil
 }
-func (r repository) DeactivateProduct(productId string) error {
-	// ToDo
+
+// productUpdate sets the catalog data, a new product is active.
+// activate makes a deactivated product active again when the catalog returned it,
+// the stored product data an item is enriched with leaves it as it is.
+func productUpdate(product *model.Product, activate bool) bson.M {
+	now := time.Now().UTC()
+	set := bson.M{
+		"name":             product.Name,
+		"brand":            product.Brand,
+		"price":            product.Price,
+		"image":            product.Image,
+		"price_updated_at": now,
+		"updated_at":       now,
+	}
+
+	if activate {
+		set["active"] = true
+		return bson.M{"$set": set}
+	}
+
+	return bson.M{"$set": set, "$setOnInsert": bson.M{"active": true}}
+}
+
+func (r repository) DeactivateProduct(ctx context.Context, productId string) error {
+	return r.setProductActive(ctx, productId, false)
+}
+
+func (r repository) ActivateProduct(ctx context.Context, productId string) error {
+	return r.setProductActive(ctx, productId, true)
+}
+
+func (r repository) setProductActive(ctx context.Context, productId string, active bool) error {
+
+	update := bson.M{"$set": bson.M{
+		"active":     active,
+		"updated_at": time.Now().UTC(),
+	}}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.products.UpdateOne(ctx, bson.M{"_id": productId}, update)
+	if err != nil {
+		logrus.Errorf("UpdateOne failed for active %t of product %s; Error: %s", active, productId, err)
+		return err
+	}
+
 	return nil
 }
 
-func (r repository) DeleteProduct(productId string) error {
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-	_, err := r.collection.DeleteMany(ctx, bson.M{"product_id": productId})
+func (r repository) DeleteProduct(ctx context.Context, productId string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.items.DeleteMany(ctx, bson.M{"product_id": productId})
 	if err != nil {
 		logrus.Errorf("DeleteMany failed for product %s; Error: %s", productId, err)
 		return err
 	}
 
+	_, err = r.products.DeleteOne(ctx, bson.M{"_id": productId})
+	if err != nil {
+		logrus.Errorf("DeleteOne failed for product %s; Error: %s", productId, err)
+		return err
+	}
+
 	return nil
 }
 
-func (r repository) UpdateProductPrice(productId string, price float32) error {
-	filter := bson.M{
-		"product_id": bson.M{
-			"$eq": productId,
-		},
-	}
+func (r repository) UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) (bool, error) {
 
+	filter := bson.M{"_id": productId, "$or": bson.A{
+		bson.M{"price_updated_at": bson.M{"$exists": false}},
+		bson.M{"price_updated_at": bson.M{"$lte": at}},
+	}}
 	update := bson.M{"$set": bson.M{
-		"price": price,
+		"price":            price,
+		"price_updated_at": at,
+		"updated_at":       time.Now().UTC(),
 	}}
 
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-	_, err := r.collection.UpdateMany(
-		ctx,
-		filter,
-		update,
-	)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	stale := false
+	err := r.inTx(ctx, func(ctx context.Context) error {
+		var previous *Product
+		err := r.products.FindOneAndUpdate(ctx, filter, update).Decode(&previous)
+
+		// nothing is updated either because a later price is stored or because the product is not wish-listed
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			n, err := r.products.CountDocuments(ctx, bson.M{"_id": productId})
+			if err != nil {
+				logrus.Errorf("CountDocuments failed for product %s; Error: %s", productId, err)
+				return err
+			}
+
+			stale = n > 0
+			return nil
+		}
+		if err != nil {
+			logrus.Errorf("FindOneAndUpdate failed for price of product %s; Error: %s", productId, err)
+			return err
+		}
+
+		if previous.Price != price {
+			if err := r.insertPricePoint(ctx, productId, price); err != nil {
+				return err
+			}
+		}
+
+		return r.trackItemPrices(ctx, bson.M{"product_id": productId}, price)
+	})
+
+	return stale, err
+}
+
+// trackItemPrices keeps the price when added and the lowest price since added of the items,
+// the filters match only the items whose tracked prices change, so a price update doesn't rewrite every item
+func (r repository) trackItemPrices(ctx context.Context, filter bson.M, price float32) error {
+	now := time.Now().UTC()
+
+	added := bson.M{"price_when_added": bson.M{"$exists": false}}
+	for k, v := range filter {
+		added[k] = v
+	}
+
+	_, err := r.items.UpdateMany(ctx, added, bson.M{"$set": bson.M{
+		"price_when_added":         price,
+		"lowest_price_since_added": price,
+		"updated_at":               now,
+	}})
+	if err != nil {
+		logrus.Errorf("UpdateMany failed for price when added; Error: %s", err)
+		return err
+	}
+
+	lower := bson.M{"lowest_price_since_added": bson.M{"$gt": price}}
+	for k, v := range filter {
+		lower[k] = v
+	}
+
+	_, err = r.items.UpdateMany(ctx, lower, bson.M{"$set": bson.M{
+		"lowest_price_since_added": price,
+		"updated_at":               now,
+	}})
+	if err != nil {
+		logrus.Errorf("UpdateMany failed for lowest price since added; Error: %s", err)
+		return err
+	}
 
+	return nil
+}
+
+// insertPricePoint appends the price to the history within the transaction of the price change
+func (r repository) insertPricePoint(ctx context.Context, productId string, price float32) error {
+
+	point := &model.PricePoint{Price: price, RecordedAt: time.Now().UTC()}
+	_, err := r.priceHistory.InsertOne(ctx, mapDomainPricePointToPricePoint(productId, point))
 	if err != nil {
-		logrus.Errorf("UpdateMany failed for product %s; Error: %s", productId, err)
+		logrus.Errorf("InsertOne failed for price point of product %s Error: %s", productId, err)
 		return err
 	}
 
 	return nil
 }
 
-func (r repository) Item(userId string, productId string) (*model.Item, error) {
+func (r repository) PriceHistory(ctx context.Context, productId string) ([]*model.PricePoint, error) {
+
+	points := []*model.PricePoint{}
+
+	opts := options.Find().SetSort(bson.M{"recorded_at": 1})
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	cur, err := r.priceHistory.Find(ctx, bson.M{"product_id": productId}, opts)
+	if err != nil {
+		logrus.Errorf("Find price history failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+
+		var point *PricePoint
+		err := cur.Decode(&point)
+		if err != nil {
+			logrus.Errorf("Find price history decode failed for product %s Error: %s", productId, err)
+			return nil, err
+		}
+
+		points = append(points, mapPricePointToDomainPricePoint(point))
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Find price history failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return points, nil
+}
+
+func (r repository) Item(ctx context.Context, listId string, productId string) (*model.Item, error) {
 
-	filter := bson.M{"user_id": userId, "product_id": productId}
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
+	filter := bson.M{"list_id": listId, "product_id": productId}
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
 
-	result := r.collection.FindOne(ctx, filter)
+	result := r.items.FindOne(ctx, filter)
 	if result.Err() != nil {
 
-		if result.Err() == mongo.ErrNoDocuments {
-			logrus.Infof("No document for product %s, user %s", productId, userId)
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			logrus.Infof("No document for product %s, list %s", productId, listId)
 			return nil, nil
 		}
 
-		logrus.Errorf("FindOne failed for product %s, user %s Error: %s", productId, userId, result.Err())
+		logrus.Errorf("FindOne failed for product %s, list %s Error: %s", productId, listId, result.Err())
 		return nil, result.Err()
 	}
 
-	var item *model.Item
+	var item *Item
 	err := result.Decode(&item)
 	if err != nil {
-		logrus.Errorf("FindOne failed for product %s, user %s Error: %s", productId, userId, err)
+		logrus.Errorf("FindOne failed for product %s, list %s Error: %s", productId, listId, err)
+		return nil, err
+	}
+
+	product, err := r.product(ctx, productId)
+	if err != nil {
 		return nil, err
 	}
 
-	return item, nil
+	return mapItemToDomainItem(item, product), nil
 }
 
-func (r repository) CreateItem(userId string, productId string) error {
+func (r repository) CreateItem(ctx context.Context, userId string, listId string, productId string) error {
 
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-	_, err := r.collection.InsertOne(ctx, bson.M{"user_id": userId, "product_id": productId, "active": true})
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	now := time.Now().UTC()
+	_, err := r.items.InsertOne(ctx, bson.M{
+		"user_id":    userId,
+		"list_id":    listId,
+		"product_id": productId,
+		"quantity":   1,
+		"priority":   model.PriorityNiceToHave,
+		"created_at": now,
+		"updated_at": now,
+	})
+	if isDuplicateKeyError(err) {
+		return myerr.ErrItemAlreadyExist
+	}
 	if err != nil {
-		logrus.Errorf("InsertOne failed for product %s, user %s Error: %s", productId, userId, err)
+		logrus.Errorf("InsertOne failed for product %s, list %s Error: %s", productId, listId, err)
 		return err
 	}
 
 	return nil
 }
 
-func (r repository) DeleteItem(userId string, productId string) error {
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-	_, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userId, "product_id": productId})
+func (r repository) DeleteItem(ctx context.Context, listId string, productId string, event *model.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	err := r.inTx(ctx, func(ctx context.Context) error {
+		res, err := r.items.DeleteOne(ctx, bson.M{"list_id": listId, "product_id": productId})
+		if err != nil {
+			return err
+		}
+
+		// nothing removed, nothing to tell
+		if res.DeletedCount == 0 {
+			return nil
+		}
+
+		return r.insertEvent(ctx, event)
+	})
 	if err != nil {
-		logrus.Errorf("DeleteOne failed for product %s, user %s Error: %s", productId, userId, err)
+		logrus.Errorf("DeleteOne failed for product %s, list %s Error: %s", productId, listId, err)
 		return err
 	}
 
 	return nil
 }
 
-func (r repository) UpdateItem(userId string, product *model.Product) error {
+// UpdateItem enriches the item, the product data is shared with the other lists having the product
+func (r repository) UpdateItem(ctx context.Context, listId string, product *model.Product, event *model.Event) error {
 
-	filter := bson.M{
-		"product_id": bson.M{
-			"$eq": product.ProductId,
-		},
-		"user_id": bson.M{
-			"$eq": userId,
-		},
-	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
 
-	update := bson.M{"$set": bson.M{
-		"name":  product.Name,
-		"brand": product.Brand,
-		"price": product.Price,
-		"image": product.Image,
-	}}
+	return r.inTx(ctx, func(ctx context.Context) error {
+		if err := r.upsertProduct(ctx, product, false); err != nil {
+			return err
+		}
 
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-	_, err := r.collection.UpdateOne(
-		ctx,
-		filter,
-		update,
-	)
+		err := r.trackItemPrices(ctx, bson.M{"list_id": listId, "product_id": product.ProductId}, product.Price)
+		if err != nil {
+			return err
+		}
+
+		return r.insertEvent(ctx, event)
+	})
+}
+
+func (r repository) UpdateItemDetails(ctx context.Context, listId string, productId string, details *model.ItemDetails) error {
+
+	filter := bson.M{"list_id": listId, "product_id": productId}
+
+	set := bson.M{}
+	if details.Quantity != nil {
+		set["quantity"] = *details.Quantity
+	}
+	if details.Priority != nil {
+		set["priority"] = *details.Priority
+	}
+	if details.Note != nil {
+		set["note"] = *details.Note
+	}
+	if details.Variant != nil {
+		set["variant"] = details.Variant
+	}
 
+	if len(set) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.items.UpdateOne(ctx, filter, withUpdatedAt(bson.M{"$set": set}))
 	if err != nil {
-		logrus.Errorf("UpdateMany failed for product %s; Error: %s", product.ProductId, err)
+		logrus.Errorf("UpdateOne failed for details of product %s, list %s Error: %s", productId, listId, err)
 		return err
 	}
 
 	return nil
 }
 
-func (r repository) List(userId string) (model.List, error) {
+func (r repository) Items(ctx context.Context, listId string, query *model.ItemQuery) (model.Items, string, error) {
+
+	items := model.Items{}
+
+	pipeline, err := itemsPipeline(listId, query)
+	if err != nil {
+		logrus.Errorf("Aggregate items failed for list %s Error: %s", listId, err)
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	cur, err := r.items.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("Aggregate items failed for list %s Error: %s", listId, err)
+		return nil, "", err
+	}
+	defer cur.Close(ctx)
+
+	var last *ItemProduct
+	for cur.Next(ctx) {
+
+		var item *ItemProduct
+		err := cur.Decode(&item)
+		if err != nil {
+			logrus.Errorf("Aggregate items decode failed for list %s Error: %s", listId, err)
+			return nil, "", err
+		}
+
+		if len(items) == query.Limit {
+			return items, encodeCursor(last, query), nil
+		}
+
+		items = append(items, mapItemToDomainItem(&item.Item, &item.Product))
+		last = item
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Aggregate items failed for list %s Error: %s", listId, err)
+		return nil, "", err
+	}
+
+	return items, "", nil
+}
+
+func (r repository) Lists(ctx context.Context, userId string) ([]*model.List, error) {
 
-	list := model.List{}
+	lists := []*model.List{}
 
 	filter := bson.M{"user_id": userId}
-	ctx, _ := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
 
-	cur, err := r.collection.Find(ctx, filter)
+	cur, err := r.lists.Find(ctx, filter)
 	if err != nil {
-		logrus.Errorf("Find all failed for user %s Error: %s", userId, err)
+		logrus.Errorf("Find lists failed for user %s Error: %s", userId, err)
 		return nil, err
 	}
 	defer cur.Close(ctx)
 	for cur.Next(ctx) {
 
-		var item *Item
-		err := cur.Decode(&item)
+		var list *List
+		err := cur.Decode(&list)
 		if err != nil {
-			logrus.Errorf("Find all decode failed for user %s Error: %s", userId, err)
+			logrus.Errorf("Find lists decode failed for user %s Error: %s", userId, err)
 			return nil, err
 		}
 
-		// ToDo create filter and move this out
-		if item.Name == "" {
-			continue
+		lists = append(lists, mapListToDomainList(list))
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Find lists failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (r repository) List(ctx context.Context, userId string, listId string) (*model.List, error) {
+
+	filter := bson.M{"_id": listId, "user_id": userId}
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	result := r.lists.FindOne(ctx, filter)
+	if result.Err() != nil {
+
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			logrus.Infof("No document for list %s, user %s", listId, userId)
+			return nil, nil
+		}
+
+		logrus.Errorf("FindOne failed for list %s, user %s Error: %s", listId, userId, result.Err())
+		return nil, result.Err()
+	}
+
+	var list *List
+	err := result.Decode(&list)
+	if err != nil {
+		logrus.Errorf("FindOne failed for list %s, user %s Error: %s", listId, userId, err)
+		return nil, err
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+func (r repository) DefaultList(ctx context.Context, userId string) (*model.List, error) {
+
+	filter := bson.M{"user_id": userId, "default": true}
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	result := r.lists.FindOne(ctx, filter)
+	if result.Err() != nil {
+
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			logrus.Infof("No default list for user %s", userId)
+			return nil, nil
 		}
 
-		list = append(list, mapItemToDomainItem(item))
+		logrus.Errorf("FindOne failed for default list, user %s Error: %s", userId, result.Err())
+		return nil, result.Err()
 	}
 
-	if err := cur.Err(); err != nil {
-		logrus.Errorf("Find all failed for user %s Error: %s", userId, err)
+	var list *List
+	err := result.Decode(&list)
+	if err != nil {
+		logrus.Errorf("FindOne failed for default list, user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+// EnsureDefaultList returns the user's default list, creating it on first access.
+// Items stored before named lists existed carry no list_id; they are moved
+// into the default list here so existing wish lists are migrated lazily.
+func (r repository) EnsureDefaultList(ctx context.Context, userId string) (*model.List, error) {
+
+	filter := bson.M{"user_id": userId, "default": true}
+	update := bson.M{"$setOnInsert": bson.M{
+		"_id":  primitive.NewObjectID().Hex(),
+		"name": defaultListName,
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	result := r.lists.FindOneAndUpdate(ctx, filter, update, opts)
+	// a concurrent first access inserted the default list, it is found on the retry
+	if isDuplicateKeyError(result.Err()) {
+		result = r.lists.FindOneAndUpdate(ctx, filter, update, opts)
+	}
+	if result.Err() != nil {
+		logrus.Errorf("FindOneAndUpdate failed for default list, user %s Error: %s", userId, result.Err())
+		return nil, result.Err()
+	}
+
+	var list *List
+	err := result.Decode(&list)
+	if err != nil {
+		logrus.Errorf("FindOneAndUpdate failed for default list, user %s Error: %s", userId, err)
 		return nil, err
 	}
 
-	return list, nil
+	if err := r.moveLegacyItems(ctx, userId, list.Id); err != nil {
+		return nil, err
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+// moveLegacyItems moves the user's items without a list_id into the list one by one, first added first.
+// An item whose product is already in the list is a duplicate, it is deleted instead of breaking the unique index.
+func (r repository) moveLegacyItems(ctx context.Context, userId string, listId string) error {
+
+	filter := bson.M{"user_id": userId, "list_id": bson.M{"$exists": false}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"_id": 1})
+
+	cur, err := r.items.Find(ctx, filter, opts)
+	if err != nil {
+		logrus.Errorf("Find failed for legacy items of user %s Error: %s", userId, err)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		var item struct {
+			Id interface{} `bson:"_id"`
+		}
+		if err := cur.Decode(&item); err != nil {
+			logrus.Errorf("Find legacy items decode failed for user %s Error: %s", userId, err)
+			return err
+		}
+
+		_, err := r.items.UpdateOne(ctx, bson.M{"_id": item.Id}, withUpdatedAt(bson.M{"$set": bson.M{"list_id": listId}}))
+		if isDuplicateKeyError(err) {
+			_, err = r.items.DeleteOne(ctx, bson.M{"_id": item.Id})
+		}
+		if err != nil {
+			logrus.Errorf("Moving legacy item %v of user %s failed Error: %s", item.Id, userId, err)
+			return err
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Find failed for legacy items of user %s Error: %s", userId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) CreateList(ctx context.Context, userId string, name string) (*model.List, error) {
+
+	list := &List{
+		Id:     primitive.NewObjectID().Hex(),
+		UserId: userId,
+		Name:   name,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.lists.InsertOne(ctx, list)
+	if err != nil {
+		logrus.Errorf("InsertOne failed for list %s, user %s Error: %s", name, userId, err)
+		return nil, err
+	}
+
+	return mapListToDomainList(list), nil
+}
+
+func (r repository) RenameList(ctx context.Context, userId string, listId string, name string) error {
+
+	filter := bson.M{"_id": listId, "user_id": userId}
+	update := bson.M{"$set": bson.M{"name": name}}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.lists.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logrus.Errorf("UpdateOne failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteList(ctx context.Context, userId string, listId string) error {
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+	result, err := r.lists.DeleteOne(ctx, bson.M{"_id": listId, "user_id": userId})
+	if err != nil {
+		logrus.Errorf("DeleteOne failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	// the items and shares belong to the owner of the list only
+	if result.DeletedCount == 0 {
+		return nil
+	}
+
+	_, err = r.items.DeleteMany(ctx, bson.M{"list_id": listId})
+	if err != nil {
+		logrus.Errorf("DeleteMany failed for items of list %s Error: %s", listId, err)
+		return err
+	}
+
+	_, err = r.shares.DeleteMany(ctx, bson.M{"list_id": listId})
+	if err != nil {
+		logrus.Errorf("DeleteMany failed for shares of list %s Error: %s", listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Share(ctx context.Context, token string) (*model.Share, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	result := r.shares.FindOne(ctx, bson.M{"_id": token})
+	if result.Err() != nil {
+
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			logrus.Infoln("No document for share token")
+			return nil, nil
+		}
+
+		logrus.Errorf("FindOne failed for share token Error: %s", result.Err())
+		return nil, result.Err()
+	}
+
+	var share *Share
+	err := result.Decode(&share)
+	if err != nil {
+		logrus.Errorf("FindOne failed for share token Error: %s", err)
+		return nil, err
+	}
+
+	return mapShareToDomainShare(share), nil
+}
+
+func (r repository) Shares(ctx context.Context, listId string) ([]*model.Share, error) {
+
+	shares := []*model.Share{}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	cur, err := r.shares.Find(ctx, bson.M{"list_id": listId})
+	if err != nil {
+		logrus.Errorf("Find shares failed for list %s Error: %s", listId, err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+
+		var share *Share
+		err := cur.Decode(&share)
+		if err != nil {
+			logrus.Errorf("Find shares decode failed for list %s Error: %s", listId, err)
+			return nil, err
+		}
+
+		shares = append(shares, mapShareToDomainShare(share))
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Find shares failed for list %s Error: %s", listId, err)
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+func (r repository) CreateShare(ctx context.Context, share *model.Share, event *model.Event) error {
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	err := r.inTx(ctx, func(ctx context.Context) error {
+		if _, err := r.shares.InsertOne(ctx, mapDomainShareToShare(share)); err != nil {
+			return err
+		}
+
+		return r.insertEvent(ctx, event)
+	})
+	if err != nil {
+		logrus.Errorf("InsertOne failed for share of list %s Error: %s", share.ListId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteShare(ctx context.Context, listId string, token string) error {
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.shares.DeleteOne(ctx, bson.M{"_id": token, "list_id": listId})
+	if err != nil {
+		logrus.Errorf("DeleteOne failed for share of list %s Error: %s", listId, err)
+		return err
+	}
+
+	return nil
+}
+
+// ReserveItem claims the item only if it's not reserved or the reservation has expired,
+// the filter and the update run as a single atomic operation
+func (r repository) ReserveItem(ctx context.Context, listId string, productId string, claimId string, expiresAt time.Time) (bool, error) {
+
+	filter := bson.M{
+		"list_id":    listId,
+		"product_id": productId,
+		"$or": bson.A{
+			bson.M{"reservation": bson.M{"$exists": false}},
+			bson.M{
+				"reservation.status":     model.ReservationStatusReserved,
+				"reservation.expires_at": bson.M{"$lte": time.Now()},
+			},
+		},
+	}
+
+	update := bson.M{"$set": bson.M{
+		"reservation": Reservation{
+			ClaimId:   claimId,
+			Status:    model.ReservationStatusReserved,
+			ExpiresAt: expiresAt,
+		},
+	}}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	result, err := r.items.UpdateOne(ctx, filter, withUpdatedAt(update))
+	if err != nil {
+		logrus.Errorf("UpdateOne failed for reserving product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r repository) ReleaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+
+	filter := bson.M{
+		"list_id":              listId,
+		"product_id":           productId,
+		"reservation.claim_id": claimId,
+		"reservation.status":   model.ReservationStatusReserved,
+	}
+
+	update := bson.M{"$unset": bson.M{"reservation": ""}}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	result, err := r.items.UpdateOne(ctx, filter, withUpdatedAt(update))
+	if err != nil {
+		logrus.Errorf("UpdateOne failed for releasing product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r repository) PurchaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+
+	filter := bson.M{
+		"list_id":              listId,
+		"product_id":           productId,
+		"reservation.claim_id": claimId,
+		"reservation.status":   model.ReservationStatusReserved,
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"reservation.status": model.ReservationStatusPurchased},
+		"$unset": bson.M{"reservation.expires_at": ""},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	result, err := r.items.UpdateOne(ctx, filter, withUpdatedAt(update))
+	if err != nil {
+		logrus.Errorf("UpdateOne failed for purchasing product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// SetAlertRule sets the alert rule of the item or removes it when the rule is nil
+func (r repository) SetAlertRule(ctx context.Context, listId string, productId string, rule *model.AlertRule) error {
+
+	filter := bson.M{"list_id": listId, "product_id": productId}
+
+	update := bson.M{"$unset": bson.M{"alert_rule": ""}}
+	if rule != nil {
+		update = bson.M{"$set": bson.M{"alert_rule": mapDomainAlertRuleToAlertRule(rule)}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.items.UpdateOne(ctx, filter, withUpdatedAt(update))
+	if err != nil {
+		logrus.Errorf("UpdateOne failed for alert rule of product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) PriceWatches(ctx context.Context, productId string) ([]*model.PriceWatch, error) {
+
+	watches := []*model.PriceWatch{}
+
+	filter := bson.M{"product_id": productId, "alert_rule": bson.M{"$exists": true}}
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	product, err := r.product(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	var price float32
+	if product != nil {
+		price = product.Price
+	}
+
+	cur, err := r.items.Find(ctx, filter)
+	if err != nil {
+		logrus.Errorf("Find price watches failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+
+		var item *Item
+		err := cur.Decode(&item)
+		if err != nil {
+			logrus.Errorf("Find price watches decode failed for product %s Error: %s", productId, err)
+			return nil, err
+		}
+
+		watches = append(watches, mapItemToDomainPriceWatch(item, price))
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Find price watches failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return watches, nil
+}
+
+func (r repository) CreateAlert(ctx context.Context, alert *model.PriceAlert, event *model.Event) error {
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	err := r.inTx(ctx, func(ctx context.Context) error {
+		if _, err := r.alerts.InsertOne(ctx, mapDomainAlertToAlert(alert)); err != nil {
+			return err
+		}
+
+		return r.insertEvent(ctx, event)
+	})
+	if err != nil {
+		logrus.Errorf("InsertOne failed for alert of product %s, user %s Error: %s", alert.ProductId, alert.UserId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Alerts(ctx context.Context, userId string) ([]*model.PriceAlert, error) {
+
+	alerts := []*model.PriceAlert{}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	cur, err := r.alerts.Find(ctx, bson.M{"user_id": userId}, opts)
+	if err != nil {
+		logrus.Errorf("Find alerts failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+
+		var alert *Alert
+		err := cur.Decode(&alert)
+		if err != nil {
+			logrus.Errorf("Find alerts decode failed for user %s Error: %s", userId, err)
+			return nil, err
+		}
+
+		alerts = append(alerts, mapAlertToDomainAlert(alert))
+	}
+
+	if err := cur.Err(); err != nil {
+		logrus.Errorf("Find alerts failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// withUpdatedAt stamps the update of item documents with the time of the write
+func withUpdatedAt(update bson.M) bson.M {
+	set, ok := update["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["updated_at"] = time.Now().UTC()
+
+	return update
+}
+
+func (r repository) EventProcessed(ctx context.Context, eventId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	// the TTL monitor removes the expired marks only once a minute
+	n, err := r.events.CountDocuments(ctx, bson.M{"_id": eventId, "expires_at": bson.M{"$gt": time.Now().UTC()}})
+	if err != nil {
+		logrus.Errorf("CountDocuments failed for event %s Error: %s", eventId, err)
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (r repository) MarkEventProcessed(ctx context.Context, eventId string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	_, err := r.events.UpdateOne(
+		ctx,
+		bson.M{"_id": eventId},
+		bson.M{"$set": bson.M{"expires_at": expiresAt.UTC()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logrus.Errorf("UpdateOne failed for event %s Error: %s", eventId, err)
+		return err
+	}
+
+	return nil
+}
+
+// requireTransactions fails unless the deployment is a replica set or a sharded cluster
+func requireTransactions(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), repo.DefaultTimeouts.Operation)
+	defer cancel()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		logrus.Errorf("isMaster failed Error: %s", err)
+		return err
+	}
+
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errStandalone
+	}
+
+	return nil
+}
+
+// inTx runs fn in a transaction, so the outbox event commits together with the change
+func (r repository) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	sess, err := r.client.StartSession()
+	if err != nil {
+		logrus.Errorf("StartSession failed Error: %s", err)
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// insertEvent stores the event in the outbox within the transaction of the change
+func (r repository) insertEvent(ctx context.Context, event *model.Event) error {
+	if event == nil {
+		return nil
+	}
+
+	_, err := r.outbox.InsertOne(ctx, mapDomainEventToEvent(event))
+	if err != nil {
+		logrus.Errorf("InsertOne failed for event %s Error: %s", event.Id, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) PendingEvents(ctx context.Context, limit int) ([]*model.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+	cur, err := r.outbox.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		logrus.Errorf("Find failed for outbox Error: %s", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	events := []*model.Event{}
+	for cur.Next(ctx) {
+		var event *Event
+		if err := cur.Decode(&event); err != nil {
+			logrus.Errorf("Decode failed for outbox Error: %s", err)
+			return nil, err
+		}
+		events = append(events, mapEventToDomainEvent(event))
+	}
+
+	return events, cur.Err()
+}
+
+func (r repository) MarkEventsPublished(ctx context.Context, events []*model.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.Id
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+	_, err := r.outbox.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		logrus.Errorf("DeleteMany failed for outbox Error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) OutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	n, err := r.outbox.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		logrus.Errorf("CountDocuments failed for outbox Error: %s", err)
+		return nil, err
+	}
+
+	stats := &model.OutboxStats{Pending: int(n)}
+	if n == 0 {
+		return stats, nil
+	}
+
+	var oldest *Event
+	opts := options.FindOne().SetSort(bson.D{{Key: "occurred_at", Value: 1}})
+	err = r.outbox.FindOne(ctx, bson.M{}, opts).Decode(&oldest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return stats, nil
+	}
+	if err != nil {
+		logrus.Errorf("FindOne failed for outbox Error: %s", err)
+		return nil, err
+	}
+
+	stats.Oldest = oldest.OccurredAt
+	return stats, nil
+}
+
+// EraseUser also deletes the items stored before named lists existed, they have the user_id only
+func (r repository) EraseUser(ctx context.Context, erasure *model.Erasure) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	err := r.inTx(ctx, func(ctx context.Context) error {
+		listIds, err := r.listIds(ctx, erasure.UserId)
+		if err != nil {
+			return err
+		}
+
+		items := bson.M{"$or": bson.A{
+			bson.M{"list_id": bson.M{"$in": listIds}},
+			bson.M{"user_id": erasure.UserId},
+		}}
+		reserved, err := r.items.CountDocuments(ctx, bson.M{"$and": bson.A{
+			items,
+			bson.M{"reservation": bson.M{"$exists": true}},
+		}})
+		if err != nil {
+			return err
+		}
+		erasure.Reservations = int(reserved)
+
+		productIds, err := r.items.Distinct(ctx, "product_id", items)
+		if err != nil {
+			return err
+		}
+
+		deletions := []struct {
+			collection *mongo.Collection
+			filter     bson.M
+			count      *int
+		}{
+			{r.items, items, &erasure.Items},
+			{r.shares, bson.M{"list_id": bson.M{"$in": listIds}}, &erasure.Shares},
+			{r.lists, bson.M{"user_id": erasure.UserId}, &erasure.Lists},
+			{r.alerts, bson.M{"user_id": erasure.UserId}, &erasure.Alerts},
+		}
+		for _, d := range deletions {
+			result, err := d.collection.DeleteMany(ctx, d.filter)
+			if err != nil {
+				return err
+			}
+			*d.count = int(result.DeletedCount)
+		}
+
+		if err := r.erasePriceHistory(ctx, productIds, erasure); err != nil {
+			return err
+		}
+
+		if err := r.eraseEvents(ctx, erasure); err != nil {
+			return err
+		}
+
+		_, err = r.erasures.InsertOne(ctx, mapDomainErasureToErasure(erasure))
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Erase failed for user %s Error: %s", erasure.UserId, err)
+		return err
+	}
+
+	return nil
+}
+
+// erasePriceHistory deletes the price history of the erased products which no other list has
+func (r repository) erasePriceHistory(ctx context.Context, productIds []interface{}, erasure *model.Erasure) error {
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	listed, err := r.items.Distinct(ctx, "product_id", bson.M{"product_id": bson.M{"$in": productIds}})
+	if err != nil {
+		return err
+	}
+	if listed == nil {
+		listed = []interface{}{}
+	}
+
+	result, err := r.priceHistory.DeleteMany(ctx, bson.M{"product_id": bson.M{"$in": productIds, "$nin": listed}})
+	if err != nil {
+		return err
+	}
+	erasure.PricePoints = int(result.DeletedCount)
+
+	return nil
+}
+
+// eraseEvents deletes the pending outbox events about the user, the user_id is in the JSON data
+func (r repository) eraseEvents(ctx context.Context, erasure *model.Erasure) error {
+	cur, err := r.outbox.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	ids := bson.A{}
+	for cur.Next(ctx) {
+		var event *Event
+		if err := cur.Decode(&event); err != nil {
+			return err
+		}
+		if repo.EventUserId(mapEventToDomainEvent(event)) == erasure.UserId {
+			ids = append(ids, event.Id)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	result, err := r.outbox.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	erasure.Events = int(result.DeletedCount)
+
+	return nil
+}
+
+func (r repository) listIds(ctx context.Context, userId string) ([]string, error) {
+	cur, err := r.lists.Find(ctx, bson.M{"user_id": userId}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	ids := []string{}
+	for cur.Next(ctx) {
+		var list *List
+		if err := cur.Decode(&list); err != nil {
+			return nil, err
+		}
+		ids = append(ids, list.Id)
+	}
+
+	return ids, cur.Err()
+}
+
+func (r repository) Erasures(ctx context.Context, userId string) ([]*model.Erasure, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "erased_at", Value: -1}})
+	cur, err := r.erasures.Find(ctx, bson.M{"user_id": userId}, opts)
+	if err != nil {
+		logrus.Errorf("Find erasures failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	erasures := []*model.Erasure{}
+	for cur.Next(ctx) {
+		var erasure *Erasure
+		if err := cur.Decode(&erasure); err != nil {
+			logrus.Errorf("Find erasures decode failed for user %s Error: %s", userId, err)
+			return nil, err
+		}
+		erasures = append(erasures, mapErasureToDomainErasure(erasure))
+	}
+
+	return erasures, cur.Err()
 }
@@ -0,0 +1,229 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	myerr "github.com/pejovski/wish-list/error"
+	repo "github.com/pejovski/wish-list/repository"
+	"github.com/pejovski/wish-list/repository/repositorytest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestRepository runs the contract on a throwaway database per test,
+// it is skipped unless MONGO_TEST_URL is set, e.g. mongodb://localhost:27017
+func TestRepository(t *testing.T) {
+	client := testClient(t)
+	defer client.Disconnect(context.Background())
+
+	repositorytest.Run(t, func(t *testing.T) (repo.Repository, func()) {
+		db := testDatabase(client)
+
+		r, err := newRepository(db, repo.DefaultTimeouts)
+		if err != nil {
+			t.Fatalf("newRepository failed: %s", err)
+		}
+
+		return r, func() {
+			dropDatabase(t, db)
+		}
+	})
+}
+
+// TestLegacyDuplicateItems covers the items stored before named lists, some of them twice:
+// the migration, the startup and the lazy move keep one item per product instead of failing on the unique index
+func TestLegacyDuplicateItems(t *testing.T) {
+	client := testClient(t)
+	defer client.Disconnect(context.Background())
+
+	t.Run("migrate", func(t *testing.T) {
+		db := testDatabase(client)
+		defer dropDatabase(t, db)
+
+		r := openRepository(db, repo.DefaultTimeouts)
+		seedLegacyItems(t, r, "p1", "p1", "p2")
+
+		if err := migrate(db); err != nil {
+			t.Fatalf("migrate failed: %s", err)
+		}
+
+		list, err := r.DefaultList(context.Background(), userId)
+		if err != nil || list == nil {
+			t.Fatalf("DefaultList = %v, %v, want the default list", list, err)
+		}
+
+		assertLegacyItems(t, r, list.Id, 2)
+	})
+
+	t.Run("startup", func(t *testing.T) {
+		db := testDatabase(client)
+		defer dropDatabase(t, db)
+
+		seedLegacyItems(t, openRepository(db, repo.DefaultTimeouts), "p1", "p1", "p2")
+
+		r, err := newRepository(db, repo.DefaultTimeouts)
+		if err != nil {
+			t.Fatalf("newRepository failed: %s", err)
+		}
+
+		// the read finds the items without a write of the user first
+		list, err := r.DefaultList(context.Background(), userId)
+		if err != nil || list == nil {
+			t.Fatalf("DefaultList = %v, %v, want the default list", list, err)
+		}
+
+		assertLegacyItems(t, r, list.Id, 2)
+	})
+
+	t.Run("lazy move", func(t *testing.T) {
+		db := testDatabase(client)
+		defer dropDatabase(t, db)
+
+		ctx := context.Background()
+		r, err := newRepository(db, repo.DefaultTimeouts)
+		if err != nil {
+			t.Fatalf("newRepository failed: %s", err)
+		}
+
+		list, err := r.EnsureDefaultList(ctx, userId)
+		if err != nil {
+			t.Fatalf("EnsureDefaultList failed: %s", err)
+		}
+		if err := r.CreateItem(ctx, userId, list.Id, "p1"); err != nil {
+			t.Fatalf("CreateItem failed: %s", err)
+		}
+
+		seedLegacyItems(t, r, "p1", "p2", "p2")
+
+		if _, err := r.EnsureDefaultList(ctx, userId); err != nil {
+			t.Fatalf("EnsureDefaultList with legacy duplicates failed: %s", err)
+		}
+
+		list, err = r.DefaultList(ctx, userId)
+		if err != nil || list == nil {
+			t.Fatalf("DefaultList = %v, %v, want the default list", list, err)
+		}
+
+		assertLegacyItems(t, r, list.Id, 2)
+	})
+}
+
+// TestStartupRemovesDuplicates covers a database written before the unique indexes:
+// the startup removes the duplicates and builds the indexes, so duplicate adds are rejected again
+func TestStartupRemovesDuplicates(t *testing.T) {
+	client := testClient(t)
+	defer client.Disconnect(context.Background())
+
+	db := testDatabase(client)
+	defer dropDatabase(t, db)
+
+	ctx := context.Background()
+	seed := openRepository(db, repo.DefaultTimeouts)
+	for _, id := range []string{"5f0000000000000000000001", "5f0000000000000000000002"} {
+		if _, err := seed.lists.InsertOne(ctx, bson.M{"_id": id, "user_id": userId, "name": defaultListName, "default": true}); err != nil {
+			t.Fatalf("Failed to seed default list: %s", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := seed.items.InsertOne(ctx, bson.M{"user_id": userId, "list_id": "5f0000000000000000000001", "product_id": "p1"}); err != nil {
+			t.Fatalf("Failed to seed item: %s", err)
+		}
+	}
+
+	r, err := newRepository(db, repo.DefaultTimeouts)
+	if err != nil {
+		t.Fatalf("newRepository over duplicates failed: %s", err)
+	}
+
+	list, err := r.DefaultList(ctx, userId)
+	if err != nil || list == nil || list.Id != "5f0000000000000000000001" {
+		t.Fatalf("DefaultList = %+v, %v, want the first default list", list, err)
+	}
+	if n, err := r.lists.CountDocuments(ctx, bson.M{"user_id": userId}); err != nil || n != 2 {
+		t.Fatalf("user has %d lists, %v, want the duplicate kept as a named list", n, err)
+	}
+
+	assertLegacyItems(t, r, list.Id, 1)
+
+	if err := r.CreateItem(ctx, userId, list.Id, "p1"); !errors.Is(err, myerr.ErrItemAlreadyExist) {
+		t.Fatalf("CreateItem of a duplicate returned %v, want ErrItemAlreadyExist", err)
+	}
+}
+
+const userId = "user-1"
+
+// seedLegacyItems inserts items the way they were stored before named lists, without a list_id
+func seedLegacyItems(t *testing.T, r repository, productIds ...string) {
+	t.Helper()
+
+	for i, productId := range productIds {
+		_, err := r.items.InsertOne(context.Background(), bson.M{
+			"user_id":    userId,
+			"product_id": productId,
+			"name":       productId,
+			"price":      float32(100 + i),
+			"active":     true,
+			"created_at": time.Now().UTC(),
+			"updated_at": time.Now().UTC(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to seed legacy item %s: %s", productId, err)
+		}
+	}
+}
+
+func assertLegacyItems(t *testing.T, r repository, listId string, want int64) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	if n, err := r.items.CountDocuments(ctx, bson.M{"list_id": bson.M{"$exists": false}}); err != nil || n != 0 {
+		t.Fatalf("%d items left without a list, %v", n, err)
+	}
+
+	n, err := r.items.CountDocuments(ctx, bson.M{"list_id": listId})
+	if err != nil {
+		t.Fatalf("CountDocuments failed: %s", err)
+	}
+	if n != want {
+		t.Fatalf("default list has %d items, want %d", n, want)
+	}
+}
+
+func testDatabase(client *mongo.Client) *mongo.Database {
+	return client.Database(fmt.Sprintf("wish_test_%d", time.Now().UnixNano()))
+}
+
+func dropDatabase(t *testing.T, db *mongo.Database) {
+	if err := db.Drop(context.Background()); err != nil {
+		t.Errorf("Failed to drop test database %s: %s", db.Name(), err)
+	}
+}
+
+func testClient(t *testing.T) *mongo.Client {
+	uri := os.Getenv("MONGO_TEST_URL")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("mongod not available at %s: %s", uri, err)
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		t.Fatalf("mongod not available at %s: %s", uri, err)
+	}
+
+	return client
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pejovski/wish-list/model"
+)
+
+// AlertRule is stored as jsonb on the item row
+type AlertRule struct {
+	TargetPrice    float32 `json:"target_price"`
+	DropPercentage float32 `json:"drop_percentage"`
+	ReferencePrice float32 `json:"reference_price"`
+}
+
+// Item is the item row joined with its product row, the product columns are empty until the item is enriched
+type Item struct {
+	Id        int64
+	ProductId string
+	Name      string
+	Brand     string
+	Price     float32
+	Image     string
+	Active    bool
+
+	Quantity int
+	Priority string
+	Note     string
+	Variant  []byte
+
+	PriceWhenAdded        float32
+	LowestPriceSinceAdded float32
+
+	ReservationStatus    sql.NullString
+	ReservationExpiresAt sql.NullTime
+
+	AlertRule []byte
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// itemColumns are the columns scanned by scanItem, items i is joined with products p
+const itemColumns = `i.id, i.product_id, COALESCE(p.name, ''), COALESCE(p.brand, ''), COALESCE(p.price, 0), COALESCE(p.image, ''),
+	COALESCE(p.active, TRUE), i.quantity, i.priority, i.note, i.variant,
+	COALESCE(i.price_when_added, 0), COALESCE(i.lowest_price_since_added, 0),
+	i.reservation_status, i.reservation_expires_at, i.alert_rule, i.created_at, i.updated_at`
+
+func scanItem(s scanner) (*Item, error) {
+	var item Item
+	err := s.Scan(
+		&item.Id, &item.ProductId, &item.Name, &item.Brand, &item.Price, &item.Image,
+		&item.Active, &item.Quantity, &item.Priority, &item.Note, &item.Variant,
+		&item.PriceWhenAdded, &item.LowestPriceSinceAdded,
+		&item.ReservationStatus, &item.ReservationExpiresAt, &item.AlertRule, &item.CreatedAt, &item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func mapItemToDomainItem(item *Item) (*model.Item, error) {
+	var variant map[string]string
+	if item.Variant != nil {
+		if err := json.Unmarshal(item.Variant, &variant); err != nil {
+			return nil, err
+		}
+	}
+
+	rule, err := mapAlertRuleToDomainAlertRule(item.AlertRule)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Item{
+		Product: &model.Product{
+			ProductId: item.ProductId,
+			Name:      item.Name,
+			Brand:     item.Brand,
+			Price:     item.Price,
+			Image:     item.Image,
+		},
+		Active:                item.Active,
+		Quantity:              item.Quantity,
+		Priority:              item.Priority,
+		Note:                  item.Note,
+		Variant:               variant,
+		PriceWhenAdded:        item.PriceWhenAdded,
+		LowestPriceSinceAdded: item.LowestPriceSinceAdded,
+		Reservation:           mapReservationToDomainReservation(item.ReservationStatus, item.ReservationExpiresAt),
+		AlertRule:             rule,
+		CreatedAt:             item.CreatedAt,
+		UpdatedAt:             item.UpdatedAt,
+	}, nil
+}
+
+// expired reservations are returned to the pool, so they are mapped as no reservation
+func mapReservationToDomainReservation(status sql.NullString, expiresAt sql.NullTime) *model.Reservation {
+	if !status.Valid {
+		return nil
+	}
+
+	if status.String != model.ReservationStatusReserved {
+		return &model.Reservation{Status: status.String}
+	}
+
+	if !expiresAt.Valid || !expiresAt.Time.After(time.Now()) {
+		return nil
+	}
+
+	t := expiresAt.Time
+	return &model.Reservation{
+		Status:    status.String,
+		ExpiresAt: &t,
+	}
+}
+
+func mapAlertRuleToDomainAlertRule(data []byte) (*model.AlertRule, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	var rule AlertRule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return nil, err
+	}
+
+	return &model.AlertRule{
+		TargetPrice:    rule.TargetPrice,
+		DropPercentage: rule.DropPercentage,
+		ReferencePrice: rule.ReferencePrice,
+	}, nil
+}
+
+// jsonb parameters are sent as text, nil is stored as NULL
+func mapDomainAlertRuleToJSON(rule *model.AlertRule) (interface{}, error) {
+	if rule == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(AlertRule{
+		TargetPrice:    rule.TargetPrice,
+		DropPercentage: rule.DropPercentage,
+		ReferencePrice: rule.ReferencePrice,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
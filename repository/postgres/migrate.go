@@ -0,0 +1,231 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/sirupsen/logrus"
+)
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations are applied in order and never changed once released, a schema change is a new version
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create lists, products and items",
+		SQL: `
+CREATE TABLE lists (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	is_default BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX lists_user_id_idx ON lists (user_id);
+CREATE UNIQUE INDEX lists_user_id_default_idx ON lists (user_id) WHERE is_default;
+
+CREATE TABLE products (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	brand      TEXT NOT NULL,
+	price      REAL NOT NULL,
+	image      TEXT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE items (
+	id                       BIGSERIAL PRIMARY KEY,
+	user_id                  TEXT NOT NULL,
+	list_id                  TEXT NOT NULL REFERENCES lists (id) ON DELETE CASCADE,
+	product_id               TEXT NOT NULL,
+	active                   BOOLEAN NOT NULL DEFAULT TRUE,
+	quantity                 INTEGER NOT NULL DEFAULT 1,
+	priority                 TEXT NOT NULL,
+	note                     TEXT NOT NULL DEFAULT '',
+	variant                  JSONB,
+	price_when_added         REAL,
+	lowest_price_since_added REAL,
+	reservation_claim_id     TEXT,
+	reservation_status       TEXT,
+	reservation_expires_at   TIMESTAMPTZ,
+	alert_rule               JSONB,
+	created_at               TIMESTAMPTZ NOT NULL,
+	updated_at               TIMESTAMPTZ NOT NULL,
+	UNIQUE (list_id, product_id)
+);
+CREATE INDEX items_product_id_idx ON items (product_id);
+`,
+	},
+	{
+		Version: 2,
+		Name:    "create shares, alerts and price history",
+		SQL: `
+CREATE TABLE shares (
+	token      TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	list_id    TEXT NOT NULL REFERENCES lists (id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX shares_list_id_idx ON shares (list_id);
+
+CREATE TABLE alerts (
+	id           TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL,
+	list_id      TEXT NOT NULL,
+	product_id   TEXT NOT NULL,
+	old_price    REAL NOT NULL,
+	new_price    REAL NOT NULL,
+	target_price REAL NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX alerts_user_id_created_at_idx ON alerts (user_id, created_at DESC);
+
+CREATE TABLE price_history (
+	id          BIGSERIAL PRIMARY KEY,
+	product_id  TEXT NOT NULL,
+	price       REAL NOT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX price_history_product_id_idx ON price_history (product_id, recorded_at);
+`,
+	},
+	{
+		Version: 3,
+		Name:    "add price ordering and processed events",
+		SQL: `
+ALTER TABLE products ADD COLUMN price_updated_at TIMESTAMPTZ;
+
+CREATE TABLE processed_events (
+	id         TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX processed_events_expires_at_idx ON processed_events (expires_at);
+`,
+	},
+	{
+		Version: 4,
+		Name:    "create outbox",
+		SQL: `
+CREATE TABLE outbox (
+	id          TEXT PRIMARY KEY,
+	type        TEXT NOT NULL,
+	version     INTEGER NOT NULL,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	data        JSON NOT NULL
+);
+CREATE INDEX outbox_occurred_at_idx ON outbox (occurred_at, id);
+`,
+	},
+	{
+		Version: 5,
+		Name:    "create erasures",
+		SQL: `
+CREATE TABLE erasures (
+	id           TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL,
+	source       TEXT NOT NULL,
+	lists        INTEGER NOT NULL,
+	items        INTEGER NOT NULL,
+	reservations INTEGER NOT NULL,
+	shares       INTEGER NOT NULL,
+	alerts       INTEGER NOT NULL,
+	erased_at    TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX erasures_user_id_erased_at_idx ON erasures (user_id, erased_at DESC);
+`,
+	},
+	{
+		Version: 6,
+		Name:    "move active from items to products",
+		SQL: `
+ALTER TABLE products ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+UPDATE products p SET active = FALSE
+WHERE EXISTS (SELECT 1 FROM items i WHERE i.product_id = p.id AND NOT i.active);
+ALTER TABLE items DROP COLUMN active;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "count erased price history and events",
+		SQL: `
+ALTER TABLE erasures ADD COLUMN price_points INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE erasures ADD COLUMN events INTEGER NOT NULL DEFAULT 0;
+`,
+	},
+}
+
+// migrationLock is the advisory lock which keeps concurrently starting instances from migrating twice
+const migrationLock = 7343201
+
+// Migrate applies the pending migrations, each one in its own transaction
+func Migrate(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to get connection for migrations Error: %s", err)
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		logrus.Errorf("Failed to lock migrations Error: %s", err)
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	_, err = conn.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`)
+	if err != nil {
+		logrus.Errorf("Failed to create schema_migrations Error: %s", err)
+		return err
+	}
+
+	var current int
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		logrus.Errorf("Failed to read schema version Error: %s", err)
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if err := apply(ctx, conn, m); err != nil {
+			logrus.Errorf("Migration %d %s failed Error: %s", m.Version, m.Name, err)
+			return err
+		}
+
+		logrus.Infof("Migration %d %s applied", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+func apply(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
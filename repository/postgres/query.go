@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+)
+
+// sortColumns maps the domain sort to the column, the item id is the added order and the tie breaker
+var sortColumns = map[string]string{
+	model.SortAdded:    "",
+	model.SortPrice:    "p.price",
+	model.SortName:     "p.name",
+	model.SortPriority: "i.priority",
+}
+
+// cursor points after the last item of a page
+type cursor struct {
+	Id       int64   `json:"id"`
+	Price    float32 `json:"price,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	Priority string  `json:"priority,omitempty"`
+}
+
+// itemsQuery builds the page query, the inner join leaves out the items which are not enriched yet
+func itemsQuery(listId string, query *model.ItemQuery) (string, []interface{}, error) {
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{"i.list_id = " + arg(listId)}
+
+	if query.Active != nil {
+		where = append(where, "p.active = "+arg(*query.Active))
+	}
+
+	if query.Brand != "" {
+		where = append(where, "p.brand = "+arg(query.Brand))
+	}
+
+	if query.MinPrice != nil {
+		where = append(where, "p.price >= "+arg(*query.MinPrice))
+	}
+	if query.MaxPrice != nil {
+		where = append(where, "p.price <= "+arg(*query.MaxPrice))
+	}
+
+	// expired reservations are not reserved
+	if query.Reserved != nil {
+		purchased := arg(model.ReservationStatusPurchased)
+		reserved := arg(model.ReservationStatusReserved)
+		if *query.Reserved {
+			where = append(where, fmt.Sprintf(
+				"(i.reservation_status = %s OR (i.reservation_status = %s AND i.reservation_expires_at > now()))",
+				purchased, reserved,
+			))
+		} else {
+			where = append(where, fmt.Sprintf(
+				"(i.reservation_status IS NULL OR (i.reservation_status = %s AND i.reservation_expires_at <= now()))",
+				reserved,
+			))
+		}
+	}
+
+	if query.AddedSince != nil {
+		where = append(where, "i.created_at >= "+arg(*query.AddedSince))
+	}
+
+	column := sortColumns[query.Sort]
+	op, dir := ">", "ASC"
+	if query.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if column == "" {
+			where = append(where, fmt.Sprintf("i.id %s %s", op, arg(c.Id)))
+		} else {
+			where = append(where, fmt.Sprintf("(%s, i.id) %s (%s, %s)", column, op, arg(cursorValue(c, query)), arg(c.Id)))
+		}
+	}
+
+	order := "i.id " + dir
+	if column != "" {
+		order = fmt.Sprintf("%s %s, i.id %s", column, dir, dir)
+	}
+
+	// one more item is fetched to know if there is a next page
+	sql := fmt.Sprintf(
+		"SELECT %s FROM items i JOIN products p ON p.id = i.product_id WHERE %s ORDER BY %s LIMIT %s",
+		itemColumns, strings.Join(where, " AND "), order, arg(query.Limit+1),
+	)
+
+	return sql, args, nil
+}
+
+func cursorValue(c *cursor, query *model.ItemQuery) interface{} {
+	switch query.Sort {
+	case model.SortPrice:
+		return c.Price
+	case model.SortName:
+		return c.Name
+	default:
+		return c.Priority
+	}
+}
+
+func encodeCursor(item *Item) string {
+	b, _ := json.Marshal(cursor{
+		Id:       item.Id,
+		Price:    item.Price,
+		Name:     item.Name,
+		Priority: item.Priority,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Id == 0 {
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,1129 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	repo "github.com/pejovski/wish-list/repository"
+)
+
+const defaultListName = "Wish List"
+
+type repository struct {
+	db       *sql.DB
+	timeouts repo.Timeouts
+}
+
+// NewRepository expects the schema to be migrated with Migrate
+func NewRepository(db *sql.DB, t repo.Timeouts) repo.Repository {
+	return repository{db: db, timeouts: t}
+}
+
+func (r repository) Product(ctx context.Context, productId string) (*model.Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	var product model.Product
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, name, brand, price, image FROM products WHERE id = $1`,
+		productId,
+	).Scan(&product.ProductId, &product.Name, &product.Brand, &product.Price, &product.Image)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Infof("No row for product %s", productId)
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Select failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// UpdateProduct stores the product only while it is wish-listed, the catalog stays the owner of the rest
+func (r repository) UpdateProduct(ctx context.Context, product *model.Product) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := recordPrice(ctx, tx, product.ProductId, product.Price); err != nil {
+			return err
+		}
+
+		if err := upsertProduct(ctx, tx, product, true); err != nil {
+			return err
+		}
+
+		return updateItemPrices(ctx, tx, "product_id = $2", product.Price, product.ProductId)
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for product %s; Error: %s", product.ProductId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeactivateProduct(ctx context.Context, productId string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	return r.setProductActive(ctx, productId, false)
+}
+
+func (r repository) ActivateProduct(ctx context.Context, productId string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	return r.setProductActive(ctx, productId, true)
+}
+
+func (r repository) setProductActive(ctx context.Context, productId string, active bool) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE products SET active = $2, updated_at = now() WHERE id = $1`,
+		productId, active,
+	)
+	if err != nil {
+		logrus.Errorf("Update failed for active %t of product %s; Error: %s", active, productId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteProduct(ctx context.Context, productId string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM items WHERE product_id = $1`, productId); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM products WHERE id = $1`, productId)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Delete failed for product %s; Error: %s", productId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	stale := false
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var priceAt sql.NullTime
+		err := tx.QueryRowContext(ctx,
+			`SELECT price_updated_at FROM products WHERE id = $1 FOR UPDATE`,
+			productId,
+		).Scan(&priceAt)
+
+		// a product which is not wish-listed has no row
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if priceAt.Valid && priceAt.Time.After(at) {
+			stale = true
+			return nil
+		}
+
+		if err := recordPrice(ctx, tx, productId, price); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE products SET price = $2, price_updated_at = $3, updated_at = now() WHERE id = $1`,
+			productId, price, at,
+		)
+		if err != nil {
+			return err
+		}
+
+		return updateItemPrices(ctx, tx, "product_id = $2", price, productId)
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for price of product %s; Error: %s", productId, err)
+		return false, err
+	}
+
+	return stale, nil
+}
+
+// recordPrice appends the price to the history of a wish-listed product if it differs from the stored one,
+// it runs before the product gets the price
+func recordPrice(ctx context.Context, tx *sql.Tx, productId string, price float32) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO price_history (product_id, price, recorded_at)
+SELECT $1::text, $2::real, now()
+WHERE EXISTS (SELECT 1 FROM items WHERE product_id = $1::text)
+AND NOT EXISTS (SELECT 1 FROM products WHERE id = $1::text AND price = $2::real)`,
+		productId, price,
+	)
+
+	return err
+}
+
+// upsertProduct stores the catalog data, activate makes a deactivated product active again when the catalog returned it
+func upsertProduct(ctx context.Context, tx *sql.Tx, product *model.Product, activate bool) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO products (id, name, brand, price, image, price_updated_at, updated_at)
+SELECT $1::text, $2::text, $3::text, $4::real, $5::text, now(), now()
+WHERE EXISTS (SELECT 1 FROM items WHERE product_id = $1::text)
+ON CONFLICT (id) DO UPDATE SET
+	name = EXCLUDED.name, brand = EXCLUDED.brand, price = EXCLUDED.price, image = EXCLUDED.image,
+	active = products.active OR $6::boolean, price_updated_at = now(), updated_at = now()`,
+		product.ProductId, product.Name, product.Brand, product.Price, product.Image, activate,
+	)
+
+	return err
+}
+
+// updateItemPrices keeps the first known price and the lowest one since the item was added,
+// $1 is the price and the filter starts at $2
+func updateItemPrices(ctx context.Context, tx *sql.Tx, filter string, price float32, args ...interface{}) error {
+	_, err := tx.ExecContext(ctx, `
+UPDATE items SET
+	price_when_added = COALESCE(price_when_added, $1),
+	lowest_price_since_added = LEAST(lowest_price_since_added, $1),
+	updated_at = now()
+WHERE `+filter,
+		append([]interface{}{price}, args...)...,
+	)
+
+	return err
+}
+
+func (r repository) PriceHistory(ctx context.Context, productId string) ([]*model.PricePoint, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	points := []*model.PricePoint{}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT price, recorded_at FROM price_history WHERE product_id = $1 ORDER BY recorded_at, id`,
+		productId,
+	)
+	if err != nil {
+		logrus.Errorf("Select price history failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var point model.PricePoint
+		if err := rows.Scan(&point.Price, &point.RecordedAt); err != nil {
+			logrus.Errorf("Select price history scan failed for product %s Error: %s", productId, err)
+			return nil, err
+		}
+		points = append(points, &point)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Select price history failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return points, nil
+}
+
+func (r repository) Item(ctx context.Context, listId string, productId string) (*model.Item, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+itemColumns+` FROM items i LEFT JOIN products p ON p.id = i.product_id
+		WHERE i.list_id = $1 AND i.product_id = $2`,
+		listId, productId,
+	)
+
+	item, err := scanItem(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Infof("No row for product %s, list %s", productId, listId)
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Select failed for product %s, list %s Error: %s", productId, listId, err)
+		return nil, err
+	}
+
+	i, err := mapItemToDomainItem(item)
+	if err != nil {
+		logrus.Errorf("Select failed for product %s, list %s Error: %s", productId, listId, err)
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func (r repository) CreateItem(ctx context.Context, userId string, listId string, productId string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	now := time.Now().UTC()
+	result, err := r.db.ExecContext(ctx, `
+INSERT INTO items (user_id, list_id, product_id, quantity, priority, created_at, updated_at)
+VALUES ($1, $2, $3, 1, $4, $5, $5)
+ON CONFLICT (list_id, product_id) DO NOTHING`,
+		userId, listId, productId, model.PriorityNiceToHave, now,
+	)
+	if err != nil {
+		logrus.Errorf("Insert failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return myerr.ErrItemAlreadyExist
+	}
+
+	return nil
+}
+
+func (r repository) DeleteItem(ctx context.Context, listId string, productId string, event *model.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM items WHERE list_id = $1 AND product_id = $2`, listId, productId)
+		if err != nil {
+			return err
+		}
+
+		// nothing removed, nothing to tell
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		return insertEvent(ctx, tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Delete failed for product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) UpdateItem(ctx context.Context, listId string, product *model.Product, event *model.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := upsertProduct(ctx, tx, product, false); err != nil {
+			return err
+		}
+
+		err := updateItemPrices(ctx, tx, "list_id = $2 AND product_id = $3", product.Price, listId, product.ProductId)
+		if err != nil {
+			return err
+		}
+
+		return insertEvent(ctx, tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Update failed for product %s, list %s; Error: %s", product.ProductId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) UpdateItemDetails(ctx context.Context, listId string, productId string, details *model.ItemDetails) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	args := []interface{}{listId, productId}
+	set := []string{}
+	add := func(column string, v interface{}) {
+		args = append(args, v)
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if details.Quantity != nil {
+		add("quantity", *details.Quantity)
+	}
+	if details.Priority != nil {
+		add("priority", *details.Priority)
+	}
+	if details.Note != nil {
+		add("note", *details.Note)
+	}
+	if details.Variant != nil {
+		b, err := json.Marshal(details.Variant)
+		if err != nil {
+			return err
+		}
+		add("variant", string(b))
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE items SET `+strings.Join(set, ", ")+`, updated_at = now() WHERE list_id = $1 AND product_id = $2`,
+		args...,
+	)
+	if err != nil {
+		logrus.Errorf("Update failed for details of product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Items(ctx context.Context, listId string, query *model.ItemQuery) (model.Items, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	items := model.Items{}
+
+	q, args, err := itemsQuery(listId, query)
+	if err != nil {
+		logrus.Errorf("Select items failed for list %s Error: %s", listId, err)
+		return nil, "", err
+	}
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		logrus.Errorf("Select items failed for list %s Error: %s", listId, err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var last *Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			logrus.Errorf("Select items scan failed for list %s Error: %s", listId, err)
+			return nil, "", err
+		}
+
+		if len(items) == query.Limit {
+			return items, encodeCursor(last), nil
+		}
+
+		i, err := mapItemToDomainItem(item)
+		if err != nil {
+			logrus.Errorf("Select items failed for list %s Error: %s", listId, err)
+			return nil, "", err
+		}
+
+		items = append(items, i)
+		last = item
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Select items failed for list %s Error: %s", listId, err)
+		return nil, "", err
+	}
+
+	return items, "", nil
+}
+
+func (r repository) Lists(ctx context.Context, userId string) ([]*model.List, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	lists := []*model.List{}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, name, is_default FROM lists WHERE user_id = $1 ORDER BY created_at, id`,
+		userId,
+	)
+	if err != nil {
+		logrus.Errorf("Select lists failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var list model.List
+		if err := rows.Scan(&list.Id, &list.UserId, &list.Name, &list.Default); err != nil {
+			logrus.Errorf("Select lists scan failed for user %s Error: %s", userId, err)
+			return nil, err
+		}
+		lists = append(lists, &list)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Select lists failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (r repository) List(ctx context.Context, userId string, listId string) (*model.List, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	var list model.List
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, user_id, name, is_default FROM lists WHERE id = $1 AND user_id = $2`,
+		listId, userId,
+	).Scan(&list.Id, &list.UserId, &list.Name, &list.Default)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Infof("No row for list %s, user %s", listId, userId)
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Select failed for list %s, user %s Error: %s", listId, userId, err)
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+func (r repository) DefaultList(ctx context.Context, userId string) (*model.List, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	var list model.List
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, user_id, name, is_default FROM lists WHERE user_id = $1 AND is_default`,
+		userId,
+	).Scan(&list.Id, &list.UserId, &list.Name, &list.Default)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Infof("No default list for user %s", userId)
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Select failed for default list, user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// EnsureDefaultList returns the user's default list, creating it on first access,
+// the partial unique index keeps one default list per user
+func (r repository) EnsureDefaultList(ctx context.Context, userId string) (*model.List, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO lists (id, user_id, name, is_default) VALUES ($1, $2, $3, TRUE)
+ON CONFLICT (user_id) WHERE is_default DO NOTHING`,
+		newId(), userId, defaultListName,
+	)
+	if err != nil {
+		logrus.Errorf("Insert failed for default list, user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	var list model.List
+	err = r.db.QueryRowContext(ctx,
+		`SELECT id, user_id, name, is_default FROM lists WHERE user_id = $1 AND is_default`,
+		userId,
+	).Scan(&list.Id, &list.UserId, &list.Name, &list.Default)
+	if err != nil {
+		logrus.Errorf("Select failed for default list, user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+func (r repository) CreateList(ctx context.Context, userId string, name string) (*model.List, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	list := &model.List{
+		Id:     newId(),
+		UserId: userId,
+		Name:   name,
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO lists (id, user_id, name) VALUES ($1, $2, $3)`,
+		list.Id, list.UserId, list.Name,
+	)
+	if err != nil {
+		logrus.Errorf("Insert failed for list %s, user %s Error: %s", name, userId, err)
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (r repository) RenameList(ctx context.Context, userId string, listId string, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE lists SET name = $3 WHERE id = $1 AND user_id = $2`,
+		listId, userId, name,
+	)
+	if err != nil {
+		logrus.Errorf("Update failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteList deletes the items and shares of the list by cascade
+func (r repository) DeleteList(ctx context.Context, userId string, listId string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM lists WHERE id = $1 AND user_id = $2`, listId, userId)
+	if err != nil {
+		logrus.Errorf("Delete failed for list %s, user %s Error: %s", listId, userId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Share(ctx context.Context, token string) (*model.Share, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	var share model.Share
+	err := r.db.QueryRowContext(ctx,
+		`SELECT token, user_id, list_id, created_at FROM shares WHERE token = $1`,
+		token,
+	).Scan(&share.Token, &share.UserId, &share.ListId, &share.CreatedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Infoln("No row for share token")
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Select failed for share token Error: %s", err)
+		return nil, err
+	}
+
+	return &share, nil
+}
+
+func (r repository) Shares(ctx context.Context, listId string) ([]*model.Share, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	shares := []*model.Share{}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT token, user_id, list_id, created_at FROM shares WHERE list_id = $1 ORDER BY created_at`,
+		listId,
+	)
+	if err != nil {
+		logrus.Errorf("Select shares failed for list %s Error: %s", listId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var share model.Share
+		if err := rows.Scan(&share.Token, &share.UserId, &share.ListId, &share.CreatedAt); err != nil {
+			logrus.Errorf("Select shares scan failed for list %s Error: %s", listId, err)
+			return nil, err
+		}
+		shares = append(shares, &share)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Select shares failed for list %s Error: %s", listId, err)
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+func (r repository) CreateShare(ctx context.Context, share *model.Share, event *model.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO shares (token, user_id, list_id, created_at) VALUES ($1, $2, $3, $4)`,
+			share.Token, share.UserId, share.ListId, share.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		return insertEvent(ctx, tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Insert failed for share of list %s Error: %s", share.ListId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) DeleteShare(ctx context.Context, listId string, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM shares WHERE token = $1 AND list_id = $2`, token, listId)
+	if err != nil {
+		logrus.Errorf("Delete failed for share of list %s Error: %s", listId, err)
+		return err
+	}
+
+	return nil
+}
+
+// ReserveItem claims the item only if it's not reserved or the reservation has expired,
+// the check and the update run as a single statement
+func (r repository) ReserveItem(ctx context.Context, listId string, productId string, claimId string, expiresAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+UPDATE items SET
+	reservation_claim_id = $3, reservation_status = $4, reservation_expires_at = $5, updated_at = now()
+WHERE list_id = $1 AND product_id = $2
+	AND (reservation_status IS NULL OR (reservation_status = $4 AND reservation_expires_at <= now()))`,
+		listId, productId, claimId, model.ReservationStatusReserved, expiresAt,
+	)
+	if err != nil {
+		logrus.Errorf("Update failed for reserving product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (r repository) ReleaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+UPDATE items SET
+	reservation_claim_id = NULL, reservation_status = NULL, reservation_expires_at = NULL, updated_at = now()
+WHERE list_id = $1 AND product_id = $2 AND reservation_claim_id = $3 AND reservation_status = $4`,
+		listId, productId, claimId, model.ReservationStatusReserved,
+	)
+	if err != nil {
+		logrus.Errorf("Update failed for releasing product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (r repository) PurchaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+UPDATE items SET
+	reservation_status = $5, reservation_expires_at = NULL, updated_at = now()
+WHERE list_id = $1 AND product_id = $2 AND reservation_claim_id = $3 AND reservation_status = $4`,
+		listId, productId, claimId, model.ReservationStatusReserved, model.ReservationStatusPurchased,
+	)
+	if err != nil {
+		logrus.Errorf("Update failed for purchasing product %s, list %s Error: %s", productId, listId, err)
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// SetAlertRule sets the alert rule of the item or removes it when the rule is nil
+func (r repository) SetAlertRule(ctx context.Context, listId string, productId string, rule *model.AlertRule) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	value, err := mapDomainAlertRuleToJSON(rule)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		`UPDATE items SET alert_rule = $3, updated_at = now() WHERE list_id = $1 AND product_id = $2`,
+		listId, productId, value,
+	)
+	if err != nil {
+		logrus.Errorf("Update failed for alert rule of product %s, list %s Error: %s", productId, listId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) PriceWatches(ctx context.Context, productId string) ([]*model.PriceWatch, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	watches := []*model.PriceWatch{}
+
+	rows, err := r.db.QueryContext(ctx, `
+SELECT i.user_id, i.list_id, i.product_id, COALESCE(p.price, 0), i.alert_rule
+FROM items i LEFT JOIN products p ON p.id = i.product_id
+WHERE i.product_id = $1 AND i.alert_rule IS NOT NULL`,
+		productId,
+	)
+	if err != nil {
+		logrus.Errorf("Select price watches failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var watch model.PriceWatch
+		var rule []byte
+		if err := rows.Scan(&watch.UserId, &watch.ListId, &watch.ProductId, &watch.Price, &rule); err != nil {
+			logrus.Errorf("Select price watches scan failed for product %s Error: %s", productId, err)
+			return nil, err
+		}
+
+		watch.Rule, err = mapAlertRuleToDomainAlertRule(rule)
+		if err != nil {
+			logrus.Errorf("Select price watches failed for product %s Error: %s", productId, err)
+			return nil, err
+		}
+
+		watches = append(watches, &watch)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Select price watches failed for product %s Error: %s", productId, err)
+		return nil, err
+	}
+
+	return watches, nil
+}
+
+func (r repository) CreateAlert(ctx context.Context, alert *model.PriceAlert, event *model.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO alerts (id, user_id, list_id, product_id, old_price, new_price, target_price, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			alert.Id, alert.UserId, alert.ListId, alert.ProductId, alert.OldPrice, alert.NewPrice, alert.TargetPrice, alert.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		return insertEvent(ctx, tx, event)
+	})
+	if err != nil {
+		logrus.Errorf("Insert failed for alert of product %s, user %s Error: %s", alert.ProductId, alert.UserId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Alerts(ctx context.Context, userId string) ([]*model.PriceAlert, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	alerts := []*model.PriceAlert{}
+
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, user_id, list_id, product_id, old_price, new_price, target_price, created_at
+FROM alerts WHERE user_id = $1 ORDER BY created_at DESC`,
+		userId,
+	)
+	if err != nil {
+		logrus.Errorf("Select alerts failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a model.PriceAlert
+		err := rows.Scan(&a.Id, &a.UserId, &a.ListId, &a.ProductId, &a.OldPrice, &a.NewPrice, &a.TargetPrice, &a.CreatedAt)
+		if err != nil {
+			logrus.Errorf("Select alerts scan failed for user %s Error: %s", userId, err)
+			return nil, err
+		}
+		alerts = append(alerts, &a)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Select alerts failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+func (r repository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// newId returns a random id with the length of a hex ObjectID
+func newId() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func (r repository) EventProcessed(ctx context.Context, eventId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	var processed bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM processed_events WHERE id = $1 AND expires_at > now())`,
+		eventId,
+	).Scan(&processed)
+	if err != nil {
+		logrus.Errorf("Select failed for event %s Error: %s", eventId, err)
+		return false, err
+	}
+
+	return processed, nil
+}
+
+// MarkEventProcessed marks the event and removes the expired marks
+func (r repository) MarkEventProcessed(ctx context.Context, eventId string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM processed_events WHERE expires_at <= now()`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO processed_events (id, expires_at) VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE SET expires_at = EXCLUDED.expires_at`,
+			eventId, expiresAt,
+		)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Insert failed for event %s Error: %s", eventId, err)
+		return err
+	}
+
+	return nil
+}
+
+// insertEvent stores the event in the outbox within the transaction of the change
+func insertEvent(ctx context.Context, tx *sql.Tx, event *model.Event) error {
+	if event == nil {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO outbox (id, type, version, occurred_at, data) VALUES ($1, $2, $3, $4, $5)`,
+		event.Id, event.Type, event.Version, event.OccurredAt, []byte(event.Data),
+	)
+	return err
+}
+
+func (r repository) PendingEvents(ctx context.Context, limit int) ([]*model.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, type, version, occurred_at, data FROM outbox ORDER BY occurred_at, id LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		logrus.Errorf("Select failed for outbox Error: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*model.Event{}
+	for rows.Next() {
+		var event model.Event
+		var data []byte
+		if err := rows.Scan(&event.Id, &event.Type, &event.Version, &event.OccurredAt, &data); err != nil {
+			logrus.Errorf("Scan failed for outbox Error: %s", err)
+			return nil, err
+		}
+		event.Data = data
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+func (r repository) MarkEventsPublished(ctx context.Context, events []*model.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Operation)
+	defer cancel()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.Id
+	}
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM outbox WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		logrus.Errorf("Delete failed for outbox Error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) OutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	stats := &model.OutboxStats{}
+	var oldest sql.NullTime
+	err := r.db.QueryRowContext(ctx, `SELECT count(*), min(occurred_at) FROM outbox`).Scan(&stats.Pending, &oldest)
+	if err != nil {
+		logrus.Errorf("Select failed for outbox stats Error: %s", err)
+		return nil, err
+	}
+
+	if oldest.Valid {
+		stats.Oldest = oldest.Time
+	}
+
+	return stats, nil
+}
+
+func (r repository) EraseUser(ctx context.Context, erasure *model.Erasure) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		// the price history of the products no other list has goes with the items
+		result, err := tx.ExecContext(ctx, `
+DELETE FROM price_history ph
+WHERE ph.product_id IN (SELECT product_id FROM items WHERE user_id = $1)
+AND NOT EXISTS (SELECT 1 FROM items i WHERE i.product_id = ph.product_id AND i.user_id <> $1)`,
+			erasure.UserId,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		erasure.PricePoints = int(n)
+
+		err = tx.QueryRowContext(ctx, `
+WITH deleted AS (
+	DELETE FROM items WHERE user_id = $1 OR list_id IN (SELECT id FROM lists WHERE user_id = $1)
+	RETURNING reservation_claim_id
+)
+SELECT count(*), count(reservation_claim_id) FROM deleted`,
+			erasure.UserId,
+		).Scan(&erasure.Items, &erasure.Reservations)
+		if err != nil {
+			return err
+		}
+
+		deletions := []struct {
+			query string
+			count *int
+		}{
+			{`DELETE FROM shares WHERE list_id IN (SELECT id FROM lists WHERE user_id = $1)`, &erasure.Shares},
+			{`DELETE FROM lists WHERE user_id = $1`, &erasure.Lists},
+			{`DELETE FROM alerts WHERE user_id = $1`, &erasure.Alerts},
+			{`DELETE FROM outbox WHERE data->>'user_id' = $1`, &erasure.Events},
+		}
+		for _, d := range deletions {
+			result, err := tx.ExecContext(ctx, d.query, erasure.UserId)
+			if err != nil {
+				return err
+			}
+
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			*d.count = int(n)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO erasures (id, user_id, source, lists, items, reservations, shares, alerts, price_points, events, erased_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			erasure.Id, erasure.UserId, erasure.Source, erasure.Lists, erasure.Items,
+			erasure.Reservations, erasure.Shares, erasure.Alerts, erasure.PricePoints, erasure.Events, erasure.ErasedAt,
+		)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Erase failed for user %s Error: %s", erasure.UserId, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r repository) Erasures(ctx context.Context, userId string) ([]*model.Erasure, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, user_id, source, lists, items, reservations, shares, alerts, price_points, events, erased_at
+FROM erasures WHERE user_id = $1 ORDER BY erased_at DESC`,
+		userId,
+	)
+	if err != nil {
+		logrus.Errorf("Select erasures failed for user %s Error: %s", userId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	erasures := []*model.Erasure{}
+	for rows.Next() {
+		var e model.Erasure
+		err := rows.Scan(
+			&e.Id, &e.UserId, &e.Source, &e.Lists, &e.Items, &e.Reservations, &e.Shares, &e.Alerts,
+			&e.PricePoints, &e.Events, &e.ErasedAt,
+		)
+		if err != nil {
+			logrus.Errorf("Scan erasures failed for user %s Error: %s", userId, err)
+			return nil, err
+		}
+		erasures = append(erasures, &e)
+	}
+
+	return erasures, rows.Err()
+}
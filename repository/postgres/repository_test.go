@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	repo "github.com/pejovski/wish-list/repository"
+	"github.com/pejovski/wish-list/repository/repositorytest"
+)
+
+// TestRepository migrates a throwaway schema per test and runs the contract on it,
+// it is skipped unless POSTGRES_TEST_URL is set, e.g. postgres://postgres@localhost:5432/postgres?sslmode=disable
+func TestRepository(t *testing.T) {
+	testURL := os.Getenv("POSTGRES_TEST_URL")
+	if testURL == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+
+	admin := testDB(t, testURL)
+	defer admin.Close()
+
+	repositorytest.Run(t, func(t *testing.T) (repo.Repository, func()) {
+		ctx := context.Background()
+		schema := fmt.Sprintf("wish_test_%d", time.Now().UnixNano())
+
+		if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+			t.Fatalf("Failed to create schema %s: %s", schema, err)
+		}
+
+		db := testDB(t, withSearchPath(t, testURL, schema))
+		if err := Migrate(ctx, db); err != nil {
+			t.Fatalf("Migrate failed: %s", err)
+		}
+
+		return NewRepository(db, repo.DefaultTimeouts), func() {
+			db.Close()
+			if _, err := admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+				t.Errorf("Failed to drop schema %s: %s", schema, err)
+			}
+		}
+	})
+}
+
+func testDB(t *testing.T, dsn string) *sql.DB {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Postgres not available: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		t.Fatalf("Postgres not available: %s", err)
+	}
+
+	return db
+}
+
+// withSearchPath points the unqualified table names of every pooled connection to the schema
+func withSearchPath(t *testing.T, dsn string, schema string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("Invalid POSTGRES_TEST_URL: %s", err)
+	}
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
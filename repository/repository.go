@@ -1,18 +1,78 @@
 package repository
 
-import "github.com/pejovski/wish-list/model"
+import (
+	"context"
+	"time"
 
+	"github.com/pejovski/wish-list/model"
+)
+
+// Repository stores the wish lists.
+// The writes taking an event store it in the outbox together with the change, a nil event stores none.
 type Repository interface {
-	Product(productId string) (*model.Product, error)
-	UpdateProduct(product *model.Product) error
-	DeactivateProduct(productId string) error
-	DeleteProduct(productId string) error
-	UpdateProductPrice(productId string, price float32) error
-
-	Item(userId string, productId string) (*model.Item, error)
-	CreateItem(userId string, productId string) error
-	DeleteItem(userId string, productId string) error
-	UpdateItem(userId string, product *model.Product) error
-
-	List(userId string) (model.List, error)
+	Product(ctx context.Context, productId string) (*model.Product, error)
+	UpdateProduct(ctx context.Context, product *model.Product) error
+	DeactivateProduct(ctx context.Context, productId string) error
+	ActivateProduct(ctx context.Context, productId string) error
+	DeleteProduct(ctx context.Context, productId string) error
+	// UpdateProductPrice applies the price set at the given time,
+	// it returns true without applying it when the stored price was set later.
+	// UpdateProductPrice and UpdateProduct store nothing for a product no list has,
+	// a changed price is appended to the price history in the same write.
+	UpdateProductPrice(ctx context.Context, productId string, price float32, at time.Time) (bool, error)
+
+	PriceHistory(ctx context.Context, productId string) ([]*model.PricePoint, error)
+
+	Lists(ctx context.Context, userId string) ([]*model.List, error)
+	List(ctx context.Context, userId string, listId string) (*model.List, error)
+	// DefaultList returns the user's default list, nil if the user has none yet
+	DefaultList(ctx context.Context, userId string) (*model.List, error)
+	// EnsureDefaultList returns the user's default list, creating it on first access
+	EnsureDefaultList(ctx context.Context, userId string) (*model.List, error)
+	CreateList(ctx context.Context, userId string, name string) (*model.List, error)
+	RenameList(ctx context.Context, userId string, listId string, name string) error
+	DeleteList(ctx context.Context, userId string, listId string) error
+
+	Item(ctx context.Context, listId string, productId string) (*model.Item, error)
+	// CreateItem inserts the item only if the list doesn't have the product yet, atomically,
+	// otherwise it returns error.ErrItemAlreadyExist
+	CreateItem(ctx context.Context, userId string, listId string, productId string) error
+	DeleteItem(ctx context.Context, listId string, productId string, event *model.Event) error
+	UpdateItem(ctx context.Context, listId string, product *model.Product, event *model.Event) error
+	UpdateItemDetails(ctx context.Context, listId string, productId string, details *model.ItemDetails) error
+
+	// Items returns a page of the list items and the cursor of the next page
+	Items(ctx context.Context, listId string, query *model.ItemQuery) (model.Items, string, error)
+
+	ReserveItem(ctx context.Context, listId string, productId string, claimId string, expiresAt time.Time) (bool, error)
+	ReleaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error)
+	PurchaseItem(ctx context.Context, listId string, productId string, claimId string) (bool, error)
+
+	SetAlertRule(ctx context.Context, listId string, productId string, rule *model.AlertRule) error
+	PriceWatches(ctx context.Context, productId string) ([]*model.PriceWatch, error)
+	// CreateAlert stores the alert with the id given by the caller, the event refers to it
+	CreateAlert(ctx context.Context, alert *model.PriceAlert, event *model.Event) error
+	Alerts(ctx context.Context, userId string) ([]*model.PriceAlert, error)
+
+	Share(ctx context.Context, token string) (*model.Share, error)
+	Shares(ctx context.Context, listId string) ([]*model.Share, error)
+	CreateShare(ctx context.Context, share *model.Share, event *model.Event) error
+	DeleteShare(ctx context.Context, listId string, token string) error
+
+	// EventProcessed tells if the event was marked processed and the mark is not expired
+	EventProcessed(ctx context.Context, eventId string) (bool, error)
+	MarkEventProcessed(ctx context.Context, eventId string, expiresAt time.Time) error
+
+	// EraseUser deletes the lists of the user with their items, reservations and shares, the user's alerts,
+	// the user's pending outbox events and the price history of the products no other list has.
+	// It counts what it deleted into the erasure and stores the erasure as the audit record, atomically.
+	EraseUser(ctx context.Context, erasure *model.Erasure) error
+	// Erasures returns the audit records of the user, the newest first
+	Erasures(ctx context.Context, userId string) ([]*model.Erasure, error)
+
+	// PendingEvents returns up to limit outbox events in the order they occurred
+	PendingEvents(ctx context.Context, limit int) ([]*model.Event, error)
+	// MarkEventsPublished removes the published events from the outbox
+	MarkEventsPublished(ctx context.Context, events []*model.Event) error
+	OutboxStats(ctx context.Context) (*model.OutboxStats, error)
 }
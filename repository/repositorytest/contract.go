@@ -0,0 +1,1017 @@
+// Package repositorytest is the contract every repository.Repository implementation must pass.
+//
+// An implementation runs it from its own tests:
+//
+//	func TestRepository(t *testing.T) {
+//		repositorytest.Run(t, func(t *testing.T) (repository.Repository, func()) {
+//			return NewRepository(), func() {}
+//		})
+//	}
+package repositorytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	"github.com/pejovski/wish-list/repository"
+)
+
+// Factory returns an empty repository and a func which cleans it up after the test
+type Factory func(t *testing.T) (repository.Repository, func())
+
+const (
+	userId      = "user-1"
+	otherUserId = "user-2"
+)
+
+// Run runs every contract test on a fresh repository
+func Run(t *testing.T, newRepository Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, r repository.Repository)
+	}{
+		{"DuplicateItems", testDuplicateItems},
+		{"ConcurrentCreateItem", testConcurrentCreateItem},
+		{"UnenrichedItemsAreNotListed", testUnenrichedItemsAreNotListed},
+		{"ItemsPaging", testItemsPaging},
+		{"ItemsPagingEqualPrices", testItemsPagingEqualPrices},
+		{"ItemsAddedSince", testItemsAddedSince},
+		{"ProductFanOut", testProductFanOut},
+		{"ProductBackInCatalog", testProductBackInCatalog},
+		{"ProductPrice", testProductPrice},
+		{"StaleProductPrice", testStaleProductPrice},
+		{"PriceHistory", testPriceHistory},
+		{"DeleteItem", testDeleteItem},
+		{"DeleteProduct", testDeleteProduct},
+		{"DeleteList", testDeleteList},
+		{"DefaultList", testDefaultList},
+		{"Reservation", testReservation},
+		{"ExpiredReservation", testExpiredReservation},
+		{"ProcessedEvents", testProcessedEvents},
+		{"Outbox", testOutbox},
+		{"EraseUser", testEraseUser},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r, cleanup := newRepository(t)
+			defer cleanup()
+
+			tc.test(t, r)
+		})
+	}
+}
+
+// CreateItem rejects the product already in the list, the item is found in its own list only
+// and the same product may still be added to other lists
+func testDuplicateItems(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	other := createList(t, r, userId, "Christmas")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+
+	item, err := r.Item(ctx, list.Id, "p1")
+	if err != nil {
+		t.Fatalf("Item failed: %s", err)
+	}
+	if item == nil {
+		t.Fatal("Item not found after create")
+	}
+
+	item, err = r.Item(ctx, other.Id, "p1")
+	if err != nil {
+		t.Fatalf("Item failed: %s", err)
+	}
+	if item != nil {
+		t.Fatal("Item found in a list it was not added to")
+	}
+
+	// adding the product to the same list again fails
+	if err := r.CreateItem(ctx, userId, list.Id, "p1"); !errors.Is(err, myerr.ErrItemAlreadyExist) {
+		t.Fatalf("CreateItem of a duplicate returned %v, want ErrItemAlreadyExist", err)
+	}
+
+	mustCreateItem(t, r, userId, other.Id, "p1")
+
+	if got := productIds(t, r, list.Id); len(got) != 0 {
+		t.Fatalf("unenriched items listed: %v", got)
+	}
+
+	mustUpdateProduct(t, r, product("p1", 10))
+
+	assertProductIds(t, productIds(t, r, list.Id), "p1")
+	assertProductIds(t, productIds(t, r, other.Id), "p1")
+}
+
+// concurrent adds of the same product race on the insert, exactly one of them wins
+func testConcurrentCreateItem(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+
+	const adds = 8
+	errs := make(chan error, adds)
+	var wg sync.WaitGroup
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.CreateItem(ctx, userId, list.Id, "p1")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, myerr.ErrItemAlreadyExist):
+		default:
+			t.Fatalf("CreateItem failed: %s", err)
+		}
+	}
+
+	if created != 1 {
+		t.Fatalf("CreateItem succeeded %d times, want 1", created)
+	}
+}
+
+func testUnenrichedItemsAreNotListed(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustCreateItem(t, r, userId, list.Id, "p2")
+
+	if err := r.UpdateItem(ctx, list.Id, product("p2", 20), nil); err != nil {
+		t.Fatalf("UpdateItem failed: %s", err)
+	}
+
+	assertProductIds(t, productIds(t, r, list.Id), "p2")
+
+	// the unenriched item is still there, so it can be enriched or deleted later
+	item, err := r.Item(ctx, list.Id, "p1")
+	if err != nil {
+		t.Fatalf("Item failed: %s", err)
+	}
+	if item == nil {
+		t.Fatal("unenriched item not found")
+	}
+
+	if p, err := r.Product(ctx, "p1"); err != nil || p != nil {
+		t.Fatalf("Product of unenriched item = %v, %v; want nil, nil", p, err)
+	}
+
+	// a price alone doesn't enrich the item, it gets the price with the product
+	if _, err := r.UpdateProductPrice(ctx, "p1", 15, time.Now().UTC()); err != nil {
+		t.Fatalf("UpdateProductPrice failed: %s", err)
+	}
+	assertProductIds(t, productIds(t, r, list.Id), "p2")
+
+	points, err := r.PriceHistory(ctx, "p1")
+	if err != nil {
+		t.Fatalf("PriceHistory failed: %s", err)
+	}
+	if len(points) != 0 {
+		t.Fatalf("price history of an unenriched product: %+v", points)
+	}
+}
+
+func testItemsPaging(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+
+	for _, p := range []*model.Product{product("p1", 30), product("p2", 10), product("p3", 20)} {
+		mustCreateItem(t, r, userId, list.Id, p.ProductId)
+		if err := r.UpdateItem(ctx, list.Id, p, nil); err != nil {
+			t.Fatalf("UpdateItem failed: %s", err)
+		}
+	}
+
+	query := &model.ItemQuery{Sort: model.SortPrice, Limit: 2}
+	items, next, err := r.Items(ctx, list.Id, query)
+	if err != nil {
+		t.Fatalf("Items failed: %s", err)
+	}
+	assertProductIds(t, itemProductIds(items), "p2", "p3")
+	if next == "" {
+		t.Fatal("next cursor missing")
+	}
+
+	query.Cursor = next
+	items, next, err = r.Items(ctx, list.Id, query)
+	if err != nil {
+		t.Fatalf("Items failed: %s", err)
+	}
+	assertProductIds(t, itemProductIds(items), "p1")
+	if next != "" {
+		t.Fatalf("next cursor = %q on the last page", next)
+	}
+
+	items, _, err = r.Items(ctx, list.Id, &model.ItemQuery{Sort: model.SortAdded, Desc: true, Limit: 10})
+	if err != nil {
+		t.Fatalf("Items failed: %s", err)
+	}
+	assertProductIds(t, itemProductIds(items), "p3", "p2", "p1")
+}
+
+// the added since filter keeps the items added from the given time, the recent order (added, descending)
+// pages through items added within the same second in the reverse order they were added
+func testItemsAddedSince(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+
+	add := func(productId string) {
+		mustCreateItem(t, r, userId, list.Id, productId)
+		if err := r.UpdateItem(ctx, list.Id, product(productId, 10), nil); err != nil {
+			t.Fatalf("UpdateItem failed: %s", err)
+		}
+	}
+
+	add("p0")
+
+	// the mongo added time has a second precision, the items after the wait share a second
+	since := time.Now().UTC().Truncate(time.Second).Add(time.Second)
+	time.Sleep(time.Until(since))
+
+	add("p1")
+	add("p2")
+	add("p3")
+
+	items, _, err := r.Items(ctx, list.Id, &model.ItemQuery{AddedSince: &since, Sort: model.SortAdded, Limit: 10})
+	if err != nil {
+		t.Fatalf("Items failed: %s", err)
+	}
+	assertProductIds(t, itemProductIds(items), "p1", "p2", "p3")
+	for _, item := range items {
+		if item.CreatedAt.Before(since) || item.UpdatedAt.Before(item.CreatedAt) {
+			t.Errorf("item %s created at %s, updated at %s, want both from %s", item.ProductId, item.CreatedAt, item.UpdatedAt, since)
+		}
+	}
+
+	query := &model.ItemQuery{AddedSince: &since, Sort: model.SortAdded, Desc: true, Limit: 2}
+	got := []string{}
+	for {
+		items, next, err := r.Items(ctx, list.Id, query)
+		if err != nil {
+			t.Fatalf("Items failed: %s", err)
+		}
+		got = append(got, itemProductIds(items)...)
+		if next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+	assertProductIds(t, got, "p3", "p2", "p1")
+
+	// without the filter the earlier item comes last
+	items, _, err = r.Items(ctx, list.Id, &model.ItemQuery{Sort: model.SortAdded, Desc: true, Limit: 10})
+	if err != nil {
+		t.Fatalf("Items failed: %s", err)
+	}
+	assertProductIds(t, itemProductIds(items), "p3", "p2", "p1", "p0")
+}
+
+// items with the same price as the last item of a page are on the next page, 19.99 has no exact float32
+func testItemsPagingEqualPrices(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+
+	for _, p := range []*model.Product{product("p1", 19.99), product("p2", 19.99), product("p3", 19.99), product("p4", 5)} {
+		mustCreateItem(t, r, userId, list.Id, p.ProductId)
+		if err := r.UpdateItem(ctx, list.Id, p, nil); err != nil {
+			t.Fatalf("UpdateItem failed: %s", err)
+		}
+	}
+
+	for _, desc := range []bool{false, true} {
+		query := &model.ItemQuery{Sort: model.SortPrice, Desc: desc, Limit: 2}
+		got := []string{}
+		for {
+			items, next, err := r.Items(ctx, list.Id, query)
+			if err != nil {
+				t.Fatalf("Items failed: %s", err)
+			}
+			got = append(got, itemProductIds(items)...)
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+
+		if desc {
+			assertProductIds(t, got, "p3", "p2", "p1", "p4")
+		} else {
+			assertProductIds(t, got, "p4", "p1", "p2", "p3")
+		}
+	}
+}
+
+// product events update the product in every list it is in
+func testProductFanOut(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	other := createList(t, r, otherUserId, "Christmas")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustCreateItem(t, r, otherUserId, other.Id, "p1")
+	mustCreateItem(t, r, userId, list.Id, "p2")
+
+	mustUpdateProduct(t, r, product("p1", 10))
+
+	for _, listId := range []string{list.Id, other.Id} {
+		item := mustItem(t, r, listId, "p1")
+		if item.Name != "Name p1" || item.Price != 10 {
+			t.Fatalf("item of list %s not updated: %+v", listId, item.Product)
+		}
+	}
+
+	if item := mustItem(t, r, list.Id, "p2"); item.Name != "" {
+		t.Fatalf("other product updated: %+v", item.Product)
+	}
+
+	if err := r.DeactivateProduct(ctx, "p1"); err != nil {
+		t.Fatalf("DeactivateProduct failed: %s", err)
+	}
+	for _, listId := range []string{list.Id, other.Id} {
+		if mustItem(t, r, listId, "p1").Active {
+			t.Fatalf("item of list %s still active", listId)
+		}
+	}
+
+	if err := r.ActivateProduct(ctx, "p1"); err != nil {
+		t.Fatalf("ActivateProduct failed: %s", err)
+	}
+	if !mustItem(t, r, other.Id, "p1").Active {
+		t.Fatal("item not active again")
+	}
+
+	p, err := r.Product(ctx, "p1")
+	if err != nil {
+		t.Fatalf("Product failed: %s", err)
+	}
+	if p == nil || p.ProductId != "p1" || p.Price != 10 {
+		t.Fatalf("Product = %+v", p)
+	}
+}
+
+// a product deactivated because the catalog didn't find it is active again once the catalog returns it
+func testProductBackInCatalog(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	other := createList(t, r, otherUserId, "Christmas")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustUpdateProduct(t, r, product("p1", 10))
+
+	if err := r.DeactivateProduct(ctx, "p1"); err != nil {
+		t.Fatalf("DeactivateProduct failed: %s", err)
+	}
+
+	// enriching another item with the stored product data leaves the product inactive
+	mustCreateItem(t, r, otherUserId, other.Id, "p1")
+	if err := r.UpdateItem(ctx, other.Id, product("p1", 10), nil); err != nil {
+		t.Fatalf("UpdateItem failed: %s", err)
+	}
+	if mustItem(t, r, list.Id, "p1").Active {
+		t.Fatal("product active again without the catalog")
+	}
+
+	mustUpdateProduct(t, r, product("p1", 12))
+
+	for _, listId := range []string{list.Id, other.Id} {
+		item := mustItem(t, r, listId, "p1")
+		if !item.Active || item.Price != 12 {
+			t.Fatalf("item of list %s after the catalog returned the product: %+v", listId, item.Product)
+		}
+	}
+}
+
+func testProductPrice(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustUpdateProduct(t, r, product("p1", 100))
+
+	for _, price := range []float32{80, 90} {
+		if _, err := r.UpdateProductPrice(ctx, "p1", price, time.Now()); err != nil {
+			t.Fatalf("UpdateProductPrice failed: %s", err)
+		}
+	}
+
+	item := mustItem(t, r, list.Id, "p1")
+	if item.Price != 90 || item.PriceWhenAdded != 100 || item.LowestPriceSinceAdded != 80 {
+		t.Fatalf("price = %v, when added = %v, lowest = %v; want 90, 100, 80",
+			item.Price, item.PriceWhenAdded, item.LowestPriceSinceAdded)
+	}
+}
+
+// a price event delivered out of order doesn't overwrite a later price
+func testStaleProductPrice(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustUpdateProduct(t, r, product("p1", 100))
+
+	now := time.Now()
+	if stale, err := r.UpdateProductPrice(ctx, "p1", 80, now.Add(time.Minute)); err != nil || stale {
+		t.Fatalf("UpdateProductPrice = %v, %v; want applied", stale, err)
+	}
+
+	stale, err := r.UpdateProductPrice(ctx, "p1", 90, now)
+	if err != nil {
+		t.Fatalf("UpdateProductPrice failed: %s", err)
+	}
+	if !stale {
+		t.Fatal("UpdateProductPrice applied an older price")
+	}
+
+	if item := mustItem(t, r, list.Id, "p1"); item.Price != 80 {
+		t.Fatalf("price = %v; want 80", item.Price)
+	}
+}
+
+// only the applied changes of a wish-listed product's price are recorded
+func testPriceHistory(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+
+	history := func(productId string) []float32 {
+		t.Helper()
+
+		points, err := r.PriceHistory(ctx, productId)
+		if err != nil {
+			t.Fatalf("PriceHistory failed: %s", err)
+		}
+
+		prices := []float32{}
+		for _, p := range points {
+			prices = append(prices, p.Price)
+		}
+		return prices
+	}
+
+	// the catalog events of products no list has leave no history
+	mustUpdateProduct(t, r, product("p9", 100))
+	if _, err := r.UpdateProductPrice(ctx, "p9", 90, time.Now()); err != nil {
+		t.Fatalf("UpdateProductPrice failed: %s", err)
+	}
+	if got := history("p9"); len(got) != 0 {
+		t.Fatalf("history of a product no list has = %v; want none", got)
+	}
+
+	list := createList(t, r, userId, "Birthday")
+	mustCreateItem(t, r, userId, list.Id, "p1")
+
+	now := time.Now()
+	mustUpdateProduct(t, r, product("p1", 100))
+	mustUpdateProduct(t, r, product("p1", 100))
+	if _, err := r.UpdateProductPrice(ctx, "p1", 80, now.Add(time.Minute)); err != nil {
+		t.Fatalf("UpdateProductPrice failed: %s", err)
+	}
+	if _, err := r.UpdateProductPrice(ctx, "p1", 80, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("UpdateProductPrice failed: %s", err)
+	}
+
+	// a stale price is not applied, so it isn't recorded either
+	if stale, err := r.UpdateProductPrice(ctx, "p1", 90, now); err != nil || !stale {
+		t.Fatalf("UpdateProductPrice = %v, %v; want stale", stale, err)
+	}
+
+	got := history("p1")
+	if len(got) != 2 || got[0] != 100 || got[1] != 80 {
+		t.Fatalf("history = %v; want [100 80]", got)
+	}
+}
+
+func testDeleteItem(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	other := createList(t, r, userId, "Christmas")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustCreateItem(t, r, userId, other.Id, "p1")
+
+	if err := r.DeleteItem(ctx, list.Id, "p1", nil); err != nil {
+		t.Fatalf("DeleteItem failed: %s", err)
+	}
+
+	if item, _ := r.Item(ctx, list.Id, "p1"); item != nil {
+		t.Fatal("item not deleted")
+	}
+	mustItem(t, r, other.Id, "p1")
+
+	// deleting a missing item is not an error
+	if err := r.DeleteItem(ctx, list.Id, "p1", nil); err != nil {
+		t.Fatalf("DeleteItem of missing item failed: %s", err)
+	}
+}
+
+func testDeleteProduct(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	other := createList(t, r, otherUserId, "Christmas")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustCreateItem(t, r, otherUserId, other.Id, "p1")
+	mustCreateItem(t, r, userId, list.Id, "p2")
+
+	if err := r.DeleteProduct(ctx, "p1"); err != nil {
+		t.Fatalf("DeleteProduct failed: %s", err)
+	}
+
+	for _, listId := range []string{list.Id, other.Id} {
+		if item, _ := r.Item(ctx, listId, "p1"); item != nil {
+			t.Fatalf("product not deleted from list %s", listId)
+		}
+	}
+	mustItem(t, r, list.Id, "p2")
+}
+
+func testDeleteList(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	other := createList(t, r, userId, "Christmas")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustCreateItem(t, r, userId, other.Id, "p1")
+
+	share := &model.Share{Token: "token-1", UserId: userId, ListId: list.Id, CreatedAt: time.Now().UTC()}
+	if err := r.CreateShare(ctx, share, nil); err != nil {
+		t.Fatalf("CreateShare failed: %s", err)
+	}
+
+	// only the owner can delete the list
+	if err := r.DeleteList(ctx, otherUserId, list.Id); err != nil {
+		t.Fatalf("DeleteList failed: %s", err)
+	}
+	if l, _ := r.List(ctx, userId, list.Id); l == nil {
+		t.Fatal("list deleted by other user")
+	}
+
+	if err := r.DeleteList(ctx, userId, list.Id); err != nil {
+		t.Fatalf("DeleteList failed: %s", err)
+	}
+
+	if l, _ := r.List(ctx, userId, list.Id); l != nil {
+		t.Fatal("list not deleted")
+	}
+	if item, _ := r.Item(ctx, list.Id, "p1"); item != nil {
+		t.Fatal("items of deleted list not deleted")
+	}
+	if s, _ := r.Share(ctx, share.Token); s != nil {
+		t.Fatal("shares of deleted list not deleted")
+	}
+	mustItem(t, r, other.Id, "p1")
+}
+
+// reading the default list never creates it, ensuring it creates it once
+func testDefaultList(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+
+	list, err := r.DefaultList(ctx, userId)
+	if err != nil {
+		t.Fatalf("DefaultList failed: %s", err)
+	}
+	if list != nil {
+		t.Fatal("DefaultList found a list which was never created")
+	}
+	if lists, _ := r.Lists(ctx, userId); len(lists) != 0 {
+		t.Fatalf("DefaultList stored a list: %v", lists)
+	}
+
+	created, err := r.EnsureDefaultList(ctx, userId)
+	if err != nil {
+		t.Fatalf("EnsureDefaultList failed: %s", err)
+	}
+	if created == nil || !created.Default {
+		t.Fatalf("EnsureDefaultList returned %v, want a default list", created)
+	}
+
+	again, err := r.EnsureDefaultList(ctx, userId)
+	if err != nil {
+		t.Fatalf("EnsureDefaultList failed: %s", err)
+	}
+	if again.Id != created.Id {
+		t.Fatalf("EnsureDefaultList created a second default list %s, want %s", again.Id, created.Id)
+	}
+
+	list, err = r.DefaultList(ctx, userId)
+	if err != nil {
+		t.Fatalf("DefaultList failed: %s", err)
+	}
+	if list == nil || list.Id != created.Id {
+		t.Fatalf("DefaultList returned %v, want list %s", list, created.Id)
+	}
+
+	if other, _ := r.DefaultList(ctx, otherUserId); other != nil {
+		t.Fatal("DefaultList returned the list of another user")
+	}
+}
+
+func testReservation(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	mustCreateItem(t, r, userId, list.Id, "p1")
+
+	expiresAt := time.Now().Add(time.Hour)
+
+	ok, err := r.ReserveItem(ctx, list.Id, "p1", "claim-1", expiresAt)
+	if err != nil || !ok {
+		t.Fatalf("ReserveItem = %t, %v; want true, nil", ok, err)
+	}
+
+	ok, err = r.ReserveItem(ctx, list.Id, "p1", "claim-2", expiresAt)
+	if err != nil || ok {
+		t.Fatalf("ReserveItem of reserved item = %t, %v; want false, nil", ok, err)
+	}
+
+	ok, err = r.PurchaseItem(ctx, list.Id, "p1", "claim-2")
+	if err != nil || ok {
+		t.Fatalf("PurchaseItem with other claim = %t, %v; want false, nil", ok, err)
+	}
+
+	ok, err = r.PurchaseItem(ctx, list.Id, "p1", "claim-1")
+	if err != nil || !ok {
+		t.Fatalf("PurchaseItem = %t, %v; want true, nil", ok, err)
+	}
+
+	reservation := mustItem(t, r, list.Id, "p1").Reservation
+	if reservation == nil || reservation.Status != model.ReservationStatusPurchased {
+		t.Fatalf("Reservation = %+v; want purchased", reservation)
+	}
+}
+
+// an expired reservation returns the item to the pool, its claim is no longer valid
+func testExpiredReservation(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	mustCreateItem(t, r, userId, list.Id, "p1")
+
+	ok, err := r.ReserveItem(ctx, list.Id, "p1", "claim-1", time.Now().Add(-time.Second))
+	if err != nil || !ok {
+		t.Fatalf("ReserveItem = %t, %v; want true, nil", ok, err)
+	}
+
+	ok, err = r.ReserveItem(ctx, list.Id, "p1", "claim-2", time.Now().Add(time.Hour))
+	if err != nil || !ok {
+		t.Fatalf("ReserveItem of expired reservation = %t, %v; want true, nil", ok, err)
+	}
+
+	ok, err = r.PurchaseItem(ctx, list.Id, "p1", "claim-1")
+	if err != nil || ok {
+		t.Fatalf("PurchaseItem with expired claim = %t, %v; want false, nil", ok, err)
+	}
+
+	ok, err = r.PurchaseItem(ctx, list.Id, "p1", "claim-2")
+	if err != nil || !ok {
+		t.Fatalf("PurchaseItem = %t, %v; want true, nil", ok, err)
+	}
+}
+
+func testProcessedEvents(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+
+	processed := func(id string) bool {
+		ok, err := r.EventProcessed(ctx, id)
+		if err != nil {
+			t.Fatalf("EventProcessed failed: %s", err)
+		}
+		return ok
+	}
+
+	if processed("e1") {
+		t.Fatal("unknown event processed")
+	}
+
+	if err := r.MarkEventProcessed(ctx, "e1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("MarkEventProcessed failed: %s", err)
+	}
+	if err := r.MarkEventProcessed(ctx, "e2", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("MarkEventProcessed failed: %s", err)
+	}
+
+	if !processed("e1") {
+		t.Fatal("marked event not processed")
+	}
+	if processed("e2") {
+		t.Fatal("expired event processed")
+	}
+}
+
+// the events are stored with the writes and wait in the outbox in the order they occurred until they are published
+func testOutbox(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	list := createList(t, r, userId, "Birthday")
+	mustCreateItem(t, r, userId, list.Id, "p1")
+
+	now := time.Now().UTC()
+	added := event("e1", now.Add(-3*time.Second))
+	shared := event("e2", now.Add(-2*time.Second))
+	removed := event("e3", now.Add(-time.Second))
+
+	if err := r.UpdateItem(ctx, list.Id, product("p1", 10), added); err != nil {
+		t.Fatalf("UpdateItem failed: %s", err)
+	}
+	share := &model.Share{Token: "token-1", UserId: userId, ListId: list.Id, CreatedAt: now}
+	if err := r.CreateShare(ctx, share, shared); err != nil {
+		t.Fatalf("CreateShare failed: %s", err)
+	}
+	if err := r.DeleteItem(ctx, list.Id, "p1", removed); err != nil {
+		t.Fatalf("DeleteItem failed: %s", err)
+	}
+	// deleting a missing item removes nothing and stores no event
+	if err := r.DeleteItem(ctx, list.Id, "p1", event("e4", now)); err != nil {
+		t.Fatalf("DeleteItem failed: %s", err)
+	}
+	// a write without an event stores none
+	if err := r.DeleteItem(ctx, list.Id, "p2", nil); err != nil {
+		t.Fatalf("DeleteItem failed: %s", err)
+	}
+
+	stats, err := r.OutboxStats(ctx)
+	if err != nil {
+		t.Fatalf("OutboxStats failed: %s", err)
+	}
+	if stats.Pending != 3 || !stats.Oldest.Equal(added.OccurredAt) {
+		t.Fatalf("got %d pending since %s, want 3 since %s", stats.Pending, stats.Oldest, added.OccurredAt)
+	}
+
+	events, err := r.PendingEvents(ctx, 2)
+	if err != nil {
+		t.Fatalf("PendingEvents failed: %s", err)
+	}
+	assertEventIds(t, events, "e1", "e2")
+	if string(events[0].Data) != string(added.Data) || events[0].Type != added.Type || events[0].Version != added.Version {
+		t.Fatalf("got event %+v, want %+v", events[0], added)
+	}
+
+	if err := r.MarkEventsPublished(ctx, events); err != nil {
+		t.Fatalf("MarkEventsPublished failed: %s", err)
+	}
+
+	events, err = r.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("PendingEvents failed: %s", err)
+	}
+	assertEventIds(t, events, "e3")
+
+	if err := r.MarkEventsPublished(ctx, events); err != nil {
+		t.Fatalf("MarkEventsPublished failed: %s", err)
+	}
+
+	stats, err = r.OutboxStats(ctx)
+	if err != nil {
+		t.Fatalf("OutboxStats failed: %s", err)
+	}
+	if stats.Pending != 0 || !stats.Oldest.IsZero() {
+		t.Fatalf("got %d pending since %s, want none", stats.Pending, stats.Oldest)
+	}
+}
+
+// the erasure deletes the data of the user only and records what it deleted
+func testEraseUser(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	list := createList(t, r, userId, "Birthday")
+	other := createList(t, r, userId, "Christmas")
+	kept := createList(t, r, otherUserId, "Birthday")
+
+	mustCreateItem(t, r, userId, list.Id, "p1")
+	mustCreateItem(t, r, userId, list.Id, "p2")
+	mustCreateItem(t, r, userId, other.Id, "p1")
+	mustCreateItem(t, r, otherUserId, kept.Id, "p1")
+
+	if ok, err := r.ReserveItem(ctx, list.Id, "p1", "claim-1", now.Add(time.Hour)); err != nil || !ok {
+		t.Fatalf("ReserveItem = %t, %v; want true, nil", ok, err)
+	}
+
+	// the shares leave pending events about their users in the outbox
+	for _, s := range []*model.Share{
+		{Token: "token-1", UserId: userId, ListId: list.Id, CreatedAt: now},
+		{Token: "token-2", UserId: otherUserId, ListId: kept.Id, CreatedAt: now},
+	} {
+		if err := r.CreateShare(ctx, s, userEvent("event-"+s.Token, s.UserId, now)); err != nil {
+			t.Fatalf("CreateShare failed: %s", err)
+		}
+	}
+
+	// p2 is on the lists of the erased user only, p1 is on a list of the other user too
+	mustUpdateProduct(t, r, product("p1", 100))
+	mustUpdateProduct(t, r, product("p2", 100))
+
+	for i, u := range []string{userId, userId, otherUserId} {
+		alert := &model.PriceAlert{
+			Id:        fmt.Sprintf("alert-%d", i),
+			UserId:    u,
+			ListId:    list.Id,
+			ProductId: "p1",
+			CreatedAt: now,
+		}
+		if err := r.CreateAlert(ctx, alert, nil); err != nil {
+			t.Fatalf("CreateAlert failed: %s", err)
+		}
+	}
+
+	erasure := &model.Erasure{Id: "erasure-1", UserId: userId, Source: model.ErasureSourceAdmin, ErasedAt: now}
+	if err := r.EraseUser(ctx, erasure); err != nil {
+		t.Fatalf("EraseUser failed: %s", err)
+	}
+
+	want := model.Erasure{
+		Id:           "erasure-1",
+		UserId:       userId,
+		Source:       model.ErasureSourceAdmin,
+		Lists:        2,
+		Items:        3,
+		Reservations: 1,
+		Shares:       1,
+		Alerts:       2,
+		PricePoints:  1,
+		Events:       1,
+		ErasedAt:     now,
+	}
+	if *erasure != want {
+		t.Fatalf("got erasure %+v, want %+v", *erasure, want)
+	}
+
+	lists, err := r.Lists(ctx, userId)
+	if err != nil {
+		t.Fatalf("Lists failed: %s", err)
+	}
+	if len(lists) != 0 {
+		t.Fatalf("got %d lists of erased user, want 0", len(lists))
+	}
+	if item, _ := r.Item(ctx, list.Id, "p1"); item != nil {
+		t.Fatal("items of erased user not deleted")
+	}
+	if s, _ := r.Share(ctx, "token-1"); s != nil {
+		t.Fatal("shares of erased user not deleted")
+	}
+	if alerts, _ := r.Alerts(ctx, userId); len(alerts) != 0 {
+		t.Fatalf("got %d alerts of erased user, want 0", len(alerts))
+	}
+
+	mustItem(t, r, kept.Id, "p1")
+	if s, _ := r.Share(ctx, "token-2"); s == nil {
+		t.Fatal("share of other user deleted")
+	}
+	if alerts, _ := r.Alerts(ctx, otherUserId); len(alerts) != 1 {
+		t.Fatalf("got %d alerts of other user, want 1", len(alerts))
+	}
+
+	if points, _ := r.PriceHistory(ctx, "p2"); len(points) != 0 {
+		t.Fatalf("got %d price points of a product only the erased user had, want 0", len(points))
+	}
+	if points, _ := r.PriceHistory(ctx, "p1"); len(points) != 1 {
+		t.Fatalf("got %d price points of a product the other user has, want 1", len(points))
+	}
+
+	pending, err := r.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("PendingEvents failed: %s", err)
+	}
+	assertEventIds(t, pending, "event-token-2")
+
+	erasures, err := r.Erasures(ctx, userId)
+	if err != nil {
+		t.Fatalf("Erasures failed: %s", err)
+	}
+	if len(erasures) != 1 || erasures[0].Id != want.Id || erasures[0].Items != want.Items || !erasures[0].ErasedAt.Equal(now) {
+		t.Fatalf("got erasures %+v, want the erasure", erasures)
+	}
+}
+
+func createList(t *testing.T, r repository.Repository, userId string, name string) *model.List {
+	t.Helper()
+
+	list, err := r.CreateList(context.Background(), userId, name)
+	if err != nil {
+		t.Fatalf("CreateList failed: %s", err)
+	}
+
+	return list
+}
+
+func mustCreateItem(t *testing.T, r repository.Repository, userId string, listId string, productId string) {
+	t.Helper()
+
+	if err := r.CreateItem(context.Background(), userId, listId, productId); err != nil {
+		t.Fatalf("CreateItem failed: %s", err)
+	}
+}
+
+func mustUpdateProduct(t *testing.T, r repository.Repository, p *model.Product) {
+	t.Helper()
+
+	if err := r.UpdateProduct(context.Background(), p); err != nil {
+		t.Fatalf("UpdateProduct failed: %s", err)
+	}
+}
+
+func mustItem(t *testing.T, r repository.Repository, listId string, productId string) *model.Item {
+	t.Helper()
+
+	item, err := r.Item(context.Background(), listId, productId)
+	if err != nil {
+		t.Fatalf("Item failed: %s", err)
+	}
+	if item == nil {
+		t.Fatalf("item %s of list %s not found", productId, listId)
+	}
+
+	return item
+}
+
+func productIds(t *testing.T, r repository.Repository, listId string) []string {
+	t.Helper()
+
+	items, _, err := r.Items(context.Background(), listId, &model.ItemQuery{Sort: model.SortAdded, Limit: 100})
+	if err != nil {
+		t.Fatalf("Items failed: %s", err)
+	}
+
+	return itemProductIds(items)
+}
+
+func itemProductIds(items model.Items) []string {
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.ProductId)
+	}
+
+	return ids
+}
+
+func assertProductIds(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("product ids = %v; want %v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("product ids = %v; want %v", got, want)
+		}
+	}
+}
+
+func product(id string, price float32) *model.Product {
+	return &model.Product{
+		ProductId: id,
+		Name:      "Name " + id,
+		Brand:     "Brand",
+		Price:     price,
+		Image:     id + ".jpg",
+	}
+}
+
+// event has a whole second time, every backend stores it without losing precision
+func event(id string, at time.Time) *model.Event {
+	return &model.Event{
+		Id:         id,
+		Type:       "wish_item_added",
+		Version:    1,
+		OccurredAt: at.Truncate(time.Second),
+		Data:       json.RawMessage(`{"product_id":"p1"}`),
+	}
+}
+
+func userEvent(id string, userId string, at time.Time) *model.Event {
+	e := event(id, at)
+	e.Type = "wish_list_shared"
+	e.Data = json.RawMessage(fmt.Sprintf(`{"user_id":%q,"list_id":"l1"}`, userId))
+
+	return e
+}
+
+func assertEventIds(t *testing.T, events []*model.Event, want ...string) {
+	t.Helper()
+
+	got := []string{}
+	for _, e := range events {
+		got = append(got, e.Id)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package repository
+
+import "time"
+
+// Timeouts bound the storage calls on top of the caller's context,
+// Operation is used for single record calls and Query for the ones reading or deleting many records
+type Timeouts struct {
+	Operation time.Duration
+	Query     time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Operation: 1 * time.Second,
+	Query:     2 * time.Second,
+}
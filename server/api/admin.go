@@ -0,0 +1,41 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const bearerPrefix = "Bearer "
+
+// admin serves the operations on behalf of other users, they require the admin token as a bearer token
+func (rtr *router) admin() {
+	if rtr.adminToken == "" {
+		logrus.Warnln("ADMIN_TOKEN is not set, the admin routes are disabled")
+		return
+	}
+
+	a := rtr.router.PathPrefix("/admin").Subrouter()
+	a.Use(rtr.adminOnly)
+
+	a.HandleFunc("/users/{user_id}", rtr.handler.EraseUser()).Methods("DELETE")
+	a.HandleFunc("/users/{user_id}/erasures", rtr.handler.GetErasures()).Methods("GET")
+}
+
+func (rtr *router) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, bearerPrefix)
+
+		if !strings.HasPrefix(auth, bearerPrefix) || subtle.ConstantTimeCompare([]byte(token), []byte(rtr.adminToken)) != 1 {
+			logrus.Warnf("Unauthorized %s %s", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, http.StatusUnauthorized, "Admin token required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pejovski/wish-list/controller"
+	"github.com/pejovski/wish-list/model"
+)
+
+const adminToken = "admin-secret"
+
+// fakeController erases users, the other controller methods are not called
+type fakeController struct {
+	controller.Controller
+
+	erased []*model.Erasure
+}
+
+func (c *fakeController) EraseUser(ctx context.Context, userId string, source string) (*model.Erasure, error) {
+	e := &model.Erasure{Id: "erasure-1", UserId: userId, Source: source, Lists: 2, Items: 3}
+	c.erased = append(c.erased, e)
+	return e, nil
+}
+
+func (c *fakeController) GetErasures(ctx context.Context, userId string) ([]*model.Erasure, error) {
+	erasures := []*model.Erasure{}
+	for _, e := range c.erased {
+		if e.UserId == userId {
+			erasures = append(erasures, e)
+		}
+	}
+	return erasures, nil
+}
+
+func newAdminRouter(c controller.Controller, token string) *router {
+	rtr := &router{router: mux.NewRouter(), handler: newHandler(c), adminToken: token}
+	rtr.admin()
+	return rtr
+}
+
+func adminRequest(rtr *router, method string, path string, auth string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+
+	w := httptest.NewRecorder()
+	rtr.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	tests := []struct {
+		name string
+		auth string
+	}{
+		{"no token", ""},
+		{"wrong token", "Bearer other"},
+		{"not a bearer token", adminToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeController{}
+			w := adminRequest(newAdminRouter(c, adminToken), http.MethodDelete, "/admin/users/u1", tt.auth)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if w.Header().Get("WWW-Authenticate") != "Bearer" || w.Header().Get("Content-Type") != problemContentType {
+				t.Errorf("headers = %v, want a bearer challenge and a problem", w.Header())
+			}
+			if len(c.erased) != 0 {
+				t.Error("user erased without the admin token")
+			}
+		})
+	}
+}
+
+func TestAdminEraseUser(t *testing.T) {
+	c := &fakeController{}
+	rtr := newAdminRouter(c, adminToken)
+
+	w := adminRequest(rtr, http.MethodDelete, "/admin/users/u1", "Bearer "+adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var erasure model.Erasure
+	if err := json.NewDecoder(w.Body).Decode(&erasure); err != nil {
+		t.Fatalf("Failed to decode erasure: %s", err)
+	}
+	if erasure.UserId != "u1" || erasure.Source != model.ErasureSourceAdmin || erasure.Items != 3 {
+		t.Errorf("erasure = %+v, want the admin erasure of u1", erasure)
+	}
+
+	w = adminRequest(rtr, http.MethodGet, "/admin/users/u1/erasures", "Bearer "+adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var erasures []*model.Erasure
+	if err := json.NewDecoder(w.Body).Decode(&erasures); err != nil {
+		t.Fatalf("Failed to decode erasures: %s", err)
+	}
+	if len(erasures) != 1 || erasures[0].Id != "erasure-1" {
+		t.Errorf("erasures = %+v, want the erasure", erasures)
+	}
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	c := &fakeController{}
+	w := adminRequest(newAdminRouter(c, ""), http.MethodDelete, "/admin/users/u1", "Bearer ")
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if len(c.erased) != 0 {
+		t.Error("user erased while the admin routes are off")
+	}
+}
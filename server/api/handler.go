@@ -1,27 +1,66 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/gorilla/mux"
 	"github.com/pejovski/wish-list/controller"
 	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	nextCursorHeader     = "X-Next-Cursor"
+	preferRepresentation = "return=representation"
+
+	// addItemSyncTimeout bounds the wait for the catalog, so the problem response is written before WriteTimeout
+	addItemSyncTimeout = WriteTimeout - time.Second
 )
 
 type Handler interface {
 	GetList() http.HandlerFunc
 	AddItem() http.HandlerFunc
 	RemoveItem() http.HandlerFunc
+	UpdateItemDetails() http.HandlerFunc
+
+	GetLists() http.HandlerFunc
+	CreateList() http.HandlerFunc
+	RenameList() http.HandlerFunc
+	DeleteList() http.HandlerFunc
+
+	ShareList() http.HandlerFunc
+	GetShares() http.HandlerFunc
+	RevokeShare() http.HandlerFunc
+	GetSharedList() http.HandlerFunc
+
+	ReserveItem() http.HandlerFunc
+	ReleaseItem() http.HandlerFunc
+	PurchaseItem() http.HandlerFunc
+
+	SetAlertRule() http.HandlerFunc
+	RemoveAlertRule() http.HandlerFunc
+	GetAlerts() http.HandlerFunc
+
+	GetPriceHistory() http.HandlerFunc
+
+	EraseUser() http.HandlerFunc
+	GetErasures() http.HandlerFunc
 }
 
 type handler struct {
-	controller controller.Controller
+	controller  controller.Controller
+	syncTimeout time.Duration
 }
 
 func newHandler(c controller.Controller) Handler {
 	s := handler{
-		controller: c,
+		controller:  c,
+		syncTimeout: addItemSyncTimeout,
 	}
 
 	return s
@@ -34,14 +73,35 @@ func (h handler) GetList() http.HandlerFunc {
 		userId := params["user_id"]
 		if userId == "" {
 			logrus.Warnln("User id not found")
-			http.Error(w, "User id not found", http.StatusBadRequest)
+			h.problem(w, r, http.StatusBadRequest, "User id not found")
 			return
 		}
 
-		list, err := h.controller.GetList(userId)
+		query, err := h.itemQuery(r)
 		if err != nil {
-			logrus.Errorf("Failed to get wish list for user %s. Error: %s", userId, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			h.fail(w, r, err)
+			return
+		}
+
+		// reading the default list doesn't create it
+		var list *model.List
+		if listId := params["list_id"]; listId != "" {
+			list, err = h.controller.GetList(r.Context(), userId, listId, query)
+		} else {
+			list, err = h.controller.GetDefaultList(r.Context(), userId, query)
+		}
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		if list.NextCursor != "" {
+			w.Header().Set(nextCursorHeader, list.NextCursor)
+		}
+
+		// the list-less route keeps responding with the plain items of the default list
+		if params["list_id"] == "" {
+			h.respond(w, r, list.Items, http.StatusOK)
 			return
 		}
 
@@ -62,32 +122,47 @@ func (h handler) AddItem() http.HandlerFunc {
 
 		if userId == "" {
 			logrus.Warnln("User id not found")
-			http.Error(w, "User id not found", http.StatusBadRequest)
+			h.problem(w, r, http.StatusBadRequest, "User id not found")
 			return
 		}
 
 		var req request
 		err := h.decode(w, r, &req)
 		if err != nil {
-			logrus.Errorf("Failed to decode request. Error: %s", err)
-			http.Error(w, "Request body incorrect", http.StatusBadRequest)
+			h.fail(w, r, myerr.Wrap(myerr.ErrInvalidRequest, err))
 			return
 		}
 
 		if req.ProductId == "" {
 			logrus.Warnln("Product id not found")
-			http.Error(w, "Product id not found", http.StatusBadRequest)
+			h.problem(w, r, http.StatusBadRequest, "Product id not found")
 			return
 		}
 
-		err = h.controller.AddItem(userId, req.ProductId)
+		listId, err := h.listId(r.Context(), userId, params)
 		if err != nil {
-			if err == myerr.ErrItemAlreadyExist {
-				http.Error(w, "Item already added", http.StatusMethodNotAllowed)
+			h.fail(w, r, err)
+			return
+		}
+
+		if h.wait(r) {
+			ctx, cancel := context.WithTimeout(r.Context(), h.syncTimeout)
+			defer cancel()
+
+			item, err := h.controller.AddItemSync(ctx, userId, listId, req.ProductId)
+			if err != nil {
+				h.fail(w, r, err)
 				return
 			}
-			logrus.Errorf("Failed to add product %s for user %s. Error: %s", req.ProductId, userId, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+			w.Header().Set("Preference-Applied", preferRepresentation)
+			h.respond(w, r, item, http.StatusCreated)
+			return
+		}
+
+		err = h.controller.AddItem(r.Context(), userId, listId, req.ProductId)
+		if err != nil {
+			h.fail(w, r, err)
 			return
 		}
 
@@ -104,14 +179,70 @@ func (h handler) RemoveItem() http.HandlerFunc {
 
 		if userId == "" || productId == "" {
 			logrus.Warnln("User or product id not found")
-			http.Error(w, "User or product id not found", http.StatusBadRequest)
+			h.problem(w, r, http.StatusBadRequest, "User or product id not found")
 			return
 		}
 
-		err := h.controller.RemoveItem(userId, productId)
+		listId, err := h.listId(r.Context(), userId, params)
 		if err != nil {
-			logrus.Errorf("Failed to remove product %s for user %s. Error: %s", productId, userId, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			h.fail(w, r, err)
+			return
+		}
+
+		err = h.controller.RemoveItem(r.Context(), userId, listId, productId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) UpdateItemDetails() http.HandlerFunc {
+
+	type request struct {
+		Quantity *int              `json:"quantity"`
+		Priority *string           `json:"priority"`
+		Note     *string           `json:"note"`
+		Variant  map[string]string `json:"variant"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		productId := params["product_id"]
+
+		if userId == "" || productId == "" {
+			logrus.Warnln("User or product id not found")
+			h.problem(w, r, http.StatusBadRequest, "User or product id not found")
+			return
+		}
+
+		var req request
+		err := h.decode(w, r, &req)
+		if err != nil {
+			h.fail(w, r, myerr.Wrap(myerr.ErrInvalidRequest, err))
+			return
+		}
+
+		listId, err := h.listId(r.Context(), userId, params)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		details := &model.ItemDetails{
+			Quantity: req.Quantity,
+			Priority: req.Priority,
+			Note:     req.Note,
+			Variant:  req.Variant,
+		}
+
+		err = h.controller.UpdateItemDetails(r.Context(), userId, listId, productId, details)
+		if err != nil {
+			h.fail(w, r, err)
 			return
 		}
 
@@ -119,6 +250,568 @@ func (h handler) RemoveItem() http.HandlerFunc {
 	}
 }
 
+func (h handler) GetLists() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		if userId == "" {
+			logrus.Warnln("User id not found")
+			h.problem(w, r, http.StatusBadRequest, "User id not found")
+			return
+		}
+
+		lists, err := h.controller.GetLists(r.Context(), userId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, lists, http.StatusOK)
+	}
+}
+
+func (h handler) CreateList() http.HandlerFunc {
+
+	type request struct {
+		Name string `json:"name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		if userId == "" {
+			logrus.Warnln("User id not found")
+			h.problem(w, r, http.StatusBadRequest, "User id not found")
+			return
+		}
+
+		var req request
+		err := h.decode(w, r, &req)
+		if err != nil {
+			h.fail(w, r, myerr.Wrap(myerr.ErrInvalidRequest, err))
+			return
+		}
+
+		if req.Name == "" {
+			logrus.Warnln("List name not found")
+			h.problem(w, r, http.StatusBadRequest, "List name not found")
+			return
+		}
+
+		list, err := h.controller.CreateList(r.Context(), userId, req.Name)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, list, http.StatusCreated)
+	}
+}
+
+func (h handler) RenameList() http.HandlerFunc {
+
+	type request struct {
+		Name string `json:"name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		listId := params["list_id"]
+
+		if userId == "" || listId == "" {
+			logrus.Warnln("User or list id not found")
+			h.problem(w, r, http.StatusBadRequest, "User or list id not found")
+			return
+		}
+
+		var req request
+		err := h.decode(w, r, &req)
+		if err != nil {
+			h.fail(w, r, myerr.Wrap(myerr.ErrInvalidRequest, err))
+			return
+		}
+
+		if req.Name == "" {
+			logrus.Warnln("List name not found")
+			h.problem(w, r, http.StatusBadRequest, "List name not found")
+			return
+		}
+
+		err = h.controller.RenameList(r.Context(), userId, listId, req.Name)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) DeleteList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		listId := params["list_id"]
+
+		if userId == "" || listId == "" {
+			logrus.Warnln("User or list id not found")
+			h.problem(w, r, http.StatusBadRequest, "User or list id not found")
+			return
+		}
+
+		err := h.controller.DeleteList(r.Context(), userId, listId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) ShareList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		listId := params["list_id"]
+
+		if userId == "" || listId == "" {
+			logrus.Warnln("User or list id not found")
+			h.problem(w, r, http.StatusBadRequest, "User or list id not found")
+			return
+		}
+
+		share, err := h.controller.ShareList(r.Context(), userId, listId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, share, http.StatusCreated)
+	}
+}
+
+func (h handler) GetShares() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		listId := params["list_id"]
+
+		if userId == "" || listId == "" {
+			logrus.Warnln("User or list id not found")
+			h.problem(w, r, http.StatusBadRequest, "User or list id not found")
+			return
+		}
+
+		shares, err := h.controller.GetShares(r.Context(), userId, listId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, shares, http.StatusOK)
+	}
+}
+
+func (h handler) RevokeShare() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		listId := params["list_id"]
+		token := params["token"]
+
+		if userId == "" || listId == "" || token == "" {
+			logrus.Warnln("User, list id or token not found")
+			h.problem(w, r, http.StatusBadRequest, "User, list id or token not found")
+			return
+		}
+
+		err := h.controller.RevokeShare(r.Context(), userId, listId, token)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) GetSharedList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		token := params["token"]
+		if token == "" {
+			logrus.Warnln("Token not found")
+			h.problem(w, r, http.StatusBadRequest, "Token not found")
+			return
+		}
+
+		query, err := h.itemQuery(r)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		list, err := h.controller.GetSharedList(r.Context(), token, query)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, list, http.StatusOK)
+	}
+}
+
+func (h handler) ReserveItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		token := params["token"]
+		productId := params["product_id"]
+
+		if token == "" || productId == "" {
+			logrus.Warnln("Token or product id not found")
+			h.problem(w, r, http.StatusBadRequest, "Token or product id not found")
+			return
+		}
+
+		claim, err := h.controller.ReserveItem(r.Context(), token, productId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, claim, http.StatusCreated)
+	}
+}
+
+func (h handler) ReleaseItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		token := params["token"]
+		productId := params["product_id"]
+		claimId := params["claim_id"]
+
+		if token == "" || productId == "" || claimId == "" {
+			logrus.Warnln("Token, product or claim id not found")
+			h.problem(w, r, http.StatusBadRequest, "Token, product or claim id not found")
+			return
+		}
+
+		err := h.controller.ReleaseItem(r.Context(), token, productId, claimId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) PurchaseItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		token := params["token"]
+		productId := params["product_id"]
+		claimId := params["claim_id"]
+
+		if token == "" || productId == "" || claimId == "" {
+			logrus.Warnln("Token, product or claim id not found")
+			h.problem(w, r, http.StatusBadRequest, "Token, product or claim id not found")
+			return
+		}
+
+		err := h.controller.PurchaseItem(r.Context(), token, productId, claimId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) SetAlertRule() http.HandlerFunc {
+
+	type request struct {
+		TargetPrice    float32 `json:"target_price"`
+		DropPercentage float32 `json:"drop_percentage"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		productId := params["product_id"]
+
+		if userId == "" || productId == "" {
+			logrus.Warnln("User or product id not found")
+			h.problem(w, r, http.StatusBadRequest, "User or product id not found")
+			return
+		}
+
+		var req request
+		err := h.decode(w, r, &req)
+		if err != nil {
+			h.fail(w, r, myerr.Wrap(myerr.ErrInvalidRequest, err))
+			return
+		}
+
+		listId, err := h.listId(r.Context(), userId, params)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		rule := &model.AlertRule{
+			TargetPrice:    req.TargetPrice,
+			DropPercentage: req.DropPercentage,
+		}
+
+		err = h.controller.SetAlertRule(r.Context(), userId, listId, productId, rule)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) RemoveAlertRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		productId := params["product_id"]
+
+		if userId == "" || productId == "" {
+			logrus.Warnln("User or product id not found")
+			h.problem(w, r, http.StatusBadRequest, "User or product id not found")
+			return
+		}
+
+		listId, err := h.listId(r.Context(), userId, params)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		err = h.controller.SetAlertRule(r.Context(), userId, listId, productId, nil)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, nil, http.StatusNoContent)
+	}
+}
+
+func (h handler) GetAlerts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		if userId == "" {
+			logrus.Warnln("User id not found")
+			h.problem(w, r, http.StatusBadRequest, "User id not found")
+			return
+		}
+
+		alerts, err := h.controller.GetAlerts(r.Context(), userId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, alerts, http.StatusOK)
+	}
+}
+
+func (h handler) GetPriceHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		productId := params["product_id"]
+		if productId == "" {
+			logrus.Warnln("Product id not found")
+			h.problem(w, r, http.StatusBadRequest, "Product id not found")
+			return
+		}
+
+		points, err := h.controller.GetPriceHistory(r.Context(), productId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, points, http.StatusOK)
+	}
+}
+
+// wait reports if the client asked for the enriched item instead of an accepted response
+// either with ?wait=true or with the Prefer: return=representation header
+func (h handler) wait(r *http.Request) bool {
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+		return true
+	}
+
+	for _, prefer := range r.Header["Prefer"] {
+		for _, p := range strings.Split(prefer, ",") {
+			if strings.TrimSpace(p) == preferRepresentation {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// itemQuery reads the item filters, sort and page from the query parameters, the parse errors are invalid queries
+func (h handler) itemQuery(r *http.Request) (*model.ItemQuery, error) {
+	values := r.URL.Query()
+
+	query := &model.ItemQuery{
+		Brand:  values.Get("brand"),
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
+	}
+
+	var err error
+	if query.Active, err = h.parseBool(values.Get("active")); err != nil {
+		return nil, myerr.Wrap(myerr.ErrInvalidQuery, err)
+	}
+	if query.Reserved, err = h.parseBool(values.Get("reserved")); err != nil {
+		return nil, myerr.Wrap(myerr.ErrInvalidQuery, err)
+	}
+	if query.MinPrice, err = h.parsePrice(values.Get("min_price")); err != nil {
+		return nil, myerr.Wrap(myerr.ErrInvalidQuery, err)
+	}
+	if query.MaxPrice, err = h.parsePrice(values.Get("max_price")); err != nil {
+		return nil, myerr.Wrap(myerr.ErrInvalidQuery, err)
+	}
+
+	if v := values.Get("added_since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, myerr.Wrap(myerr.ErrInvalidQuery, err)
+		}
+		query.AddedSince = &since
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return nil, myerr.ErrInvalidQuery
+	}
+
+	if v := values.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, myerr.Wrap(myerr.ErrInvalidQuery, err)
+		}
+	}
+
+	return query, nil
+}
+
+func (h handler) parseBool(v string) (*bool, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (h handler) parsePrice(v string) (*float32, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	price := float32(f)
+	return &price, nil
+}
+
+// listId returns the list id from the route or the user's default list id
+// for the writes that don't address a list explicitly, the default list is created on the first one
+func (h handler) listId(ctx context.Context, userId string, params map[string]string) (string, error) {
+	if listId := params["list_id"]; listId != "" {
+		return listId, nil
+	}
+
+	list, err := h.controller.EnsureDefaultList(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+
+	return list.Id, nil
+}
+
+// EraseUser erases the user like the user_deleted event and responds with the audit record
+func (h handler) EraseUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		if userId == "" {
+			logrus.Warnln("User id not found")
+			h.problem(w, r, http.StatusBadRequest, "User id not found")
+			return
+		}
+
+		erasure, err := h.controller.EraseUser(r.Context(), userId, model.ErasureSourceAdmin)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, erasure, http.StatusOK)
+	}
+}
+
+func (h handler) GetErasures() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		userId := params["user_id"]
+		if userId == "" {
+			logrus.Warnln("User id not found")
+			h.problem(w, r, http.StatusBadRequest, "User id not found")
+			return
+		}
+
+		erasures, err := h.controller.GetErasures(r.Context(), userId)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, erasures, http.StatusOK)
+	}
+}
+
 func (h handler) respond(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pejovski/wish-list/controller"
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/pejovski/wish-list/model"
+	"github.com/pejovski/wish-list/pkg/worker"
+	"github.com/pejovski/wish-list/repository/memory"
+)
+
+const userId = "user-1"
+
+// stubCatalog knows its products or fails with err, a blocking catalog answers only when the request is canceled
+type stubCatalog struct {
+	products map[string]*model.Product
+	err      error
+	block    bool
+}
+
+func (c stubCatalog) Product(ctx context.Context, id string) (*model.Product, error) {
+	if c.block {
+		<-ctx.Done()
+		return nil, myerr.Wrap(myerr.ErrCatalogUnavailable, ctx.Err())
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.products[id], nil
+}
+
+// newTestRouter serves the routes with a controller on the in-memory repository
+func newTestRouter(t *testing.T, catalog stubCatalog) (*router, func()) {
+	t.Helper()
+
+	w := worker.NewPool(worker.Config{Workers: 1, QueueSize: 10, MaxAttempts: 1})
+	c := controller.New(memory.NewRepository(), catalog, nil, w)
+
+	rtr := &router{router: mux.NewRouter(), handler: handler{controller: c, syncTimeout: 50 * time.Millisecond}}
+	rtr.routes()
+
+	return rtr, func() {
+		w.Shutdown(context.Background())
+	}
+}
+
+func request(rtr *router, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	rtr.ServeHTTP(w, r)
+	return w
+}
+
+func assertProblem(t *testing.T, w *httptest.ResponseRecorder, status int) problem {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, status, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+		t.Fatalf("Content-Type = %q, want %q", ct, problemContentType)
+	}
+
+	var p problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatalf("Failed to decode problem: %s", err)
+	}
+	if p.Status != status {
+		t.Errorf("problem status = %d, want %d", p.Status, status)
+	}
+
+	return p
+}
+
+func TestAddItemSync(t *testing.T) {
+	rtr, cleanup := newTestRouter(t, stubCatalog{products: map[string]*model.Product{
+		"p1": {ProductId: "p1", Name: "Galaxy", Price: 800},
+	}})
+	defer cleanup()
+
+	w := request(rtr, http.MethodPost, "/wish-list/"+userId+"?wait=true", `{"product_id": "p1"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	var item model.Item
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil {
+		t.Fatalf("Failed to decode item: %s", err)
+	}
+	if item.ProductId != "p1" || item.Name != "Galaxy" {
+		t.Errorf("item = %+v, want the enriched p1", item)
+	}
+}
+
+func TestAddItemSyncFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog stubCatalog
+		status  int
+	}{
+		{"unknown product", stubCatalog{}, http.StatusNotFound},
+		{"catalog down", stubCatalog{err: myerr.Wrap(myerr.ErrCatalogUnavailable, errors.New("connection refused"))}, http.StatusBadGateway},
+		{"catalog too slow", stubCatalog{block: true}, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtr, cleanup := newTestRouter(t, tt.catalog)
+			defer cleanup()
+
+			// the failed item is removed, so adding it again fails the same way instead of conflicting
+			for i := 0; i < 2; i++ {
+				start := time.Now()
+				w := request(rtr, http.MethodPost, "/wish-list/"+userId+"?wait=true", `{"product_id": "p1"}`)
+				assertProblem(t, w, tt.status)
+
+				if elapsed := time.Since(start); elapsed > time.Second {
+					t.Errorf("responded after %s, want it bounded by the sync timeout", elapsed)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateItemDetailsInvalid(t *testing.T) {
+	rtr, cleanup := newTestRouter(t, stubCatalog{products: map[string]*model.Product{
+		"p1": {ProductId: "p1", Name: "Galaxy", Price: 800},
+	}})
+	defer cleanup()
+
+	if w := request(rtr, http.MethodPost, "/wish-list/"+userId+"?wait=true", `{"product_id": "p1"}`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"zero quantity", `{"quantity": 0}`},
+		{"quantity too big", `{"quantity": 100}`},
+		{"unknown priority", `{"priority": "urgent"}`},
+		{"note too long", `{"note": "` + strings.Repeat("a", 501) + `"}`},
+		{"empty variant key", `{"variant": {"": "blue"}}`},
+		{"variant value too long", `{"variant": {"color": "` + strings.Repeat("a", 51) + `"}}`},
+		{"too many variant entries", `{"variant": {"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6", "g": "7", "h": "8", "i": "9", "j": "10", "k": "11"}}`},
+		{"malformed quantity", `{"quantity": "two"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(rtr, http.MethodPatch, "/wish-list/"+userId+"/p1", tt.body)
+			assertProblem(t, w, http.StatusBadRequest)
+		})
+	}
+
+	// a valid update still goes through
+	if w := request(rtr, http.MethodPatch, "/wish-list/"+userId+"/p1", `{"quantity": 2, "priority": "must_have"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusNoContent, w.Body)
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+const (
+	healthUp       = "up"
+	healthDown     = "down"
+	healthDegraded = "degraded"
+)
+
+// HealthCheck reports if a dependency of the service is up
+type HealthCheck struct {
+	Name string
+	Up   func() bool
+}
+
+type health struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	myerr "github.com/pejovski/wish-list/error"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	problemType        = "about:blank"
+	retryAfterSeconds  = 1
+)
+
+// problem is the RFC 7807 body of the error responses
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+var kindStatus = map[myerr.Kind]int{
+	myerr.KindNotFound:    http.StatusNotFound,
+	myerr.KindConflict:    http.StatusConflict,
+	myerr.KindValidation:  http.StatusBadRequest,
+	myerr.KindUnavailable: http.StatusBadGateway,
+	myerr.KindBusy:        http.StatusServiceUnavailable,
+}
+
+// problemDetails tell the client how to fix a validation error
+var problemDetails = []struct {
+	err    error
+	detail string
+}{
+	{myerr.ErrInvalidDetails, "Quantity must be 1-99, priority must_have or nice_to_have, note up to 500 and variant up to 10 entries of 50 characters"},
+	{myerr.ErrInvalidAlertRule, "Either target_price or drop_percentage lower than 100 must be set"},
+}
+
+// fail responds with the status of the error kind, unclassified errors are internal server errors
+func (h handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	kind := myerr.KindOf(err)
+
+	status, ok := kindStatus[kind]
+	if !ok {
+		logrus.Errorf("%s %s failed. Error: %s", r.Method, r.URL.Path, err)
+		h.problem(w, r, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if kind == myerr.KindBusy {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	}
+
+	h.problem(w, r, status, problemDetail(err))
+}
+
+// problemDetail hides the causes of the errors except of the validation errors, they tell what is invalid
+func problemDetail(err error) string {
+	for _, d := range problemDetails {
+		if errors.Is(err, d.err) {
+			return d.detail
+		}
+	}
+
+	var e *myerr.Error
+	if !errors.As(err, &e) {
+		return ""
+	}
+
+	if e.Kind == myerr.KindValidation {
+		return e.Error()
+	}
+
+	return e.Message
+}
+
+func (h handler) problem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblem(w, r, status, detail)
+}
+
+// writeProblem responds with the problem, it serves the middlewares too
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(problem{
+		Type:     problemType,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to encode problem. Error: %s", err)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	myerr "github.com/pejovski/wish-list/error"
+)
+
+func TestFail(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		detail     string
+		retryAfter string
+	}{
+		{"not found", myerr.ErrListNotFound, http.StatusNotFound, "list not found", ""},
+		{"conflict", myerr.ErrItemAlreadyExist, http.StatusConflict, "item already exist", ""},
+		{
+			"validation with detail", fmt.Errorf("details: %w", myerr.ErrInvalidAlertRule), http.StatusBadRequest,
+			"Either target_price or drop_percentage lower than 100 must be set", "",
+		},
+		{
+			"validation with cause", myerr.Wrap(myerr.ErrInvalidQuery, errors.New("limit must be 1-100")), http.StatusBadRequest,
+			"invalid item query: limit must be 1-100", "",
+		},
+		{
+			"unavailable hides the cause", myerr.Wrap(myerr.ErrCatalogUnavailable, errors.New("dial tcp: refused")), http.StatusBadGateway,
+			"catalog unavailable", "",
+		},
+		{"busy", myerr.ErrServiceBusy, http.StatusServiceUnavailable, "service busy", "1"},
+		{"permanent is internal", myerr.ErrInvalidMessage, http.StatusInternalServerError, "Internal server error", ""},
+		{"unknown is internal", errors.New("connection reset"), http.StatusInternalServerError, "Internal server error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/users/u1/lists/l1", nil)
+
+			handler{}.fail(w, r, tt.err)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, problemContentType)
+			}
+			if ra := w.Header().Get("Retry-After"); ra != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", ra, tt.retryAfter)
+			}
+
+			var p problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatalf("Failed to decode problem: %s", err)
+			}
+
+			want := problem{
+				Type:     problemType,
+				Title:    http.StatusText(tt.status),
+				Status:   tt.status,
+				Detail:   tt.detail,
+				Instance: "/users/u1/lists/l1",
+			}
+			if p != want {
+				t.Errorf("problem = %+v, want %+v", p, want)
+			}
+		})
+	}
+}
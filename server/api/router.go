@@ -1,18 +1,23 @@
 package api
 
 import (
+	"encoding/json"
+	"expvar"
 	"github.com/gorilla/mux"
 	_ "github.com/pejovski/wish-list/app/statik"
 	"github.com/pejovski/wish-list/controller"
 	"github.com/rakyll/statik/fs"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"os"
 )
 
 type Router interface {
 	routes()
 	swagger()
 	health()
+	metrics()
+	admin()
 
 	ServeHTTP(w http.ResponseWriter, r *http.Request)
 }
@@ -20,15 +25,24 @@ type Router interface {
 type router struct {
 	router  *mux.Router
 	handler Handler
+	checks  []HealthCheck
+
+	// adminToken guards the admin routes, they are off without it
+	adminToken string
 }
 
-func newRouter(c controller.Controller) Router {
+func newRouter(c controller.Controller, checks []HealthCheck) Router {
 	s := &router{
 		router:  mux.NewRouter(),
 		handler: newHandler(c),
+		checks:  checks,
+
+		adminToken: os.Getenv("ADMIN_TOKEN"),
 	}
 
 	s.health()
+	s.metrics()
+	s.admin()
 	s.swagger()
 	s.routes()
 
@@ -43,6 +57,34 @@ func (rtr *router) routes() {
 	rtr.router.HandleFunc("/wish-list/{user_id}", rtr.handler.GetList()).Methods("GET")
 	rtr.router.HandleFunc("/wish-list/{user_id}", rtr.handler.AddItem()).Methods("POST")
 	rtr.router.HandleFunc("/wish-list/{user_id}/{product_id}", rtr.handler.RemoveItem()).Methods("DELETE")
+	rtr.router.HandleFunc("/wish-list/{user_id}/{product_id}", rtr.handler.UpdateItemDetails()).Methods("PATCH")
+
+	rtr.router.HandleFunc("/wish-list/{user_id}/{product_id}/alert", rtr.handler.SetAlertRule()).Methods("PUT")
+	rtr.router.HandleFunc("/wish-list/{user_id}/{product_id}/alert", rtr.handler.RemoveAlertRule()).Methods("DELETE")
+
+	rtr.router.HandleFunc("/users/{user_id}/lists", rtr.handler.GetLists()).Methods("GET")
+	rtr.router.HandleFunc("/users/{user_id}/lists", rtr.handler.CreateList()).Methods("POST")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}", rtr.handler.GetList()).Methods("GET")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}", rtr.handler.RenameList()).Methods("PUT")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}", rtr.handler.DeleteList()).Methods("DELETE")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/items", rtr.handler.AddItem()).Methods("POST")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/items/{product_id}", rtr.handler.RemoveItem()).Methods("DELETE")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/items/{product_id}", rtr.handler.UpdateItemDetails()).Methods("PATCH")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/items/{product_id}/alert", rtr.handler.SetAlertRule()).Methods("PUT")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/items/{product_id}/alert", rtr.handler.RemoveAlertRule()).Methods("DELETE")
+
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/shares", rtr.handler.GetShares()).Methods("GET")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/shares", rtr.handler.ShareList()).Methods("POST")
+	rtr.router.HandleFunc("/users/{user_id}/lists/{list_id}/shares/{token}", rtr.handler.RevokeShare()).Methods("DELETE")
+
+	rtr.router.HandleFunc("/users/{user_id}/alerts", rtr.handler.GetAlerts()).Methods("GET")
+
+	rtr.router.HandleFunc("/products/{product_id}/price-history", rtr.handler.GetPriceHistory()).Methods("GET")
+
+	rtr.router.HandleFunc("/shared/{token}", rtr.handler.GetSharedList()).Methods("GET")
+	rtr.router.HandleFunc("/shared/{token}/items/{product_id}/reservation", rtr.handler.ReserveItem()).Methods("POST")
+	rtr.router.HandleFunc("/shared/{token}/items/{product_id}/reservation/{claim_id}", rtr.handler.ReleaseItem()).Methods("DELETE")
+	rtr.router.HandleFunc("/shared/{token}/items/{product_id}/reservation/{claim_id}/purchase", rtr.handler.PurchaseItem()).Methods("POST")
 }
 
 func (rtr *router) swagger() {
@@ -57,9 +99,30 @@ func (rtr *router) swagger() {
 	rtr.router.PathPrefix("/swagger").Handler(sh)
 }
 
+// health reports the state of the dependencies, the service keeps serving while one is down
 func (rtr *router) health() {
 	rtr.router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("Up"))
+		status := http.StatusOK
+		res := health{Status: healthUp, Components: map[string]string{}}
+
+		for _, c := range rtr.checks {
+			res.Components[c.Name] = healthUp
+			if !c.Up() {
+				res.Components[c.Name] = healthDown
+				res.Status = healthDegraded
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			logrus.Errorf("Failed to encode health. Error: %s", err)
+		}
 	}).Methods("GET")
 }
+
+// metrics serves the expvars, e.g. the outbox lag, as JSON
+func (rtr *router) metrics() {
+	rtr.router.Handle("/metrics", expvar.Handler()).Methods("GET")
+}
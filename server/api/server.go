@@ -20,8 +20,9 @@ type server struct {
 	router Router
 }
 
-func NewServer(c controller.Controller) srv.Server {
-	return server{router: newRouter(c)}
+// NewServer serves the API, the checks are reported by the health endpoint
+func NewServer(c controller.Controller, checks ...HealthCheck) srv.Server {
+	return server{router: newRouter(c, checks)}
 }
 
 func (s server) Run(ctx context.Context) {